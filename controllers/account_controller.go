@@ -1,72 +1,107 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/mathis-k/bank-api/middleware"
 	"github.com/mathis-k/bank-api/models"
 	"github.com/mathis-k/bank-api/utils"
+	"io"
 	"net/http"
 )
 
 func (s *APIServer) GetAccounts(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaimsFromContext(r)
 	if !ok {
-		utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
 		return
 	}
 
-	accounts, err := s.Database.GetAccountsFromUser(claims.User_Id)
+	accounts, err := s.Database.GetAccountsFromUser(r.Context(), claims.User_Id)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusPreconditionFailed, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
-	utils.ResponseMessage(w, http.StatusOK, accounts)
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAccountResponses(accounts))
 }
 func (s *APIServer) GetAccountByNumber(w http.ResponseWriter, r *http.Request) {
 	account := r.Context().Value("account").(*models.Account)
-	utils.ResponseMessage(w, http.StatusOK, account)
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAccountResponse(account))
 }
 func (s *APIServer) CreateAccount(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaimsFromContext(r)
 	if !ok {
-		utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
 		return
 	}
 
-	account, err := s.Database.CreateAccount()
+	var accountRequest models.AccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&accountRequest); err != nil && !errors.Is(err, io.EOF) {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateAccountRequest(&accountRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	account, err := s.Database.CreateAccount(r.Context(), accountRequest.Currency)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	err = s.Database.AddAccountToUser(claims.User_Id, account.ID)
+	err = s.Database.AddAccountToUser(r.Context(), claims.User_Id, account.ID)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	utils.ResponseMessage(w, http.StatusCreated, account)
+	utils.ResponseMessage(w, r, http.StatusCreated, models.NewAccountResponse(account))
 
 }
 func (s *APIServer) DeleteAccount(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaimsFromContext(r)
 	if !ok {
-		utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
 		return
 	}
 
 	account := r.Context().Value("account").(*models.Account)
 
-	err := s.Database.RemoveAccountFromUser(claims.User_Id, account.ID)
+	err := s.Database.RemoveAccountFromUser(r.Context(), claims.User_Id, account.ID)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	err = s.Database.DeleteAccount(account.ID)
+	err = s.Database.DeleteAccount(r.Context(), account.ID)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	utils.ResponseMessage(w, http.StatusNoContent, `{"Success": "Account deleted"}`)
+	utils.ResponseMessage(w, r, http.StatusNoContent, `{"Success": "Account deleted"}`)
+}
+
+// accountByNumber resolves an account number or IBAN. A number without a valid check digit is only accepted when it
+// is the legacy number of a renumbered account, otherwise the parse error is returned.
+func (s *APIServer) accountByNumber(ctx context.Context, number string) (*models.Account, error) {
+	accountNumber, err := models.ParseAccountNumber(number)
+	if errors.Is(err, utils.INVALID_ACCOUNT_NUMBER) {
+		legacyAccountNumber, ok := models.ParseLegacyAccountNumber(number)
+		if !ok {
+			return nil, err
+		}
+		account, legacyErr := s.Database.GetAccountByLegacyAccountNumber(ctx, legacyAccountNumber)
+		if errors.Is(legacyErr, utils.ACCOUNT_NOT_FOUND) {
+			return nil, err
+		}
+		return account, legacyErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Database.GetAccountByAccountNumber(ctx, accountNumber)
 }
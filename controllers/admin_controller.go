@@ -0,0 +1,351 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const AUDIT_REASON_PARAM = "reason"
+
+func BootstrapAdmin(ctx context.Context, store models.Store) {
+	email := strings.TrimSpace(os.Getenv("ADMIN_EMAIL"))
+	if email == "" {
+		return
+	}
+	user, err := store.GetUserByEmail(ctx, email)
+	if err != nil {
+		slog.Warn("ADMIN_EMAIL does not belong to a registered user yet", "email", email)
+		return
+	}
+	if !user.EmailVerified {
+		slog.Warn("ADMIN_EMAIL is not verified, not granting the admin role", "email", email)
+		return
+	}
+	if user.EffectiveRole() == models.RoleAdmin {
+		return
+	}
+	if err := store.SetUserRole(ctx, user.ID, models.RoleAdmin); err != nil {
+		slog.Error("could not grant the admin role", "email", email, "error", err)
+		return
+	}
+	slog.Info("granted the admin role", "email", email)
+}
+
+func (s *APIServer) audit(r *http.Request, action models.AuditAction, target string, reason string) error {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		return utils.INVALID_TOKEN
+	}
+	entry := &models.AuditEntry{
+		ActorID:   claims.User_Id,
+		ActorRole: claims.EffectiveRole(),
+		Action:    action,
+		Target:    target,
+		Reason:    reason,
+		IP:        utils.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		CreatedAt: time.Now(),
+	}
+	if err := s.Database.CreateAuditEntry(r.Context(), entry); err != nil {
+		utils.Logger(r.Context()).Error("could not write audit entry", "action", action, "target", target, "actor_id", claims.User_Id.Hex(), "error", err)
+		return err
+	}
+	utils.Logger(r.Context()).Info("admin action", "action", action, "target", target, "actor_id", claims.User_Id.Hex(), "actor_role", claims.EffectiveRole(), "reason", reason)
+	return nil
+}
+
+func (s *APIServer) auditRead(w http.ResponseWriter, r *http.Request, action models.AuditAction, target string) bool {
+	request := models.AdminActionRequest{Reason: r.URL.Query().Get(AUDIT_REASON_PARAM)}
+	if err := models.ValidateAdminActionRequest(&request); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return false
+	}
+	if err := s.audit(r, action, target, request.Reason); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return false
+	}
+	return true
+}
+
+func queryLimit(r *http.Request, fallback int, max int) (int64, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return int64(fallback), nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > max {
+		return 0, fmt.Errorf("%w: limit must be between 1 and %d", utils.INVALID_QUERY_PARAMETER, max)
+	}
+	return int64(limit), nil
+}
+
+func (s *APIServer) AdminAccountMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account, err := s.accountByNumber(r.Context(), mux.Vars(r)["number"])
+		if err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), "account", account)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *APIServer) adminUserFromRequest(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userId, ok := mux.Vars(r)["id"]
+	if !ok {
+		utils.ErrorMessage(w, r, utils.MISSING_USER_ID)
+		return nil, false
+	}
+	uId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		utils.ErrorMessage(w, r, utils.INVALID_USER_ID)
+		return nil, false
+	}
+	user, err := s.Database.GetUserById(r.Context(), uId)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func (s *APIServer) AdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryLimit(r, models.DefaultUserSearchLimit, models.MaxUserSearchLimit)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if !s.auditRead(w, r, models.AuditSearchUsers, "query:"+query) {
+		return
+	}
+
+	users, err := s.Database.SearchUsers(r.Context(), query, limit)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	s.writeUsers(w, r, users)
+}
+
+func (s *APIServer) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !s.auditRead(w, r, models.AuditViewUser, "user:"+user.ID.Hex()) {
+		return
+	}
+	s.writeUser(w, r, http.StatusOK, user)
+}
+
+func (s *APIServer) AdminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+	var roleUpdate models.RoleUpdate
+	if err := json.NewDecoder(r.Body).Decode(&roleUpdate); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateRoleUpdate(&roleUpdate); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	user, ok := s.adminUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == claims.User_Id {
+		utils.ErrorMessage(w, r, utils.CANNOT_CHANGE_OWN_ROLE)
+		return
+	}
+
+	target := fmt.Sprintf("user:%s role:%s->%s", user.ID.Hex(), user.EffectiveRole(), roleUpdate.Role)
+	if err := s.audit(r, models.AuditChangeRole, target, roleUpdate.Reason); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Database.SetUserRole(r.Context(), user.ID, roleUpdate.Role); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Database.RevokeUserRefreshTokens(r.Context(), user.ID); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Denylist.RevokeUser(r.Context(), user.ID); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	user.Role = roleUpdate.Role
+	s.writeUser(w, r, http.StatusOK, user)
+}
+
+func (s *APIServer) AdminGetAccount(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value("account").(*models.Account)
+	if !s.auditRead(w, r, models.AuditViewAccount, fmt.Sprintf("account:%d", account.AccountNumber)) {
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAccountResponse(account))
+}
+
+func (s *APIServer) AdminGetAccountTransactions(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value("account").(*models.Account)
+	if !s.auditRead(w, r, models.AuditViewTransactions, fmt.Sprintf("account:%d", account.AccountNumber)) {
+		return
+	}
+	s.findTransactions(w, r, []primitive.ObjectID{account.ID})
+}
+
+func (s *APIServer) AdminFreezeAccount(w http.ResponseWriter, r *http.Request) {
+	s.setAccountFrozen(w, r, true)
+}
+
+func (s *APIServer) AdminUnfreezeAccount(w http.ResponseWriter, r *http.Request) {
+	s.setAccountFrozen(w, r, false)
+}
+
+func (s *APIServer) setAccountFrozen(w http.ResponseWriter, r *http.Request, frozen bool) {
+	account := r.Context().Value("account").(*models.Account)
+	var actionRequest models.AdminActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&actionRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateAdminActionRequest(&actionRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if account.Frozen == frozen {
+		if frozen {
+			utils.ErrorMessage(w, r, utils.ACCOUNT_ALREADY_FROZEN)
+		} else {
+			utils.ErrorMessage(w, r, utils.ACCOUNT_NOT_FROZEN)
+		}
+		return
+	}
+
+	action := models.AuditFreezeAccount
+	if !frozen {
+		action = models.AuditUnfreezeAccount
+	}
+	if err := s.audit(r, action, fmt.Sprintf("account:%d", account.AccountNumber), actionRequest.Reason); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Database.SetAccountFrozen(r.Context(), account.ID, frozen); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	account.Frozen = frozen
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAccountResponse(account))
+}
+
+func (s *APIServer) AdminGetTransaction(w http.ResponseWriter, r *http.Request) {
+	tId, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorMessage(w, r, utils.INVALID_TRANSACTION_ID)
+		return
+	}
+	transaction, err := s.Database.GetTransactionById(r.Context(), tId)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if !s.auditRead(w, r, models.AuditViewTransaction, "transaction:"+transaction.ID.Hex()) {
+		return
+	}
+	s.writeTransaction(w, r, http.StatusOK, transaction)
+}
+
+func (s *APIServer) AdminReverseTransaction(w http.ResponseWriter, r *http.Request) {
+	tId, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorMessage(w, r, utils.INVALID_TRANSACTION_ID)
+		return
+	}
+	var reversalRequest models.ReversalRequest
+	if err := json.NewDecoder(r.Body).Decode(&reversalRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateReversalRequest(&reversalRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	transaction, err := s.Database.GetTransactionById(r.Context(), tId)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.checkAmountTOTP(r, transaction.Amount, transaction.Currency); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.audit(r, models.AuditReverseTransaction, "transaction:"+transaction.ID.Hex(), reversalRequest.Reason); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	reversal, err := s.Database.ReverseTransaction(r.Context(), tId, reversalRequest.Reason)
+	if err != nil {
+		if errors.Is(err, utils.CANNOT_REVERSE_REVERSAL) || errors.Is(err, utils.REVERSAL_INSUFFICIENT_FUNDS) || errors.Is(err, utils.ACCOUNT_NOT_FOUND) {
+			err = utils.WithStatus(err, http.StatusUnprocessableEntity)
+		}
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	s.writeTransaction(w, r, http.StatusCreated, reversal)
+}
+
+func (s *APIServer) AdminGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	limit, err := queryLimit(r, models.DefaultAuditLogLimit, models.MaxAuditLogLimit)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	query := &models.AuditLogQuery{
+		Action: models.AuditAction(params.Get("action")),
+		Target: params.Get("target"),
+		Limit:  limit,
+	}
+	if actor := params.Get("actor"); actor != "" {
+		actorId, err := primitive.ObjectIDFromHex(actor)
+		if err != nil {
+			utils.ErrorMessage(w, r, fmt.Errorf("%w: actor must be a user id", utils.INVALID_QUERY_PARAMETER))
+			return
+		}
+		query.ActorID = &actorId
+	}
+	if !s.auditRead(w, r, models.AuditViewAuditLog, "") {
+		return
+	}
+
+	entries, err := s.Database.GetAuditLog(r.Context(), query)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAuditEntryResponses(entries))
+}
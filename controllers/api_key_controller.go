@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mathis-k/bank-api/mailer"
+	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strings"
+)
+
+func checkAPIKeyScopes(user *models.User, scopes []models.Scope) error {
+	if user.EffectiveRole() != models.RoleCustomer {
+		return nil
+	}
+	for _, scope := range scopes {
+		if scope == models.ScopeAdminRead || scope == models.ScopeAdminWrite {
+			return fmt.Errorf("%w: scope %s requires a staff role", utils.INVALID_API_KEY_REQUEST, scope)
+		}
+	}
+	return nil
+}
+
+func checkAdminAPIKeyScopes(user *models.User, scopes []models.Scope) error {
+	if err := checkAPIKeyScopes(user, scopes); err != nil {
+		return err
+	}
+	if user.EffectiveRole() != models.RoleCustomer {
+		return nil
+	}
+	for _, scope := range scopes {
+		if !scope.ReadOnly() {
+			return fmt.Errorf("%w: keys created for customers can only have read scopes, %s is not allowed", utils.INVALID_API_KEY_REQUEST, scope)
+		}
+	}
+	return nil
+}
+
+func apiKeyIdFromRequest(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	kId, err := primitive.ObjectIDFromHex(mux.Vars(r)["keyId"])
+	if err != nil {
+		utils.ErrorMessage(w, r, utils.INVALID_API_KEY_ID)
+		return primitive.NilObjectID, false
+	}
+	return kId, true
+}
+
+func (s *APIServer) createAPIKey(w http.ResponseWriter, r *http.Request, user *models.User, createdBy primitive.ObjectID, request *models.APIKeyRequest) (*models.APIKey, string, bool) {
+	if err := checkAPIKeyScopes(user, request.Scopes); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return nil, "", false
+	}
+	apiKey, key, err := models.NewAPIKey(user.ID, createdBy, request)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return nil, "", false
+	}
+	if err := s.Database.CreateAPIKey(r.Context(), apiKey); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return nil, "", false
+	}
+	s.authEvent(r, models.APIKeyCreated, &user.ID, user.Email, apiKey.Prefix)
+	utils.Logger(r.Context()).Info("API key created", "key_prefix", apiKey.Prefix, "user_id", user.ID.Hex(), "created_by", createdBy.Hex())
+	return apiKey, key, true
+}
+
+func (s *APIServer) sendAPIKey(user *models.User, apiKey *models.APIKey, key string) error {
+	scopes := make([]string, len(apiKey.Scopes))
+	for i, scope := range apiKey.Scopes {
+		scopes[i] = string(scope)
+	}
+	return s.Mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "Your new API key",
+		Body: fmt.Sprintf("Hello %s %s,\n\nour staff created the API key %q with the scopes %s for you:\n\n%s\n\n"+
+			"The key is only sent in this email, store it securely. If you did not expect it, revoke it with "+
+			"DELETE /api/user/api-keys/%s and contact us.\n",
+			user.FirstName, user.LastName, apiKey.Name, strings.Join(scopes, ", "), key, apiKey.ID.Hex()),
+	})
+}
+
+func (s *APIServer) revokeAPIKey(w http.ResponseWriter, r *http.Request, user *models.User, kId primitive.ObjectID) (*models.APIKey, bool) {
+	apiKey, err := s.Database.RevokeAPIKey(r.Context(), user.ID, kId)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return nil, false
+	}
+	s.authEvent(r, models.APIKeyRevoked, &user.ID, user.Email, apiKey.Prefix)
+	utils.Logger(r.Context()).Info("API key revoked", "key_prefix", apiKey.Prefix, "user_id", user.ID.Hex())
+	return apiKey, true
+}
+
+func (s *APIServer) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+	var request models.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateAPIKeyRequest(&request); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	apiKey, key, ok := s.createAPIKey(w, r, user, user.ID, &request)
+	if !ok {
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusCreated, &models.CreatedAPIKeyResponse{
+		APIKeyResponse: models.NewAPIKeyResponse(apiKey),
+		Key:            key,
+	})
+}
+
+func (s *APIServer) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+	keys, err := s.Database.GetAPIKeys(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAPIKeyResponses(keys))
+}
+
+func (s *APIServer) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+	kId, ok := apiKeyIdFromRequest(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	apiKey, ok := s.revokeAPIKey(w, r, user, kId)
+	if !ok {
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAPIKeyResponse(apiKey))
+}
+
+func (s *APIServer) AdminCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+	var request models.AdminAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateAdminAPIKeyRequest(&request); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	user, ok := s.adminUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := checkAdminAPIKeyScopes(user, request.Scopes); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	target := fmt.Sprintf("user:%s api_key:%s", user.ID.Hex(), request.Name)
+	if err := s.audit(r, models.AuditCreateAPIKey, target, request.Reason); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	apiKey, key, ok := s.createAPIKey(w, r, user, claims.User_Id, &request.APIKeyRequest)
+	if !ok {
+		return
+	}
+	if err := s.sendAPIKey(user, apiKey, key); err != nil {
+		if _, revokeErr := s.Database.RevokeAPIKey(r.Context(), user.ID, apiKey.ID); revokeErr != nil {
+			utils.Logger(r.Context()).Error("could not revoke undelivered API key", "key_prefix", apiKey.Prefix, "error", revokeErr)
+		}
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusCreated, models.NewAPIKeyResponse(apiKey))
+}
+
+func (s *APIServer) AdminGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !s.auditRead(w, r, models.AuditViewAPIKeys, "user:"+user.ID.Hex()) {
+		return
+	}
+	keys, err := s.Database.GetAPIKeys(r.Context(), user.ID)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAPIKeyResponses(keys))
+}
+
+func (s *APIServer) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var actionRequest models.AdminActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&actionRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateAdminActionRequest(&actionRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	kId, ok := apiKeyIdFromRequest(w, r)
+	if !ok {
+		return
+	}
+	user, ok := s.adminUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+	keys, err := s.Database.GetAPIKeys(r.Context(), user.ID)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	var apiKey *models.APIKey
+	for _, key := range keys {
+		if key.ID == kId && key.RevokedAt == nil {
+			apiKey = key
+		}
+	}
+	if apiKey == nil {
+		utils.ErrorMessage(w, r, utils.API_KEY_NOT_FOUND)
+		return
+	}
+	target := fmt.Sprintf("user:%s api_key:%s", user.ID.Hex(), apiKey.Prefix)
+	if err := s.audit(r, models.AuditRevokeAPIKey, target, actionRequest.Reason); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	apiKey, ok = s.revokeAPIKey(w, r, user, kId)
+	if !ok {
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAPIKeyResponse(apiKey))
+}
@@ -1,72 +1,230 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/mathis-k/bank-api/metrics"
 	"github.com/mathis-k/bank-api/middleware"
 	"github.com/mathis-k/bank-api/models"
 	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
 	"net/http"
+	"time"
 )
 
 func (s *APIServer) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var userRequest models.UserRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&userRequest); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
 	if err := models.ValidateUserRequest(&userRequest); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
-	user, err := s.Database.CreateUser(&userRequest)
+	user, err := s.Database.CreateUser(r.Context(), &userRequest)
 	if err != nil {
 		if errors.Is(err, utils.EMAIL_ALREADY_EXISTS) {
-			utils.ErrorMessage(w, http.StatusConflict, utils.EMAIL_ALREADY_EXISTS)
+			utils.ErrorMessage(w, r, utils.EMAIL_ALREADY_EXISTS)
 			return
 		}
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
+	if err := s.sendUserToken(r.Context(), user, models.EmailVerification); err != nil {
+		utils.Logger(r.Context()).Error("could not send verification email", "user_id", user.ID.Hex(), "error", err)
+	}
 
-	utils.ResponseMessage(w, http.StatusCreated, user)
+	s.writeUser(w, r, http.StatusCreated, user)
 }
 
 func (s *APIServer) LoginUser(w http.ResponseWriter, r *http.Request) {
 	var userLogin models.UserLogin
 	if err := json.NewDecoder(r.Body).Decode(&userLogin); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
 	if err := models.ValidateUserLogin(&userLogin); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	user, err := s.Database.LoginUser(&userLogin)
+	if !s.checkLoginLockout(w, r, userLogin.Email, nil) {
+		return
+	}
+	user, err := s.Database.LoginUser(r.Context(), &userLogin)
 	if err != nil {
 		if errors.Is(err, utils.INVALID_CREDENTIALS) {
-			utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_CREDENTIALS)
+			s.recordLoginFailure(r, userLogin.Email, nil, "invalid credentials")
+			utils.ErrorMessage(w, r, utils.INVALID_CREDENTIALS)
 			return
 		}
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	if user.TOTPEnabled() {
+		mfaToken, err := middleware.GenerateMFAJWT(user.ID)
+		if err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+		metrics.Logins.WithLabelValues("mfa_required").Inc()
+		utils.ResponseMessage(w, r, http.StatusOK, MFAResponse{
+			Message:     "Two-factor authentication required, send the mfa_token and a code from your authenticator app to /api/auth/login/totp",
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(middleware.MFATokenTTL().Seconds()),
+		})
+		return
+	}
+
+	s.recordLoginSuccess(r, user)
+	response, err := s.newSession(r.Context(), user)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, response)
+}
+
+func (s *APIServer) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var refreshRequest RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&refreshRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if refreshRequest.RefreshToken == "" {
+		utils.ErrorMessage(w, r, utils.MISSING_REFRESH_TOKEN)
 		return
 	}
 
-	token, err := middleware.GenerateUserJWT(user.ID)
+	next, nextString, err := models.NewRefreshToken(primitive.NilObjectID, middleware.RefreshTokenTTL())
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	current, err := s.Database.RotateRefreshToken(r.Context(), models.HashRefreshToken(refreshRequest.RefreshToken), next)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	user, err := s.Database.GetUserById(r.Context(), current.UserID)
+	if err != nil {
+		utils.ErrorMessage(w, r, utils.INVALID_REFRESH_TOKEN)
+		return
+	}
+	response, err := newTokenResponse(user, nextString)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, response)
+}
+
+func (s *APIServer) LogoutUser(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+
+	var refreshRequest RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&refreshRequest); err != nil && !errors.Is(err, io.EOF) {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if refreshRequest.RefreshToken != "" {
+		err := s.Database.RevokeRefreshToken(r.Context(), claims.User_Id, models.HashRefreshToken(refreshRequest.RefreshToken))
+		if err != nil && !errors.Is(err, utils.INVALID_REFRESH_TOKEN) {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+	}
+	if err := s.Denylist.RevokeToken(r.Context(), claims); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	utils.ResponseMessage(w, r, http.StatusOK, map[string]string{"Success": "Logged out"})
+}
+
+func (s *APIServer) LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
 		return
 	}
 
-	info := fmt.Sprintf("Welcome %s %s, your session/token is valid for %s. "+
+	if err := s.Database.RevokeUserRefreshTokens(r.Context(), claims.User_Id); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Denylist.RevokeToken(r.Context(), claims); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Denylist.RevokeUser(r.Context(), claims.User_Id); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	utils.ResponseMessage(w, r, http.StatusOK, map[string]string{"Success": "Logged out on all devices"})
+}
+
+func (s *APIServer) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.ResponseMessage(w, r, http.StatusOK, s.Keys.JWKS(time.Now()))
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenResponse struct {
+	Message      string `json:"Message,omitempty"`
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (s *APIServer) newSession(ctx context.Context, user *models.User) (*TokenResponse, error) {
+	refreshToken, refreshTokenString, err := models.NewRefreshToken(user.ID, middleware.RefreshTokenTTL())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Database.CreateRefreshToken(ctx, refreshToken); err != nil {
+		return nil, err
+	}
+
+	response, err := newTokenResponse(user, refreshTokenString)
+	if err != nil {
+		return nil, err
+	}
+	response.Message = fmt.Sprintf("Welcome %s %s, your session/token is valid for %s. "+
 		"Please make sure to enter your token in the authorization-header: "+
-		"Authorization: Bearer <token>",
-		user.FirstName, user.LastName, utils.FormatDuration(middleware.EXPIRATION_TIME_USER))
-	msg := map[string]string{"Message": info, "token": token}
-	utils.ResponseMessage(w, http.StatusOK, msg)
+		"Authorization: Bearer <token>. Use the refresh_token at /api/auth/refresh to get a new one.",
+		user.FirstName, user.LastName, utils.FormatDuration(middleware.AccessTokenTTL()))
+	return response, nil
+}
+
+func newTokenResponse(user *models.User, refreshToken string) (*TokenResponse, error) {
+	token, err := middleware.GenerateUserJWT(user.ID, user.EffectiveRole())
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		Token:        token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(middleware.AccessTokenTTL().Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
 }
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+	IDEMPOTENCY_KEY_TTL    = time.Hour * 24
+	IDEMPOTENCY_KEY_LOCK   = time.Minute
+)
+
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (s *APIServer) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			utils.ErrorMessage(w, r, utils.INVALID_IDEMPOTENCY_KEY)
+			return
+		}
+
+		claims, ok := middleware.GetClaimsFromContext(r)
+		if !ok {
+			utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		now := time.Now()
+		reservation := &models.IdempotencyKey{
+			UserID:      claims.User_Id,
+			Key:         key,
+			RequestHash: requestHash,
+			CreatedAt:   now,
+			LockedUntil: now.Add(s.IdempotencyKeyLock),
+			ExpiresAt:   now.Add(s.IdempotencyKeyTTL),
+		}
+		existing, err := s.Database.ReserveIdempotencyKey(r.Context(), reservation)
+		if err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.RequestHash != requestHash:
+				utils.ErrorMessage(w, r, utils.IDEMPOTENCY_KEY_MISMATCH)
+			case !existing.Completed && existing.TransactionID != nil:
+				s.replayTransaction(w, r, *existing.TransactionID)
+			case !existing.Completed:
+				utils.ErrorMessage(w, r, utils.IDEMPOTENCY_KEY_IN_USE)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.StatusCode)
+				if _, err := w.Write(existing.Response); err != nil {
+					return
+				}
+			}
+			return
+		}
+
+		recorder := &recordingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(models.WithIdempotencyKey(r.Context(), reservation)))
+
+		ctx := context.WithoutCancel(r.Context())
+		if recorder.statusCode >= 200 && recorder.statusCode < 300 {
+			err = s.Database.CompleteIdempotencyKey(ctx, reservation, recorder.statusCode, recorder.body.Bytes())
+		} else {
+			err = s.Database.ReleaseIdempotencyKey(ctx, reservation)
+		}
+		if err != nil {
+			utils.Logger(r.Context()).Warn("error storing idempotency key", "user_id", claims.User_Id.Hex(), "error", err)
+		}
+	})
+}
+
+// replayTransaction answers a retry whose transaction was committed, but whose response could not be stored.
+func (s *APIServer) replayTransaction(w http.ResponseWriter, r *http.Request, tId primitive.ObjectID) {
+	transaction, err := s.Database.GetTransactionById(r.Context(), tId)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	s.writeTransaction(w, r, http.StatusCreated, transaction)
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/mathis-k/bank-api/metrics"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	LOGIN_MAX_ATTEMPTS        = 5
+	LOGIN_MAX_ATTEMPTS_PER_IP = 20
+	LOGIN_ATTEMPT_WINDOW      = time.Minute * 15
+	LOGIN_LOCKOUT_BASE        = time.Minute
+	LOGIN_LOCKOUT_MAX         = time.Hour
+	AUTH_EVENTS_LIMIT         = 50
+)
+
+func loginPolicyFromEnv(maxAttemptsKey string, maxAttempts int) models.LoginPolicy {
+	return models.LoginPolicy{
+		MaxAttempts: utils.GetEnvInt(maxAttemptsKey, maxAttempts),
+		Window:      utils.GetEnvDuration("LOGIN_ATTEMPT_WINDOW", LOGIN_ATTEMPT_WINDOW),
+		BaseLockout: utils.GetEnvDuration("LOGIN_LOCKOUT_BASE", LOGIN_LOCKOUT_BASE),
+		MaxLockout:  utils.GetEnvDuration("LOGIN_LOCKOUT_MAX", LOGIN_LOCKOUT_MAX),
+	}
+}
+
+func (s *APIServer) loginLockout(r *http.Request, email string) (time.Duration, error) {
+	var lockout time.Duration
+	now := time.Now()
+	for _, key := range []string{models.EmailAttemptKey(email), models.IPAttemptKey(utils.ClientIP(r))} {
+		attempt, err := s.Database.GetLoginAttempt(r.Context(), key)
+		if err != nil {
+			return 0, err
+		}
+		if remaining, locked := attempt.Locked(now); locked && remaining > lockout {
+			lockout = remaining
+		}
+	}
+	return lockout, nil
+}
+
+func (s *APIServer) recordLoginFailure(r *http.Request, email string, uId *primitive.ObjectID, detail string) {
+	if uId == nil {
+		if user, err := s.Database.GetUserByEmail(r.Context(), email); err == nil {
+			uId = &user.ID
+		}
+	}
+	s.authEvent(r, models.LoginFailed, uId, email, detail)
+	metrics.Logins.WithLabelValues("failed").Inc()
+
+	attempts := []struct {
+		key    string
+		policy models.LoginPolicy
+	}{
+		{models.EmailAttemptKey(email), s.LoginPolicy},
+		{models.IPAttemptKey(utils.ClientIP(r)), s.IPLoginPolicy},
+	}
+	for _, attempt := range attempts {
+		recorded, err := s.Database.RecordLoginFailure(r.Context(), attempt.key, attempt.policy)
+		if err != nil {
+			utils.Logger(r.Context()).Warn("could not record failed login", "attempt_key", attempt.key, "error", err)
+			continue
+		}
+		if recorded.LockedUntil != nil {
+			lockout := recorded.LockedUntil.Sub(recorded.LastFailure).Round(time.Second)
+			utils.Logger(r.Context()).Warn("locked logins", "attempt_key", attempt.key, "lockout", lockout, "failures", recorded.Failures)
+			s.authEvent(r, models.LoginLocked, uId, email,
+				fmt.Sprintf("%s locked for %s after %d failed attempts", attempt.key, lockout, recorded.Failures))
+		}
+	}
+}
+
+func (s *APIServer) recordLoginSuccess(r *http.Request, user *models.User) {
+	if err := s.Database.ResetLoginAttempts(r.Context(), models.EmailAttemptKey(user.Email)); err != nil {
+		utils.Logger(r.Context()).Warn("could not reset failed logins", "user_id", user.ID.Hex(), "error", err)
+	}
+	s.authEvent(r, models.LoginSucceeded, &user.ID, user.Email, "")
+	metrics.Logins.WithLabelValues("succeeded").Inc()
+}
+
+func (s *APIServer) authEvent(r *http.Request, eventType models.AuthEventType, uId *primitive.ObjectID, email string, detail string) {
+	err := s.Database.CreateAuthEvent(r.Context(), &models.AuthEvent{
+		Type:      eventType,
+		UserID:    uId,
+		Email:     email,
+		IP:        utils.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		utils.Logger(r.Context()).Warn("could not record auth event", "event", eventType, "error", err)
+	}
+}
+
+func (s *APIServer) checkLoginLockout(w http.ResponseWriter, r *http.Request, email string, uId *primitive.ObjectID) bool {
+	lockout, err := s.loginLockout(r, email)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return false
+	}
+	if lockout > 0 {
+		s.authEvent(r, models.LoginBlocked, uId, email, fmt.Sprintf("locked for another %s", lockout.Round(time.Second)))
+		metrics.Logins.WithLabelValues("locked").Inc()
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(lockout.Seconds()))))
+		utils.ErrorMessage(w, r, utils.TOO_MANY_LOGIN_ATTEMPTS)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"net/http"
+)
+
+func (s *APIServer) writeUser(w http.ResponseWriter, r *http.Request, code int, user *models.User) {
+	accountNumbers, err := s.Database.GetAccountNumbers(r.Context(), user.Accounts)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, code, models.NewUserResponse(user, accountNumbers))
+}
+
+func (s *APIServer) writeUsers(w http.ResponseWriter, r *http.Request, users []*models.User) {
+	accountNumbers, err := s.Database.GetAccountNumbers(r.Context(), models.UserAccountIDs(users...))
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewUserResponses(users, accountNumbers))
+}
+
+func (s *APIServer) transactionResponses(ctx context.Context, transactions []*models.Transaction) ([]*models.TransactionResponse, error) {
+	accountNumbers, err := s.Database.GetAccountNumbers(ctx, models.TransactionAccountIDs(transactions...))
+	if err != nil {
+		return nil, err
+	}
+	return models.NewTransactionResponses(transactions, accountNumbers), nil
+}
+
+func (s *APIServer) writeTransaction(w http.ResponseWriter, r *http.Request, code int, transaction *models.Transaction) {
+	responses, err := s.transactionResponses(r.Context(), []*models.Transaction{transaction})
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, code, responses[0])
+}
+
+func (s *APIServer) writeTransactionPage(w http.ResponseWriter, r *http.Request, page *models.TransactionPage) {
+	transactions, err := s.transactionResponses(r.Context(), page.Transactions)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, &models.TransactionPageResponse{
+		Transactions: transactions,
+		NextCursor:   page.NextCursor,
+		PrevCursor:   page.PrevCursor,
+	})
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
+	"time"
+)
+
+const SCHEDULER_INTERVAL = time.Minute
+
+type Scheduler struct {
+	server   *APIServer
+	interval time.Duration
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+func NewScheduler(server *APIServer, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		server:   server,
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (sc *Scheduler) Start() {
+	slog.Info("standing order scheduler started", "interval", sc.interval)
+	go func() {
+		defer close(sc.done)
+		ticker := time.NewTicker(sc.interval)
+		defer ticker.Stop()
+		for {
+			sc.RunDue(time.Now())
+			select {
+			case <-ticker.C:
+			case <-sc.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (sc *Scheduler) Stop() {
+	close(sc.quit)
+	<-sc.done
+	slog.Info("standing order scheduler stopped")
+}
+
+func (sc *Scheduler) RunDue(now time.Time) {
+	for {
+		ctx, cancel := sc.context()
+		order, err := sc.server.Database.ClaimDueStandingOrder(ctx, now)
+		cancel()
+		if err != nil {
+			slog.Warn("error claiming due standing order", "error", err)
+			return
+		}
+		if order == nil {
+			return
+		}
+		if !sc.execute(order, now) {
+			return
+		}
+	}
+}
+
+func (sc *Scheduler) context() (context.Context, context.CancelFunc) {
+	if sc.server.RequestTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), sc.server.RequestTimeout)
+}
+
+func (sc *Scheduler) execute(order *models.StandingOrder, now time.Time) bool {
+	ctx, cancel := sc.context()
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "Scheduler.ExecuteStandingOrder", attribute.String("standing_order.id", order.ID.Hex()))
+	defer span.End()
+
+	execution := &models.StandingOrderExecution{
+		ID:           primitive.NewObjectID(),
+		OrderID:      order.ID,
+		ScheduledFor: *order.NextRunAt,
+		ExecutedAt:   now,
+	}
+	_, err := sc.server.executeStandingOrder(ctx, order, execution)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, utils.STANDING_ORDER_ALREADY_EXECUTED) {
+		slog.Warn("standing order was already executed", "order_id", order.ID.Hex(), "scheduled_for", execution.ScheduledFor)
+		return true
+	}
+	execution.Status = models.ExecutionFailed
+	execution.TransactionID = nil
+	execution.Error = utils.ErrorCode(err)
+	tracing.RecordError(span, err)
+	slog.Warn("standing order failed", "order_id", order.ID.Hex(), "error", err)
+
+	if err := sc.server.Database.RecordStandingOrderExecution(ctx, order, execution); err != nil {
+		if errors.Is(err, utils.STANDING_ORDER_ALREADY_EXECUTED) {
+			return true
+		}
+		slog.Warn("error recording standing order execution", "order_id", order.ID.Hex(), "error", err)
+		return false
+	}
+	return true
+}
+
+func (s *APIServer) executeStandingOrder(ctx context.Context, order *models.StandingOrder, execution *models.StandingOrderExecution) (*models.Transaction, error) {
+	from, err := s.Database.GetAccountById(ctx, order.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.Database.GetAccountByAccountNumber(ctx, order.ToAccountNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionRequest := &models.TransactionRequest{
+		Type:        models.Transfer,
+		Amount:      order.Amount,
+		Currency:    order.Currency,
+		FromAccount: from.ID,
+		ToAccountID: to.ID,
+	}
+	if err := checkTransactionCurrency(transactionRequest, from); err != nil {
+		return nil, err
+	}
+	if err := s.applyExchangeRate(transactionRequest, from, to); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateTransactionRequest(transactionRequest); err != nil {
+		return nil, err
+	}
+	if err := s.checkTransactionLimit(transactionRequest.Amount, transactionRequest.Currency); err != nil {
+		return nil, err
+	}
+	transaction, err := s.Database.ExecuteStandingOrder(ctx, order, transactionRequest, execution)
+	if !errors.Is(err, utils.STANDING_ORDER_ALREADY_EXECUTED) {
+		observeTransaction(transactionRequest, err)
+	}
+	return transaction, err
+}
@@ -1,45 +1,195 @@
 package controllers
 
 import (
+	"context"
+	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
+	"github.com/mathis-k/bank-api/fx"
+	"github.com/mathis-k/bank-api/mailer"
+	"github.com/mathis-k/bank-api/middleware"
 	"github.com/mathis-k/bank-api/models"
-	"log"
+	"github.com/mathis-k/bank-api/utils"
+	"log/slog"
 	"net/http"
 	"os"
+	"sync"
+	"time"
 )
 
+const REQUEST_TIMEOUT = 30 * time.Second
+
 type APIServer struct {
 	ListenAddress string
-	Database      *models.DB
+	Database      models.Store
+	Rates         fx.RateProvider
+	Mailer        mailer.Mailer
+
+	IdempotencyKeyTTL  time.Duration
+	IdempotencyKeyLock time.Duration
+	RequestTimeout     time.Duration
+	Scheduler          *Scheduler
+	Denylist           *middleware.Denylist
+	Keys               *middleware.KeySet
+
+	TOTPTransferThreshold models.Money
+	LoginPolicy           models.LoginPolicy
+	IPLoginPolicy         models.LoginPolicy
+	PasswordResetPolicy   models.LoginPolicy
+	IPPasswordResetPolicy models.LoginPolicy
+
+	background sync.WaitGroup
 }
 
 func NewAPIServer() *APIServer {
 
-	if err := godotenv.Load(); err != nil {
-		log.Println("✖ No .env file found")
+	err := godotenv.Load()
+	utils.ConfigureLogging()
+	if err != nil {
+		slog.Error("no .env file found")
 		return &APIServer{}
 	}
 	listenAddress := os.Getenv("API_SERVER_ADDRESS")
 	if listenAddress == "" {
-		log.Fatal("✖ API_SERVER_ADDRESS environment variable not set")
+		fatal("API_SERVER_ADDRESS environment variable not set")
+	}
+
+	rates, err := fx.NewProviderFromEnv()
+	if err != nil {
+		fatal("could not load exchange rates", "error", err)
+	}
+	mail, err := mailer.NewMailerFromEnv()
+	if err != nil {
+		fatal("could not configure mailer", "error", err)
+	}
+
+	slog.Info("new API server created", "address", listenAddress)
+	ctx := context.Background()
+	var store models.Store
+	if os.Getenv("STORAGE") == "memory" {
+		slog.Info("using in-memory storage, data will be lost on shutdown")
+		store = models.NewMemoryStore()
+	} else {
+		database := &models.DB{}
+		if err := database.Connect(ctx); err != nil {
+			fatal("could not connect to database", "error", err)
+		}
+		if err := database.Migrate(ctx); err != nil {
+			fatal("could not migrate database", "error", err)
+		}
+		store = database
+	}
+	BootstrapAdmin(ctx, store)
+
+	server := NewAPIServerWithStore(listenAddress, store)
+	server.Rates = rates
+	server.Mailer = mail
+	if os.Getenv("SCHEDULER_ENABLED") != "false" {
+		server.Scheduler = NewScheduler(server, utils.GetEnvDuration("SCHEDULER_INTERVAL", SCHEDULER_INTERVAL))
+	}
+	return server
+}
+
+func NewAPIServerWithStore(listenAddress string, store models.Store) *APIServer {
+	keys, err := middleware.NewKeySetFromEnv()
+	if err != nil {
+		fatal("could not load JWT signing keys", "error", err)
+	}
+	middleware.UseKeySet(keys)
+
+	threshold, err := totpTransferThreshold()
+	if err != nil {
+		fatal("invalid TOTP_TRANSFER_THRESHOLD", "error", err)
 	}
-	database := &models.DB{}
 
-	log.Println("✔ New API server created on address:", listenAddress)
-	if err := database.Connect(); err != nil {
-		panic("✖ Could not connect to database")
+	rates, _ := fx.NewTableProvider(models.DefaultCurrency(), nil)
+	denylist := middleware.NewDenylist(store, utils.GetEnvDuration("DENYLIST_SYNC_INTERVAL", middleware.DENYLIST_SYNC_INTERVAL))
+	if err := denylist.Sync(context.Background()); err != nil {
+		slog.Warn("could not load token denylist", "error", err)
 	}
+	middleware.UseDenylist(denylist)
+	middleware.UseAPIKeyStore(store)
+
 	return &APIServer{
 		ListenAddress: listenAddress,
-		Database:      database,
+		Database:      store,
+		Rates:         rates,
+		Mailer:        mailer.NewStdoutMailer(mailer.DEFAULT_MAIL_FROM),
+
+		IdempotencyKeyTTL:  utils.GetEnvDuration("IDEMPOTENCY_KEY_TTL", IDEMPOTENCY_KEY_TTL),
+		IdempotencyKeyLock: utils.GetEnvDuration("IDEMPOTENCY_KEY_LOCK", IDEMPOTENCY_KEY_LOCK),
+		RequestTimeout:     utils.GetEnvDuration("REQUEST_TIMEOUT", REQUEST_TIMEOUT),
+		Denylist:           denylist,
+		Keys:               keys,
+
+		TOTPTransferThreshold: threshold,
+		LoginPolicy:           loginPolicyFromEnv("LOGIN_MAX_ATTEMPTS", LOGIN_MAX_ATTEMPTS),
+		IPLoginPolicy:         loginPolicyFromEnv("LOGIN_MAX_ATTEMPTS_PER_IP", LOGIN_MAX_ATTEMPTS_PER_IP),
+		PasswordResetPolicy:   passwordResetPolicyFromEnv("PASSWORD_RESET_MAX_REQUESTS", PASSWORD_RESET_MAX_REQUESTS),
+		IPPasswordResetPolicy: passwordResetPolicyFromEnv("PASSWORD_RESET_MAX_REQUESTS_PER_IP", PASSWORD_RESET_MAX_REQUESTS_PER_IP),
 	}
 }
 
+func (s *APIServer) runInBackground(ctx context.Context, task func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	cancel := context.CancelFunc(func() {})
+	if s.RequestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.RequestTimeout)
+	}
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer cancel()
+		task(ctx)
+	}()
+}
+
+func (s *APIServer) WaitForBackgroundTasks() {
+	s.background.Wait()
+}
+
 func (s *APIServer) HandleStartPage(w http.ResponseWriter, r *http.Request) {
-	r.Context()
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("https://github.com/mathis-k/bank-api")); err != nil {
 		return
 	}
 }
+
+func (s *APIServer) CheckAccountPermissionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetClaimsFromContext(r)
+		if !ok {
+			utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+			return
+		}
+
+		user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+		if err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+
+		vars := mux.Vars(r)
+		accountNumber_str, ok := vars["number"]
+		if !ok {
+			utils.ErrorMessage(w, r, utils.MISSING_ACCOUNT_NUMBER)
+			return
+		}
+		account, err := s.accountByNumber(r.Context(), accountNumber_str)
+		if err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+		if !user.HasAccount(account.ID) {
+			utils.ErrorMessage(w, r, utils.ACCOUNT_NOT_FOUND)
+			return
+		}
+		ctx := context.WithValue(r.Context(), "account", account)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+)
+
+func (s *APIServer) GetStandingOrders(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value("account").(*models.Account)
+
+	orders, err := s.Database.GetStandingOrdersFromAccount(r.Context(), account.ID)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewStandingOrderResponses(orders))
+}
+func (s *APIServer) CreateStandingOrder(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value("account").(*models.Account)
+
+	var orderRequest models.StandingOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&orderRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateStandingOrderRequest(&orderRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	if orderRequest.Currency == "" {
+		orderRequest.Currency = account.Currency
+	}
+	currency, err := models.ParseCurrency(string(orderRequest.Currency))
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if currency != account.Currency {
+		utils.ErrorMessage(w, r, utils.CURRENCY_MISMATCH)
+		return
+	}
+	if err := currency.ValidateAmount(orderRequest.Amount); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	orderRequest.Currency = currency
+	if err := s.checkTransactionLimit(orderRequest.Amount, orderRequest.Currency); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.checkAmountTOTP(r, orderRequest.Amount, orderRequest.Currency); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	order, err := s.Database.CreateStandingOrder(r.Context(), account.ID, &orderRequest)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusCreated, models.NewStandingOrderResponse(order))
+}
+func (s *APIServer) GetStandingOrderById(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.standingOrderFromRequest(w, r)
+	if !ok {
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewStandingOrderResponse(order))
+}
+func (s *APIServer) UpdateStandingOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.standingOrderFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var orderUpdate models.StandingOrderUpdate
+	if err := json.NewDecoder(r.Body).Decode(&orderUpdate); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateStandingOrderUpdate(&orderUpdate); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if orderUpdate.Amount != 0 {
+		if err := order.Currency.ValidateAmount(orderUpdate.Amount); err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+		if err := s.checkTransactionLimit(orderUpdate.Amount, order.Currency); err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+		if err := s.checkAmountTOTP(r, orderUpdate.Amount, order.Currency); err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+	}
+
+	updated, err := s.Database.UpdateStandingOrder(r.Context(), order.ID, &orderUpdate)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewStandingOrderResponse(updated))
+}
+func (s *APIServer) DeleteStandingOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.standingOrderFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.Database.DeleteStandingOrder(r.Context(), order.ID); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusNoContent, `{"Success": "Standing order deleted"}`)
+}
+func (s *APIServer) GetStandingOrderExecutions(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.standingOrderFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	executions, err := s.Database.GetStandingOrderExecutions(r.Context(), order.ID)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewStandingOrderExecutionResponses(executions))
+}
+
+func (s *APIServer) standingOrderFromRequest(w http.ResponseWriter, r *http.Request) (*models.StandingOrder, bool) {
+	account := r.Context().Value("account").(*models.Account)
+
+	vars := mux.Vars(r)
+	orderId, ok := vars["id"]
+	if !ok {
+		utils.ErrorMessage(w, r, utils.MISSING_STANDING_ORDER_ID)
+		return nil, false
+	}
+	oId, err := primitive.ObjectIDFromHex(orderId)
+	if err != nil {
+		utils.ErrorMessage(w, r, utils.INVALID_STANDING_ORDER_ID)
+		return nil, false
+	}
+
+	order, err := s.Database.GetStandingOrderById(r.Context(), oId)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return nil, false
+	}
+	if order.AccountID != account.ID {
+		utils.ErrorMessage(w, r, utils.STANDING_ORDER_NOT_FOUND)
+		return nil, false
+	}
+	return order, true
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/statement"
+	"github.com/mathis-k/bank-api/utils"
+	"net/http"
+	"time"
+)
+
+func (s *APIServer) GetAccountStatement(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value("account").(*models.Account)
+	params := r.URL.Query()
+
+	var format statement.Format
+	var err error
+	if f := params.Get("format"); f != "" {
+		if format, err = statement.ParseFormat(f); err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+	} else if format, err = statement.Negotiate(r.Header.Get("Accept")); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := now
+	if value := params.Get("from_date"); value != "" {
+		if from, _, err = parseQueryDate(value); err != nil {
+			utils.ErrorMessage(w, r, fmt.Errorf("%w: from_date must be RFC 3339 or YYYY-MM-DD", utils.INVALID_QUERY_PARAMETER))
+			return
+		}
+	}
+	if value := params.Get("to_date"); value != "" {
+		var dateOnly bool
+		if to, dateOnly, err = parseQueryDate(value); err != nil {
+			utils.ErrorMessage(w, r, fmt.Errorf("%w: to_date must be RFC 3339 or YYYY-MM-DD", utils.INVALID_QUERY_PARAMETER))
+			return
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+	}
+	if !from.Before(to) {
+		utils.ErrorMessage(w, r, utils.INVALID_DATE_RANGE)
+		return
+	}
+
+	accountStatement, err := s.Database.GetStatement(r.Context(), account, from, to)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := statement.Write(&body, format, accountStatement); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	if format != statement.JSON {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, format.Filename(accountStatement)))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = body.WriteTo(w)
+}
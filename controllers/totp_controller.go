@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	TOTP_CODE_HEADER            = "X-TOTP-Code"
+	DEFAULT_TOTP_ISSUER         = "Bank API"
+	DEFAULT_TOTP_TRANSFER_LIMIT = "1000.00"
+)
+
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFAResponse struct {
+	Message     string `json:"Message"`
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return DEFAULT_TOTP_ISSUER
+}
+
+func totpTransferThreshold() (models.Money, error) {
+	threshold := os.Getenv("TOTP_TRANSFER_THRESHOLD")
+	if threshold == "" {
+		threshold = DEFAULT_TOTP_TRANSFER_LIMIT
+	}
+	return models.ParseMoney(threshold)
+}
+
+func (s *APIServer) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if user.TOTPEnabled() {
+		utils.ErrorMessage(w, r, utils.TOTP_ALREADY_ENABLED)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	recoveryCodes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	hashes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = utils.HashRecoveryCode(code)
+	}
+	if err := s.Database.SetTOTPSecret(r.Context(), user.ID, secret, hashes); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	utils.ResponseMessage(w, r, http.StatusCreated, TOTPEnrollment{
+		Secret:        secret,
+		URI:           utils.TOTPURI(totpIssuer(), user.Email, secret),
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+func (s *APIServer) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+	var totpRequest models.TOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&totpRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateTOTPRequest(&totpRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if user.TOTP == nil || user.TOTP.Enabled {
+		utils.ErrorMessage(w, r, utils.TOTP_NOT_ENROLLED)
+		return
+	}
+	counter, ok := utils.ValidateTOTP(user.TOTP.Secret, totpRequest.Code, time.Now())
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOTP_CODE)
+		return
+	}
+	if err := s.Database.EnableTOTP(r.Context(), user.ID, counter); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	utils.Logger(r.Context()).Info("two-factor authentication enabled", "user_id", user.ID.Hex())
+	utils.ResponseMessage(w, r, http.StatusOK, map[string]string{"Success": "Two-factor authentication enabled"})
+}
+
+func (s *APIServer) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+	var totpRequest models.TOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&totpRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateTOTPRequest(&totpRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if !user.TOTPEnabled() {
+		utils.ErrorMessage(w, r, utils.TOTP_NOT_ENABLED)
+		return
+	}
+	if err := s.verifyTOTP(r.Context(), user, totpRequest.Code, true); err != nil {
+		if errors.Is(err, utils.INVALID_TOTP_CODE) || errors.Is(err, utils.TOTP_CODE_REUSED) {
+			utils.ErrorMessage(w, r, utils.WithStatus(err, http.StatusForbidden))
+			return
+		}
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Database.DisableTOTP(r.Context(), user.ID); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	utils.Logger(r.Context()).Warn("two-factor authentication disabled", "user_id", user.ID.Hex())
+	utils.ResponseMessage(w, r, http.StatusOK, map[string]string{"Success": "Two-factor authentication disabled"})
+}
+
+func (s *APIServer) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	var loginRequest models.TOTPLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateTOTPLoginRequest(&loginRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	claims, err := middleware.VerifyMFAJWT(r.Context(), loginRequest.MFAToken)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, utils.INVALID_MFA_TOKEN)
+		return
+	}
+	if !user.TOTPEnabled() {
+		utils.ErrorMessage(w, r, utils.TOTP_NOT_ENABLED)
+		return
+	}
+	if !s.checkLoginLockout(w, r, user.Email, &user.ID) {
+		return
+	}
+	if err := s.verifyTOTP(r.Context(), user, loginRequest.Code, true); err != nil {
+		if errors.Is(err, utils.INVALID_TOTP_CODE) || errors.Is(err, utils.TOTP_CODE_REUSED) {
+			s.recordLoginFailure(r, user.Email, &user.ID, "invalid two-factor code")
+			utils.ErrorMessage(w, r, utils.WithStatus(err, http.StatusUnauthorized))
+			return
+		}
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Denylist.RevokeToken(r.Context(), claims); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	s.recordLoginSuccess(r, user)
+	response, err := s.newSession(r.Context(), user)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	utils.ResponseMessage(w, r, http.StatusOK, response)
+}
+
+func (s *APIServer) verifyTOTP(ctx context.Context, user *models.User, code string, allowRecoveryCode bool) error {
+	if counter, ok := utils.ValidateTOTP(user.TOTP.Secret, code, time.Now()); ok {
+		return s.Database.UseTOTPCounter(ctx, user.ID, counter)
+	}
+	if !allowRecoveryCode {
+		return utils.INVALID_TOTP_CODE
+	}
+	if err := s.Database.UseRecoveryCode(ctx, user.ID, utils.HashRecoveryCode(code)); err != nil {
+		return err
+	}
+	utils.Logger(ctx).Warn("two-factor recovery code used", "user_id", user.ID.Hex())
+	return nil
+}
+
+func (s *APIServer) requiresTransferTOTP(amount models.Money, currency models.Currency) bool {
+	base := models.DefaultCurrency()
+	if currency != base {
+		rate, err := s.Rates.Rate(currency, base)
+		if err != nil {
+			return true
+		}
+		if amount, err = rate.Convert(amount, base); err != nil {
+			return true
+		}
+	}
+	return amount > s.TOTPTransferThreshold
+}
+
+func (s *APIServer) checkTransferTOTP(r *http.Request, transactionRequest *models.TransactionRequest) error {
+	return s.checkAmountTOTP(r, transactionRequest.Amount, transactionRequest.Currency)
+}
+
+func (s *APIServer) checkAmountTOTP(r *http.Request, amount models.Money, currency models.Currency) error {
+	if !s.requiresTransferTOTP(amount, currency) {
+		return nil
+	}
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		return utils.INVALID_TOKEN
+	}
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled() {
+		return utils.TOTP_SETUP_REQUIRED
+	}
+	code := r.Header.Get(TOTP_CODE_HEADER)
+	if code == "" {
+		return utils.TOTP_REQUIRED
+	}
+	if err := s.verifyTOTP(r.Context(), user, code, false); err != nil {
+		if errors.Is(err, utils.INVALID_TOTP_CODE) || errors.Is(err, utils.TOTP_CODE_REUSED) {
+			return utils.WithStatus(err, http.StatusForbidden)
+		}
+		return err
+	}
+	return nil
+}
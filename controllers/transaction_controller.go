@@ -1,157 +1,391 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/mathis-k/bank-api/metrics"
 	"github.com/mathis-k/bank-api/middleware"
 	"github.com/mathis-k/bank-api/models"
 	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+var transactionAmountBuckets = []struct {
+	limit models.Money
+	label string
+}{
+	{10_00, "0-10"},
+	{100_00, "10-100"},
+	{1000_00, "100-1000"},
+}
+
 func (s *APIServer) GetTransactions(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaimsFromContext(r)
 	if !ok {
-		utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
 		return
 	}
 
-	user, err := s.Database.GetUserById(claims.User_Id)
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusPreconditionFailed, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	var transactions []models.Transaction
-	for _, aId := range user.Accounts {
-		transactions_, err := s.Database.GetTransactionsFromAccount(aId)
-		if err != nil {
-			utils.ErrorMessage(w, http.StatusInternalServerError, err)
-			return
-		}
-		for _, t := range transactions_ {
-			transactions = append(transactions, *t)
-		}
-	}
-
-	utils.ResponseMessage(w, http.StatusOK, transactions)
+	s.findTransactions(w, r, user.Accounts)
 }
 func (s *APIServer) GetTransactionById(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaimsFromContext(r)
 	if !ok {
-		utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
 		return
 	}
 
 	vars := mux.Vars(r)
 	transactionId, ok := vars["id"]
 	if !ok {
-		utils.ErrorMessage(w, http.StatusBadRequest, utils.MISSING_TRANSACTION_ID)
+		utils.ErrorMessage(w, r, utils.MISSING_TRANSACTION_ID)
 		return
 	}
-
-	transactions, err := s.Database.GetTransactionsFromUser(claims.User_Id)
+	tId, err := primitive.ObjectIDFromHex(transactionId)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		utils.ErrorMessage(w, r, utils.INVALID_TRANSACTION_ID)
 		return
 	}
 
-	for _, t := range transactions {
-		if t.ID.Hex() == transactionId {
-			utils.ResponseMessage(w, http.StatusOK, t)
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	transaction, err := s.Database.GetTransactionById(r.Context(), tId)
+	if err != nil {
+		if errors.Is(err, utils.TRANSACTION_NOT_FOUND) {
+			utils.ErrorMessage(w, r, utils.TRANSACTION_NOT_FOUND)
 			return
 		}
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if !user.HasAccount(transaction.FromAccount) && !user.HasAccount(transaction.ToAccount) {
+		utils.ErrorMessage(w, r, utils.TRANSACTION_NOT_FOUND)
+		return
 	}
 
-	utils.ErrorMessage(w, http.StatusNotFound, utils.TRANSACTION_NOT_FOUND)
+	s.writeTransaction(w, r, http.StatusOK, transaction)
 }
 func (s *APIServer) GetTransactionsFromAccount(w http.ResponseWriter, r *http.Request) {
 	account := r.Context().Value("account").(*models.Account)
-	transactions, err := s.Database.GetTransactionsFromAccount(account.ID)
-	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	utils.ResponseMessage(w, http.StatusOK, transactions)
+	s.findTransactions(w, r, []primitive.ObjectID{account.ID})
 }
 func (s *APIServer) DepositToAccount(w http.ResponseWriter, r *http.Request) {
 	account := r.Context().Value("account").(*models.Account)
 
 	var transactionRequest models.TransactionRequest
 	if err := json.NewDecoder(r.Body).Decode(&transactionRequest); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 	transactionRequest.Type = "Deposit"
 	transactionRequest.ToAccountID = account.ID
+	if err := checkTransactionCurrency(&transactionRequest, account); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
 	if err := models.ValidateTransactionRequest(&transactionRequest); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.checkTransactionLimit(transactionRequest.Amount, transactionRequest.Currency); err != nil {
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	transaction, err := s.Database.CreateTransaction(&transactionRequest)
+	transaction, err := s.createTransaction(r.Context(), &transactionRequest)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	utils.ResponseMessage(w, http.StatusCreated, transaction)
+	s.writeTransaction(w, r, http.StatusCreated, transaction)
 }
 func (s *APIServer) WithdrawFromAccount(w http.ResponseWriter, r *http.Request) {
 	account := r.Context().Value("account").(*models.Account)
 
 	var transactionRequest models.TransactionRequest
 	if err := json.NewDecoder(r.Body).Decode(&transactionRequest); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 	transactionRequest.Type = "Payout"
 	transactionRequest.FromAccount = account.ID
+	if err := checkTransactionCurrency(&transactionRequest, account); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
 	if err := models.ValidateTransactionRequest(&transactionRequest); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.checkTransactionLimit(transactionRequest.Amount, transactionRequest.Currency); err != nil {
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	transaction, err := s.Database.CreateTransaction(&transactionRequest)
+	transaction, err := s.createTransaction(r.Context(), &transactionRequest)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	utils.ResponseMessage(w, http.StatusCreated, transaction)
+	s.writeTransaction(w, r, http.StatusCreated, transaction)
 }
 func (s *APIServer) TransferBetweenAccounts(w http.ResponseWriter, r *http.Request) {
 	account := r.Context().Value("account").(*models.Account)
 
 	var transactionRequest models.TransactionRequest
 	if err := json.NewDecoder(r.Body).Decode(&transactionRequest); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 	transactionRequest.Type = "Transfer"
 	transactionRequest.FromAccount = account.ID
-	to_account_number, err := utils.StringToUint64(transactionRequest.ToAccount)
-	if err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+	if err := checkTransactionCurrency(&transactionRequest, account); err != nil {
+		utils.ErrorMessage(w, r, err)
 		return
 	}
-	to_account, err := s.Database.GetAccountByAccountNumber(to_account_number)
+	to_account, err := s.accountByNumber(r.Context(), transactionRequest.ToAccount)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if to_account.Frozen {
+		utils.ErrorMessage(w, r, utils.ACCOUNT_FROZEN)
 		return
 	}
 	transactionRequest.ToAccountID = to_account.ID
+	if err := s.applyExchangeRate(&transactionRequest, account, to_account); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
 	if err := models.ValidateTransactionRequest(&transactionRequest); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.checkTransactionLimit(transactionRequest.Amount, transactionRequest.Currency); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.checkTransferTOTP(r, &transactionRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	transaction, err := s.createTransaction(r.Context(), &transactionRequest)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	transaction, err := s.Database.CreateTransaction(&transactionRequest)
+	s.writeTransaction(w, r, http.StatusCreated, transaction)
+}
+func (s *APIServer) findTransactions(w http.ResponseWriter, r *http.Request, accounts []primitive.ObjectID) {
+	query, err := s.parseTransactionQuery(r)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		if !errors.Is(err, utils.ACCOUNT_NOT_FOUND) {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+		accounts = nil
+	}
+	if len(accounts) == 0 {
+		utils.ResponseMessage(w, r, http.StatusOK, &models.TransactionPageResponse{Transactions: []*models.TransactionResponse{}})
 		return
 	}
+	query.Accounts = accounts
 
-	utils.ResponseMessage(w, http.StatusCreated, transaction)
+	page, err := s.Database.FindTransactions(r.Context(), query)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	s.writeTransactionPage(w, r, page)
+}
+func (s *APIServer) parseTransactionQuery(r *http.Request) (*models.TransactionQuery, error) {
+	params := r.URL.Query()
+	query := &models.TransactionQuery{
+		SortBy:     models.SortByCreatedAt,
+		Descending: true,
+		Limit:      models.DefaultPageSize,
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > models.MaxPageSize {
+			return nil, fmt.Errorf("%w: limit must be between 1 and %d", utils.INVALID_QUERY_PARAMETER, models.MaxPageSize)
+		}
+		query.Limit = l
+	}
+	if sort := params.Get("sort"); sort != "" {
+		query.Descending = strings.HasPrefix(sort, "-")
+		query.SortBy = models.TransactionSort(strings.TrimPrefix(sort, "-"))
+		if query.SortBy != models.SortByCreatedAt && query.SortBy != models.SortByAmount {
+			return nil, fmt.Errorf("%w: sort must be one of created_at, -created_at, amount, -amount", utils.INVALID_QUERY_PARAMETER)
+		}
+	}
+	if types := params.Get("type"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			transactionType := models.TransactionType(strings.TrimSpace(t))
+			switch transactionType {
+			case models.Deposit, models.Payout, models.Transfer, models.Reversal:
+				query.Types = append(query.Types, transactionType)
+			default:
+				return nil, fmt.Errorf("%w: unknown transaction type %s", utils.INVALID_QUERY_PARAMETER, strings.TrimSpace(t))
+			}
+		}
+	}
+	for name, target := range map[string]**models.Money{"min_amount": &query.MinAmount, "max_amount": &query.MaxAmount} {
+		if value := params.Get(name); value != "" {
+			amount, err := models.ParseMoney(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", utils.INVALID_QUERY_PARAMETER, name, err)
+			}
+			*target = &amount
+		}
+	}
+	if from := params.Get("from_date"); from != "" {
+		t, _, err := parseQueryDate(from)
+		if err != nil {
+			return nil, fmt.Errorf("%w: from_date must be RFC 3339 or YYYY-MM-DD", utils.INVALID_QUERY_PARAMETER)
+		}
+		query.From = &t
+	}
+	if to := params.Get("to_date"); to != "" {
+		t, dateOnly, err := parseQueryDate(to)
+		if err != nil {
+			return nil, fmt.Errorf("%w: to_date must be RFC 3339 or YYYY-MM-DD", utils.INVALID_QUERY_PARAMETER)
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		query.To = &t
+	}
+	if counterparty := params.Get("counterparty"); counterparty != "" {
+		account, err := s.accountByNumber(r.Context(), counterparty)
+		if errors.Is(err, utils.INVALID_ACCOUNT_NUMBER) || errors.Is(err, utils.INVALID_IBAN) || errors.Is(err, utils.MISSING_ACCOUNT_NUMBER) {
+			return nil, fmt.Errorf("%w: counterparty: %v", utils.INVALID_QUERY_PARAMETER, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		query.Counterparty = &account.ID
+	}
+	if cursor := params.Get("cursor"); cursor != "" {
+		if err := query.ParseCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	return query, nil
+}
+func parseQueryDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+func amountBucket(amount models.Money) string {
+	for _, bucket := range transactionAmountBuckets {
+		if amount < bucket.limit {
+			return bucket.label
+		}
+	}
+	return "1000+"
+}
+
+func transactionMetricType(transactionType models.TransactionType) string {
+	if transactionType == models.Payout {
+		return "withdrawal"
+	}
+	return strings.ToLower(string(transactionType))
+}
+
+func (s *APIServer) createTransaction(ctx context.Context, transactionRequest *models.TransactionRequest) (*models.Transaction, error) {
+	transaction, err := s.Database.CreateTransaction(ctx, transactionRequest)
+	observeTransaction(transactionRequest, err)
+	return transaction, err
+}
+
+func observeTransaction(transactionRequest *models.TransactionRequest, err error) {
+	kind := transactionMetricType(transactionRequest.Type)
+	outcome := "succeeded"
+	switch {
+	case err == nil:
+	case errors.Is(err, utils.INSUFFICIENT_FUNDS):
+		outcome = "insufficient_funds"
+		metrics.InsufficientFunds.WithLabelValues(kind).Inc()
+	case errors.Is(err, utils.ACCOUNT_FROZEN):
+		outcome = "account_frozen"
+	default:
+		outcome = "failed"
+	}
+	metrics.Transactions.WithLabelValues(kind, outcome, amountBucket(transactionRequest.Amount)).Inc()
+}
+
+func checkTransactionCurrency(transactionRequest *models.TransactionRequest, account *models.Account) error {
+	if transactionRequest.Currency == "" {
+		transactionRequest.Currency = account.Currency
+	}
+	currency, err := models.ParseCurrency(string(transactionRequest.Currency))
+	if err != nil {
+		return err
+	}
+	if currency != account.Currency {
+		return utils.CURRENCY_MISMATCH
+	}
+	transactionRequest.Currency = currency
+	return currency.ValidateAmount(transactionRequest.Amount)
+}
+func (s *APIServer) applyExchangeRate(transactionRequest *models.TransactionRequest, from *models.Account, to *models.Account) error {
+	transactionRequest.ToCurrency = to.Currency
+	if from.Currency == to.Currency {
+		transactionRequest.ToAmount = transactionRequest.Amount
+		return nil
+	}
+
+	rate, err := s.Rates.Rate(from.Currency, to.Currency)
+	if err != nil {
+		return err
+	}
+	toAmount, err := rate.Convert(transactionRequest.Amount, to.Currency)
+	if err != nil {
+		return err
+	}
+	transactionRequest.ExchangeRate = rate
+	transactionRequest.ToAmount = toAmount
+	return nil
+}
+func (s *APIServer) checkTransactionLimit(amount models.Money, currency models.Currency) error {
+	base := models.DefaultCurrency()
+	if currency != base {
+		rate, err := s.Rates.Rate(currency, base)
+		if err != nil {
+			return err
+		}
+		if amount, err = rate.Convert(amount, base); err != nil {
+			return err
+		}
+	}
+	if amount > models.MaxTransactionAmount {
+		return utils.TRANSACTION_LIMIT_EXCEEDED
+	}
+	return nil
 }
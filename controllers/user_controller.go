@@ -11,41 +11,120 @@ import (
 func (s *APIServer) GetUser(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaimsFromContext(r)
 	if !ok {
-		utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
 		return
 	}
 
-	user, err := s.Database.GetUserById(claims.User_Id)
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusPreconditionFailed, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	utils.ResponseMessage(w, http.StatusOK, user)
+	s.writeUser(w, r, http.StatusOK, user)
 }
 func (s *APIServer) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaimsFromContext(r)
 	if !ok {
-		utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
 		return
 	}
 
 	var userUpdate models.UserUpdate
 	if err := json.NewDecoder(r.Body).Decode(&userUpdate); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
 	if err := models.ValidateUserUpdate(&userUpdate); err != nil {
-		utils.ErrorMessage(w, http.StatusBadRequest, err)
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	user, err := s.Database.UpdateUser(claims.User_Id, &userUpdate)
+	user, err := s.Database.UpdateUser(r.Context(), claims.User_Id, &userUpdate)
 	if err != nil {
-		utils.ErrorMessage(w, http.StatusInternalServerError, err)
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if userUpdate.Email != "" && !user.EmailVerified {
+		if err := s.sendUserToken(r.Context(), user, models.EmailVerification); err != nil {
+			utils.Logger(r.Context()).Error("could not send verification email", "user_id", user.ID.Hex(), "error", err)
+		}
+	}
+
+	s.writeUser(w, r, http.StatusOK, user)
+}
+func (s *APIServer) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+
+	var changeRequest models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&changeRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateChangePasswordRequest(&changeRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if !s.checkLoginLockout(w, r, user.Email, &user.ID) {
+		return
+	}
+	if !utils.CheckPasswordHash(changeRequest.CurrentPassword, user.Password) {
+		s.recordLoginFailure(r, user.Email, &user.ID, "invalid current password")
+		utils.ErrorMessage(w, r, utils.INVALID_CURRENT_PASSWORD)
+		return
+	}
+
+	password, err := utils.HashPassword(changeRequest.NewPassword)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Database.SetPassword(r.Context(), user.ID, password); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Database.RevokeUserRefreshTokens(r.Context(), user.ID); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Denylist.RevokeUser(r.Context(), user.ID); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	s.authEvent(r, models.PasswordChanged, &user.ID, user.Email, "")
+	utils.Logger(r.Context()).Info("password changed, other sessions revoked", "user_id", user.ID.Hex())
+
+	response, err := s.newSession(r.Context(), user)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	response.Message = "Password changed, all other sessions have been logged out"
+	utils.ResponseMessage(w, r, http.StatusOK, response)
+}
+func (s *APIServer) GetAuthEvents(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+
+	events, err := s.Database.GetAuthEvents(r.Context(), claims.User_Id, AUTH_EVENTS_LIMIT)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
 		return
 	}
 
-	utils.ResponseMessage(w, http.StatusOK, user)
+	utils.ResponseMessage(w, r, http.StatusOK, models.NewAuthEventResponses(events))
 }
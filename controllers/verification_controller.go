@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mathis-k/bank-api/mailer"
+	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	PASSWORD_RESET_TTL                 = time.Hour
+	EMAIL_VERIFICATION_TTL             = time.Hour * 48
+	PASSWORD_RESET_MAX_REQUESTS        = 3
+	PASSWORD_RESET_MAX_REQUESTS_PER_IP = 10
+	PASSWORD_RESET_WINDOW              = time.Hour
+)
+
+func passwordResetPolicyFromEnv(maxRequestsKey string, maxRequests int) models.LoginPolicy {
+	window := utils.GetEnvDuration("PASSWORD_RESET_WINDOW", PASSWORD_RESET_WINDOW)
+	return models.LoginPolicy{
+		MaxAttempts: utils.GetEnvInt(maxRequestsKey, maxRequests),
+		Window:      window,
+		BaseLockout: window,
+		MaxLockout:  window,
+	}
+}
+
+func (s *APIServer) checkPasswordResetLimit(w http.ResponseWriter, r *http.Request, email string) bool {
+	limits := []struct {
+		key    string
+		policy models.LoginPolicy
+	}{
+		{models.PasswordResetAttemptKey(models.EmailAttemptKey(email)), s.PasswordResetPolicy},
+		{models.PasswordResetAttemptKey(models.IPAttemptKey(utils.ClientIP(r))), s.IPPasswordResetPolicy},
+	}
+
+	var lockout time.Duration
+	now := time.Now()
+	for _, limit := range limits {
+		attempt, err := s.Database.GetLoginAttempt(r.Context(), limit.key)
+		if err != nil {
+			utils.ErrorMessage(w, r, err)
+			return false
+		}
+		if remaining, locked := attempt.Locked(now); locked && remaining > lockout {
+			lockout = remaining
+		}
+	}
+	if lockout > 0 {
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(lockout.Seconds()))))
+		utils.ErrorMessage(w, r, utils.TOO_MANY_PASSWORD_RESETS)
+		return false
+	}
+
+	for _, limit := range limits {
+		if _, err := s.Database.RecordLoginFailure(r.Context(), limit.key, limit.policy); err != nil {
+			utils.Logger(r.Context()).Warn("could not record password reset request", "attempt_key", limit.key, "error", err)
+		}
+	}
+	return true
+}
+
+func tokenLink(path string, token string) string {
+	baseURL := strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	if baseURL == "" {
+		return "Token: " + token
+	}
+	return fmt.Sprintf("%s%s?token=%s", baseURL, path, token)
+}
+
+func (s *APIServer) sendUserToken(ctx context.Context, user *models.User, purpose models.UserTokenPurpose) error {
+	var ttl time.Duration
+	var subject, path, intro string
+	switch purpose {
+	case models.PasswordReset:
+		ttl = utils.GetEnvDuration("PASSWORD_RESET_TTL", PASSWORD_RESET_TTL)
+		subject, path = "Reset your password", "/reset-password"
+		intro = "Somebody asked to reset the password of your account. If that was you, use the link below to choose a new one."
+	case models.EmailVerification:
+		ttl = utils.GetEnvDuration("EMAIL_VERIFICATION_TTL", EMAIL_VERIFICATION_TTL)
+		subject, path = "Confirm your email address", "/verify-email"
+		intro = "Please confirm your email address to unlock withdrawals and transfers."
+	}
+
+	token, tokenString, err := models.NewUserToken(user, purpose, ttl)
+	if err != nil {
+		return err
+	}
+	if err := s.Database.CreateUserToken(ctx, token); err != nil {
+		return err
+	}
+	return s.Mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body: fmt.Sprintf("Hello %s %s,\n\n%s\n\n%s\n\nThe link is valid for %s and can only be used once. "+
+			"If you did not request this, you can ignore this email.\n",
+			user.FirstName, user.LastName, intro, tokenLink(path, tokenString), utils.FormatDuration(ttl)),
+	})
+}
+
+func (s *APIServer) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var forgotRequest models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&forgotRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateForgotPasswordRequest(&forgotRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	if !s.checkPasswordResetLimit(w, r, forgotRequest.Email) {
+		return
+	}
+
+	s.runInBackground(r.Context(), func(ctx context.Context) {
+		user, err := s.Database.GetUserByEmail(ctx, forgotRequest.Email)
+		if err != nil {
+			return
+		}
+		if err := s.sendUserToken(ctx, user, models.PasswordReset); err != nil {
+			utils.Logger(ctx).Error("could not send password reset email", "user_id", user.ID.Hex(), "error", err)
+		}
+	})
+
+	utils.ResponseMessage(w, r, http.StatusAccepted, map[string]string{"Success": "If an account with this email exists, a reset link has been sent"})
+}
+
+func (s *APIServer) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var resetRequest models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&resetRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateResetPasswordRequest(&resetRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	token, err := s.Database.ConsumeUserToken(r.Context(), models.PasswordReset, models.HashRefreshToken(resetRequest.Token))
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	user, err := s.Database.GetUserById(r.Context(), token.UserID)
+	if err != nil || user.Email != token.Email {
+		utils.ErrorMessage(w, r, utils.INVALID_USER_TOKEN)
+		return
+	}
+
+	password, err := utils.HashPassword(resetRequest.Password)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Database.SetPassword(r.Context(), user.ID, password); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if !user.EmailVerified {
+		if err := s.Database.MarkEmailVerified(r.Context(), user.ID, token.Email); err != nil {
+			utils.Logger(r.Context()).Warn("could not mark email as verified", "user_id", user.ID.Hex(), "error", err)
+		}
+	}
+	if err := s.Database.RevokeUserRefreshTokens(r.Context(), user.ID); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := s.Denylist.RevokeUser(r.Context(), user.ID); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	s.authEvent(r, models.PasswordRecovered, &user.ID, user.Email, "")
+	utils.Logger(r.Context()).Info("password reset, all sessions revoked", "user_id", user.ID.Hex())
+	utils.ResponseMessage(w, r, http.StatusOK, map[string]string{"Success": "Password has been reset, please log in again"})
+}
+
+func (s *APIServer) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var verifyRequest models.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&verifyRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if err := models.ValidateVerifyEmailRequest(&verifyRequest); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	token, err := s.Database.ConsumeUserToken(r.Context(), models.EmailVerification, models.HashRefreshToken(verifyRequest.Token))
+	if err == nil {
+		err = s.Database.MarkEmailVerified(r.Context(), token.UserID, token.Email)
+	}
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	utils.ResponseMessage(w, r, http.StatusOK, map[string]string{"Success": "Email address verified"})
+}
+
+func (s *APIServer) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r)
+	if !ok {
+		utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+		return
+	}
+	user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+	if err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+	if user.EmailVerified {
+		utils.ErrorMessage(w, r, utils.EMAIL_ALREADY_VERIFIED)
+		return
+	}
+	if err := s.sendUserToken(r.Context(), user, models.EmailVerification); err != nil {
+		utils.ErrorMessage(w, r, err)
+		return
+	}
+
+	utils.ResponseMessage(w, r, http.StatusAccepted, map[string]string{"Success": "Verification email sent to " + user.Email})
+}
+
+func (s *APIServer) RequireVerifiedEmailMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetClaimsFromContext(r)
+		if !ok {
+			utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+			return
+		}
+		user, err := s.Database.GetUserById(r.Context(), claims.User_Id)
+		if err != nil {
+			utils.ErrorMessage(w, r, err)
+			return
+		}
+		if !user.EmailVerified {
+			utils.ErrorMessage(w, r, utils.EMAIL_NOT_VERIFIED)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,104 @@
+package fx
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"math/big"
+	"os"
+	"strings"
+)
+
+type RateProvider interface {
+	Rate(from models.Currency, to models.Currency) (models.Rate, error)
+}
+
+type TableProvider struct {
+	base  models.Currency
+	rates map[models.Currency]*big.Rat
+}
+
+type rateFile struct {
+	Base  string            `json:"base"`
+	Rates map[string]string `json:"rates"`
+}
+
+func NewTableProvider(base models.Currency, rates map[models.Currency]string) (*TableProvider, error) {
+	if !base.IsSupported() {
+		return nil, fmt.Errorf("%w: %s", utils.UNSUPPORTED_CURRENCY, base)
+	}
+	provider := &TableProvider{
+		base:  base,
+		rates: map[models.Currency]*big.Rat{base: big.NewRat(1, 1)},
+	}
+	for currency, rate := range rates {
+		if !currency.IsSupported() {
+			return nil, fmt.Errorf("%w: %s", utils.UNSUPPORTED_CURRENCY, currency)
+		}
+		r, err := models.Rate(rate).Rat()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s=%s", err, currency, rate)
+		}
+		provider.rates[currency] = r
+	}
+	return provider, nil
+}
+
+func (p *TableProvider) Rate(from models.Currency, to models.Currency) (models.Rate, error) {
+	if from == to {
+		return "1", nil
+	}
+	fromRate, ok := p.rates[from]
+	if !ok {
+		return "", utils.EXCHANGE_RATE_NOT_FOUND
+	}
+	toRate, ok := p.rates[to]
+	if !ok {
+		return "", utils.EXCHANGE_RATE_NOT_FOUND
+	}
+	return models.NewRate(new(big.Rat).Quo(toRate, fromRate)), nil
+}
+
+func LoadFileProvider(path string) (*TableProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file rateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	rates := map[models.Currency]string{}
+	for currency, rate := range file.Rates {
+		rates[models.Currency(strings.ToUpper(currency))] = rate
+	}
+	return NewTableProvider(models.Currency(strings.ToUpper(file.Base)), rates)
+}
+
+func ParseConfigProvider(base string, config string) (*TableProvider, error) {
+	rates := map[models.Currency]string{}
+	for _, pair := range strings.Split(config, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		currency, rate, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", utils.INVALID_EXCHANGE_RATE, pair)
+		}
+		rates[models.Currency(strings.ToUpper(strings.TrimSpace(currency)))] = strings.TrimSpace(rate)
+	}
+	return NewTableProvider(models.Currency(strings.ToUpper(base)), rates)
+}
+
+func NewProviderFromEnv() (RateProvider, error) {
+	if path := os.Getenv("FX_RATES_FILE"); path != "" {
+		return LoadFileProvider(path)
+	}
+	base := os.Getenv("FX_BASE_CURRENCY")
+	if base == "" {
+		base = string(models.DefaultCurrency())
+	}
+	return ParseConfigProvider(base, os.Getenv("FX_RATES"))
+}
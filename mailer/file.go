@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+func NewFileMailer(from string, dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	slog.Info("writing outgoing mail", "dir", dir)
+	return &FileMailer{from: from, dir: dir}, nil
+}
+
+func (m *FileMailer) Send(message *Message) error {
+	message = withFrom(message, m.from)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405Z"), primitive.NewObjectID().Hex())
+	return os.WriteFile(filepath.Join(m.dir, name), message.Bytes(), 0o600)
+}
@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const DEFAULT_MAIL_FROM = "Bank API <no-reply@bank-api.local>"
+
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(message *Message) error
+}
+
+func NewMailerFromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = DEFAULT_MAIL_FROM
+	}
+	switch strings.ToLower(os.Getenv("MAILER")) {
+	case "", "stdout":
+		return NewStdoutMailer(from), nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileMailer(from, dir)
+	case "smtp":
+		return NewSMTPMailerFromEnv(from)
+	default:
+		return nil, fmt.Errorf("unknown MAILER %s, expected smtp, stdout or file", os.Getenv("MAILER"))
+	}
+}
+
+func (m *Message) Bytes() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+func withFrom(message *Message, from string) *Message {
+	if message.From != "" {
+		return message
+	}
+	c := *message
+	c.From = from
+	return &c
+}
@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+type StdoutMailer struct {
+	mu   sync.Mutex
+	from string
+	out  io.Writer
+}
+
+func NewStdoutMailer(from string) *StdoutMailer {
+	return &StdoutMailer{from: from, out: os.Stdout}
+}
+
+func (m *StdoutMailer) Send(message *Message) error {
+	message = withFrom(message, m.from)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.out, "----- mail -----\n%s\n----- end mail -----\n", message.Bytes())
+	return err
+}
@@ -1,38 +1,61 @@
 package main
 
 import (
-	"github.com/gorilla/mux"
+	"context"
 	"github.com/mathis-k/bank-api/controllers"
+	"github.com/mathis-k/bank-api/metrics"
+	"github.com/mathis-k/bank-api/middleware"
 	"github.com/mathis-k/bank-api/routes"
-	"log"
+	"github.com/mathis-k/bank-api/tracing"
+	"log/slog"
 	"net/http"
+	"os"
 )
 
 func Shutdown(s *controllers.APIServer) {
-	if err := s.Database.Disconnect(); err != nil {
-		log.Printf("⚠ Error disconnecting from database: %v", err)
+	if s.Scheduler != nil {
+		s.Scheduler.Stop()
 	}
-	log.Println("✔ API server has been shut down.")
+	s.WaitForBackgroundTasks()
+	if err := s.Database.Disconnect(context.Background()); err != nil {
+		slog.Warn("error disconnecting from database", "error", err)
+	}
+	slog.Info("API server has been shut down")
 }
 func Run(s *controllers.APIServer) {
-	router := mux.NewRouter()
-	router.HandleFunc("/api", s.HandleStartPage).Methods(http.MethodGet)
+	router := routes.NewRouter(s)
+	if s.Scheduler != nil {
+		s.Scheduler.Start()
+	}
 
-	routes.RegisterUserRoutes(router, s)
-	routes.RegisterAccountRoutes(router, s)
-	routes.RegisterTransactionRoutes(router, s)
-	routes.RegisterAuthRoutes(router, s)
+	if metrics.Enabled() && metrics.ListenAddress() != "" {
+		go func() {
+			slog.Info("metrics are served", "address", metrics.ListenAddress(), "path", metrics.Path())
+			if err := metrics.ListenAndServe(); err != nil {
+				slog.Error("error whilst serving metrics", "error", err)
+			}
+		}()
+	}
 
-	log.Printf("✔ API server is running on localhost%s/ ... 🚀", s.ListenAddress)
-	err := http.ListenAndServe(s.ListenAddress, router)
+	slog.Info("API server is running", "address", s.ListenAddress)
+	err := http.ListenAndServe(s.ListenAddress, middleware.Instrument(router))
 	if err != nil {
-		log.Println("⚠ Error whilst listening:", err)
-		log.Println("⚠ ... Shutting down server ...")
+		slog.Error("error whilst listening, shutting down server", "error", err)
 		Shutdown(s)
 		return
 	}
 }
 func main() {
 	api := controllers.NewAPIServer()
+	shutdownTracing, err := tracing.NewProviderFromEnv(context.Background())
+	if err != nil {
+		slog.Error("error setting up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Warn("error flushing traces", "error", err)
+		}
+	}()
 	Run(api)
 }
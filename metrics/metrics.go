@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"github.com/mathis-k/bank-api/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	NAMESPACE            = "bank_api"
+	DEFAULT_METRICS_PATH = "/metrics"
+	UNMATCHED_ROUTE      = "unmatched"
+)
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, mux route template and status code.",
+	}, []string{"method", "route", "status"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, mux route template and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	MongoCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "mongo_command_duration_seconds",
+		Help:      "MongoDB command latency by command and collection.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"command", "collection"})
+	MongoCommandErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "mongo_command_errors_total",
+		Help:      "Failed MongoDB commands by command and collection.",
+	}, []string{"command", "collection"})
+
+	Transactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "transactions_total",
+		Help:      "Deposits, withdrawals and transfers by outcome and amount bucket.",
+	}, []string{"type", "outcome", "amount_bucket"})
+	InsufficientFunds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "insufficient_funds_total",
+		Help:      "Withdrawals and transfers rejected because of insufficient funds.",
+	}, []string{"type"})
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "logins_total",
+		Help:      "Login attempts by outcome.",
+	}, []string{"outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPRequestDuration,
+		MongoCommandDuration, MongoCommandErrors,
+		Transactions, InsufficientFunds, Logins,
+	)
+}
+
+func Enabled() bool {
+	return os.Getenv("METRICS_ENABLED") != "false"
+}
+
+func ListenAddress() string {
+	return os.Getenv("METRICS_ADDRESS")
+}
+
+func Protected() bool {
+	return os.Getenv("METRICS_TOKEN") != "" || os.Getenv("METRICS_USERNAME") != ""
+}
+
+func Path() string {
+	if path := os.Getenv("METRICS_PATH"); path != "" {
+		return path
+	}
+	return DEFAULT_METRICS_PATH
+}
+
+func ObserveHTTPRequest(method string, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = UNMATCHED_ROUTE
+	}
+	code := strconv.Itoa(status)
+	HTTPRequests.WithLabelValues(method, route, code).Inc()
+	HTTPRequestDuration.WithLabelValues(method, route, code).Observe(duration.Seconds())
+}
+
+func NewHandlerFromEnv() http.Handler {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+	token := os.Getenv("METRICS_TOKEN")
+	username, password := os.Getenv("METRICS_USERNAME"), os.Getenv("METRICS_PASSWORD")
+	if token == "" && username == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && secureEqual(r.Header.Get("Authorization"), "Bearer "+token) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if username != "" {
+			if u, p, ok := r.BasicAuth(); ok && secureEqual(u, username) && secureEqual(p, password) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		}
+		utils.ErrorMessage(w, r, utils.INVALID_METRICS_CREDENTIALS)
+	})
+}
+
+func ListenAndServe() error {
+	mux := http.NewServeMux()
+	mux.Handle(Path(), NewHandlerFromEnv())
+	return http.ListenAndServe(ListenAddress(), mux)
+}
+
+func secureEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/event"
+	"sync"
+)
+
+type mongoMonitor struct {
+	mu          sync.Mutex
+	collections map[int64]string
+}
+
+func NewMongoMonitor() *event.CommandMonitor {
+	m := &mongoMonitor{collections: map[int64]string{}}
+	return &event.CommandMonitor{
+		Started: m.started,
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			m.finished(&e.CommandFinishedEvent, false)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			m.finished(&e.CommandFinishedEvent, true)
+		},
+	}
+}
+
+func (m *mongoMonitor) started(ctx context.Context, e *event.CommandStartedEvent) {
+	collection, ok := e.Command.Lookup(e.CommandName).StringValueOK()
+	if !ok {
+		collection, _ = e.Command.Lookup("collection").StringValueOK()
+	}
+	m.mu.Lock()
+	m.collections[e.RequestID] = collection
+	m.mu.Unlock()
+}
+
+func (m *mongoMonitor) finished(e *event.CommandFinishedEvent, failed bool) {
+	m.mu.Lock()
+	collection := m.collections[e.RequestID]
+	delete(m.collections, e.RequestID)
+	m.mu.Unlock()
+
+	MongoCommandDuration.WithLabelValues(e.CommandName, collection).Observe(e.Duration.Seconds())
+	if failed {
+		MongoCommandErrors.WithLabelValues(e.CommandName, collection).Inc()
+	}
+}
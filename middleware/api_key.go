@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+const API_KEY_HEADER = "X-API-Key"
+
+type APIKeyStore interface {
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	TouchAPIKey(ctx context.Context, kId primitive.ObjectID, usedAt time.Time) error
+	GetUserById(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+}
+
+var apiKeyStore APIKeyStore
+
+func UseAPIKeyStore(store APIKeyStore) {
+	apiKeyStore = store
+}
+
+func authenticateAPIKey(r *http.Request, key string) (*UserClaims, error) {
+	if apiKeyStore == nil || !models.LooksLikeAPIKey(key) {
+		return nil, utils.INVALID_API_KEY
+	}
+	apiKey, err := apiKeyStore.GetAPIKeyByHash(r.Context(), models.HashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !apiKey.Usable(now) {
+		return nil, utils.INVALID_API_KEY
+	}
+	ip := utils.ClientIP(r)
+	if !apiKey.AllowsIP(ip) {
+		utils.Logger(r.Context()).Warn("API key used from disallowed address", "key_prefix", apiKey.Prefix, "user_id", apiKey.UserID.Hex(), "ip", ip)
+		return nil, utils.API_KEY_IP_NOT_ALLOWED
+	}
+	user, err := apiKeyStore.GetUserById(r.Context(), apiKey.UserID)
+	if err != nil {
+		return nil, utils.INVALID_API_KEY
+	}
+	if apiKey.NeedsTouch(now) {
+		if err := apiKeyStore.TouchAPIKey(r.Context(), apiKey.ID, now); err != nil {
+			utils.Logger(r.Context()).Warn("could not record API key use", "key_prefix", apiKey.Prefix, "error", err)
+		}
+	}
+	return &UserClaims{
+		User_Id: user.ID,
+		Role:    user.EffectiveRole(),
+		Valid:   true,
+		Sub:     user.ID.Hex(),
+		APIKey:  apiKey,
+	}, nil
+}
+
+func RequireScope(scope models.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaimsFromContext(r)
+			if !ok {
+				utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+				return
+			}
+			if !claims.HasScope(scope) {
+				utils.Logger(r.Context()).Warn("API key lacks scope", "key_prefix", claims.APIKey.Prefix, "user_id", claims.User_Id.Hex(), "scope", scope, "method", r.Method, "path", r.URL.Path)
+				utils.ErrorMessage(w, r, utils.MISSING_SCOPE)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (u UserClaims) HasScope(scope models.Scope) bool {
+	if u.APIKey == nil {
+		return true
+	}
+	return scope != models.ScopeSession && u.APIKey.HasScope(scope)
+}
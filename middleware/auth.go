@@ -5,38 +5,83 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/mathis-k/bank-api/models"
 	"github.com/mathis-k/bank-api/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 )
 
-var mySigningKey = os.Getenv("JWT_SECRET")
-
 const (
-	EXPIRATION_TIME_USER = time.Hour * 24
+	EXPIRATION_TIME_USER    = time.Minute * 15
+	EXPIRATION_TIME_REFRESH = time.Hour * 24 * 30
+	EXPIRATION_TIME_MFA     = time.Minute * 5
+	JWT_LEEWAY              = time.Second * 30
+	DEFAULT_JWT_ISSUER      = "bank-api"
+	DEFAULT_JWT_AUDIENCE    = "bank-api"
 )
 
 type UserClaims struct {
 	User_Id primitive.ObjectID `json:"user"`
+	Role    models.Role        `json:"role,omitempty"`
 	Valid   bool               `json:"valid"`
+	Iss     string             `json:"iss"`
+	Sub     string             `json:"sub"`
+	Aud     jwt.ClaimStrings   `json:"aud"`
 	Exp     int64              `json:"exp"`
-	Iat     int64              `json:"iat"`
+	Nbf     int64              `json:"nbf"`
+	Iat     float64            `json:"iat"`
+	Jti     string             `json:"jti"`
+
+	APIKey *models.APIKey `json:"-"`
+}
+
+func AccessTokenTTL() time.Duration {
+	return utils.GetEnvDuration("ACCESS_TOKEN_TTL", EXPIRATION_TIME_USER)
+}
+
+func RefreshTokenTTL() time.Duration {
+	return utils.GetEnvDuration("REFRESH_TOKEN_TTL", EXPIRATION_TIME_REFRESH)
+}
+
+func JWTIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return DEFAULT_JWT_ISSUER
+}
+
+func JWTAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return DEFAULT_JWT_AUDIENCE
 }
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(API_KEY_HEADER); key != "" {
+			claims, err := authenticateAPIKey(r, key)
+			if err != nil {
+				utils.ErrorMessage(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, withClaims(r, claims))
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			utils.ErrorMessage(w, http.StatusUnauthorized, utils.MISSING_AUTH_HEADER)
+			utils.ErrorMessage(w, r, utils.MISSING_AUTH_HEADER)
 			return
 		}
 
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
+			utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
 			return
 		}
 
@@ -44,55 +89,144 @@ func AuthMiddleware(next http.Handler) http.Handler {
 
 		token, err := VerifyJWT(tokenString)
 		if err != nil {
-			if errors.Is(err, utils.TOKEN_EXPIRED) || errors.Is(err, utils.INVALID_TOKEN) {
-				utils.ErrorMessage(w, http.StatusUnauthorized, err)
-			} else {
-				utils.ErrorMessage(w, http.StatusBadRequest, err)
-			}
+			utils.ErrorMessage(w, r, err)
 			return
 		}
 
 		claims, ok := token.Claims.(*UserClaims)
 		if !ok || !token.Valid {
-			utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
+			utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+			return
+		}
+		if denylist != nil && denylist.IsRevoked(r.Context(), claims) {
+			utils.ErrorMessage(w, r, utils.TOKEN_REVOKED)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), "claims", claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, withClaims(r, claims))
 	})
 }
 
-func GenerateUserJWT(uId primitive.ObjectID) (string, error) {
+func withClaims(r *http.Request, claims *UserClaims) *http.Request {
+	setRequestUser(r, claims.User_Id)
+	ctx := context.WithValue(r.Context(), "claims", claims)
+	ctx = utils.WithLogger(ctx, utils.Logger(ctx).With("user_id", claims.User_Id.Hex()))
+	return r.WithContext(ctx)
+}
+
+func RequireRole(roles ...models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaimsFromContext(r)
+			if !ok {
+				utils.ErrorMessage(w, r, utils.INVALID_TOKEN)
+				return
+			}
+			for _, role := range roles {
+				if claims.EffectiveRole() == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			utils.Logger(r.Context()).Warn("access denied", "user_id", claims.User_Id.Hex(), "role", claims.EffectiveRole(), "method", r.Method, "path", r.URL.Path)
+			utils.ErrorMessage(w, r, utils.FORBIDDEN_ROLE)
+		})
+	}
+}
+
+func MFATokenTTL() time.Duration {
+	return utils.GetEnvDuration("MFA_TOKEN_TTL", EXPIRATION_TIME_MFA)
+}
+
+func mfaAudience() string {
+	return JWTAudience() + ":mfa"
+}
+
+func GenerateUserJWT(uId primitive.ObjectID, role models.Role) (string, error) {
+	signedToken, err := generateJWT(uId, role, JWTAudience(), AccessTokenTTL())
+	if err != nil {
+		return "", err
+	}
+	slog.Debug("issued access token", "user_id", uId.Hex(), "ttl", utils.FormatDuration(AccessTokenTTL()))
+	return signedToken, nil
+}
+
+func GenerateMFAJWT(uId primitive.ObjectID) (string, error) {
+	return generateJWT(uId, "", mfaAudience(), MFATokenTTL())
+}
+
+func generateJWT(uId primitive.ObjectID, role models.Role, audience string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	issuedAt := now
+	if denylist != nil {
+		issuedAt = denylist.issuedAt(uId, now)
+	}
 	claims := UserClaims{
 		User_Id: uId,
+		Role:    role,
 		Valid:   true,
-		Exp:     time.Now().Add(EXPIRATION_TIME_USER).Unix(),
-		Iat:     time.Now().Unix(),
+		Iss:     JWTIssuer(),
+		Sub:     uId.Hex(),
+		Aud:     jwt.ClaimStrings{audience},
+		Exp:     now.Add(ttl).Unix(),
+		Nbf:     now.Unix(),
+		Iat:     float64(issuedAt.UnixMilli()) / 1000,
+		Jti:     primitive.NewObjectID().Hex(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	signedToken, err := token.SignedString([]byte(mySigningKey))
+	if keySet == nil {
+		return "", utils.MISSING_SIGNING_KEY
+	}
+	key, err := keySet.SigningKey(now)
 	if err != nil {
 		return "", err
 	}
-	log.Printf("ℹ New JWT token created for user %v (Valid for %s): %v", claims.User_Id, utils.FormatDuration(EXPIRATION_TIME_USER), signedToken)
-	return signedToken, nil
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Kid
+
+	return token.SignedString(key.Private)
 }
 
 func VerifyJWT(signedToken string) (*jwt.Token, error) {
-	token, err := jwt.ParseWithClaims(signedToken, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(mySigningKey), nil
-	})
+	return verifyJWT(signedToken, JWTAudience())
+}
+
+func VerifyMFAJWT(ctx context.Context, signedToken string) (*UserClaims, error) {
+	token, err := verifyJWT(signedToken, mfaAudience())
 	if err != nil {
-		return nil, err
+		return nil, utils.INVALID_MFA_TOKEN
 	}
-	if claims, ok := token.Claims.(*UserClaims); ok && token.Valid {
-		if claims.Exp < time.Now().Unix() {
+	claims := token.Claims.(*UserClaims)
+	if denylist != nil && denylist.IsRevoked(ctx, claims) {
+		return nil, utils.INVALID_MFA_TOKEN
+	}
+	return claims, nil
+}
+
+func verifyJWT(signedToken string, audience string) (*jwt.Token, error) {
+	if keySet == nil {
+		return nil, utils.MISSING_SIGNING_KEY
+	}
+	token, err := jwt.ParseWithClaims(signedToken, &UserClaims{}, keySet.Keyfunc,
+		jwt.WithValidMethods(keySet.Methods()),
+		jwt.WithIssuer(JWTIssuer()),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(utils.GetEnvDuration("JWT_LEEWAY", JWT_LEEWAY)),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, utils.TOKEN_EXPIRED
-		} else if !claims.Valid {
+		}
+		return nil, utils.INVALID_TOKEN
+	}
+	if claims, ok := token.Claims.(*UserClaims); ok && token.Valid {
+		if !claims.Valid || claims.Jti == "" {
 			return nil, utils.INVALID_TOKEN
 		}
+		if claims.Sub != claims.User_Id.Hex() {
+			return nil, utils.INVALID_CLAIMS
+		}
 		return token, nil
 	} else {
 		if !token.Valid {
@@ -107,6 +241,13 @@ func GetClaimsFromContext(r *http.Request) (*UserClaims, bool) {
 	return claims, ok
 }
 
+func (u UserClaims) EffectiveRole() models.Role {
+	if u.Role == "" {
+		return models.RoleCustomer
+	}
+	return u.Role
+}
+
 func (u UserClaims) GetExpirationTime() (*jwt.NumericDate, error) {
 	if u.Exp == 0 {
 		return nil, fmt.Errorf("no expiration time set")
@@ -119,22 +260,31 @@ func (u UserClaims) GetIssuedAt() (*jwt.NumericDate, error) {
 	if u.Iat == 0 {
 		return nil, fmt.Errorf("no issued at time set")
 	}
-	issuedAt := jwt.NewNumericDate(time.Unix(u.Iat, 0))
+	issuedAt := jwt.NewNumericDate(u.IssuedAt())
 	return issuedAt, nil
 }
 
+// iat is written with milliseconds as a fraction, so a session created right after a logout of all sessions
+// is not revoked by it.
+func (u UserClaims) IssuedAt() time.Time {
+	return time.UnixMilli(int64(math.Round(u.Iat * 1000)))
+}
+
 func (u UserClaims) GetNotBefore() (*jwt.NumericDate, error) {
-	return nil, nil
+	if u.Nbf == 0 {
+		return nil, nil
+	}
+	return jwt.NewNumericDate(time.Unix(u.Nbf, 0)), nil
 }
 
 func (u UserClaims) GetIssuer() (string, error) {
-	return "", nil
+	return u.Iss, nil
 }
 
 func (u UserClaims) GetSubject() (string, error) {
-	return "", nil
+	return u.Sub, nil
 }
 
 func (u UserClaims) GetAudience() (jwt.ClaimStrings, error) {
-	return nil, nil
+	return u.Aud, nil
 }
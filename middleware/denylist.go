@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+const DENYLIST_SYNC_INTERVAL = time.Second * 30
+
+type RevocationStore interface {
+	CreateRevocation(ctx context.Context, revocation *models.Revocation) error
+	GetRevocations(ctx context.Context, since time.Time) ([]*models.Revocation, error)
+}
+
+type Denylist struct {
+	mu           sync.RWMutex
+	store        RevocationStore
+	syncInterval time.Duration
+	syncedAt     time.Time
+	tokens       map[string]time.Time
+	users        map[primitive.ObjectID]time.Time
+}
+
+var denylist *Denylist
+
+func UseDenylist(d *Denylist) {
+	denylist = d
+}
+
+func NewDenylist(store RevocationStore, syncInterval time.Duration) *Denylist {
+	return &Denylist{
+		store:        store,
+		syncInterval: syncInterval,
+		tokens:       map[string]time.Time{},
+		users:        map[primitive.ObjectID]time.Time{},
+	}
+}
+
+func (d *Denylist) Sync(ctx context.Context) error {
+	d.mu.RLock()
+	since := d.syncedAt.Add(-d.syncInterval)
+	d.mu.RUnlock()
+
+	now := time.Now()
+	revocations, err := d.store.GetRevocations(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, revocation := range revocations {
+		d.add(revocation)
+	}
+	for jti, expiresAt := range d.tokens {
+		if now.After(expiresAt) {
+			delete(d.tokens, jti)
+		}
+	}
+	for uId, revokedAt := range d.users {
+		if now.Sub(revokedAt) > AccessTokenTTL() {
+			delete(d.users, uId)
+		}
+	}
+	d.syncedAt = now
+	return nil
+}
+
+func (d *Denylist) add(revocation *models.Revocation) {
+	if revocation.TokenID != "" {
+		d.tokens[revocation.TokenID] = revocation.ExpiresAt
+	}
+	if revocation.UserID != nil && revocation.RevokedAt.After(d.users[*revocation.UserID]) {
+		d.users[*revocation.UserID] = revocation.RevokedAt
+	}
+}
+
+func (d *Denylist) Revoke(ctx context.Context, revocation *models.Revocation) error {
+	if err := d.store.CreateRevocation(ctx, revocation); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.add(revocation)
+	return nil
+}
+
+func (d *Denylist) RevokeToken(ctx context.Context, claims *UserClaims) error {
+	return d.Revoke(ctx, &models.Revocation{
+		TokenID:   claims.Jti,
+		RevokedAt: time.Now(),
+		ExpiresAt: time.Unix(claims.Exp, 0),
+	})
+}
+
+func (d *Denylist) RevokeUser(ctx context.Context, uId primitive.ObjectID) error {
+	// iat has millisecond precision, so the revocation covers the rest of the current millisecond, tokens issued in it
+	// after this call start at the revocation instead, see issuedAt
+	revokedAt := time.Now().Truncate(time.Millisecond).Add(time.Millisecond)
+	return d.Revoke(ctx, &models.Revocation{
+		UserID:    &uId,
+		RevokedAt: revokedAt,
+		ExpiresAt: revokedAt.Add(AccessTokenTTL()),
+	})
+}
+
+// issuedAt is the iat of a new token of the user, never before the last revocation of all the user's tokens.
+func (d *Denylist) issuedAt(uId primitive.ObjectID, now time.Time) time.Time {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if revokedAt, ok := d.users[uId]; ok && now.Before(revokedAt) {
+		return revokedAt
+	}
+	return now
+}
+
+func (d *Denylist) IsRevoked(ctx context.Context, claims *UserClaims) bool {
+	d.mu.RLock()
+	stale := time.Since(d.syncedAt) > d.syncInterval
+	d.mu.RUnlock()
+	if stale {
+		if err := d.Sync(ctx); err != nil {
+			utils.Logger(ctx).Warn("could not sync token denylist", "error", err)
+		}
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if _, ok := d.tokens[claims.Jti]; ok && claims.Jti != "" {
+		return true
+	}
+	revokedAt, ok := d.users[claims.User_Id]
+	return ok && claims.IssuedAt().Before(revokedAt)
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gorilla/mux"
+	"github.com/mathis-k/bank-api/metrics"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestInfo struct {
+	route  string
+	userID string
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return primitive.NewObjectID().Hex()
+	}
+	return hex.EncodeToString(id)
+}
+
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(REQUEST_ID_HEADER)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, requestID)
+
+		ctx := tracing.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.StartServer(ctx, r.Method,
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("client.address", utils.ClientIP(r)),
+			attribute.String("http.request.header.x-request-id", requestID),
+		)
+		defer span.End()
+
+		info := &requestInfo{}
+		logger := slog.Default().With("request_id", requestID)
+		if spanContext := span.SpanContext(); spanContext.IsValid() {
+			logger = logger.With("trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String())
+		}
+		ctx = context.WithValue(ctx, "request_info", info)
+		ctx = utils.WithLogger(ctx, logger)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		status := recorder.statusCode
+		if status == 0 {
+			status = http.StatusOK
+		}
+		latency := time.Since(start)
+		metrics.ObserveHTTPRequest(r.Method, info.route, status, latency)
+		if info.route != "" {
+			span.SetName(r.Method + " " + info.route)
+			span.SetAttributes(attribute.String("http.route", info.route))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if info.userID != "" {
+			span.SetAttributes(attribute.String("enduser.id", info.userID))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		route := info.route
+		if route == "" {
+			route = r.URL.Path
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(latency.Microseconds())/1000),
+			slog.Int("bytes", recorder.bytes),
+			slog.String("ip", utils.ClientIP(r)),
+		}
+		if info.userID != "" {
+			attrs = append(attrs, slog.String("user_id", info.userID))
+		}
+		logger.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+func RouteTemplate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value("request_info").(*requestInfo); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					info.route = template
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setRequestUser(r *http.Request, uId primitive.ObjectID) {
+	if info, ok := r.Context().Value("request_info").(*requestInfo); ok {
+		info.userID = uId.Hex()
+	}
+}
@@ -0,0 +1,262 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mathis-k/bank-api/utils"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	MIN_RSA_KEY_BITS     = 2048
+	MIN_HMAC_SECRET_SIZE = 32
+)
+
+type SigningKey struct {
+	Kid       string
+	Method    jwt.SigningMethod
+	Private   crypto.PrivateKey
+	Public    crypto.PublicKey
+	NotBefore time.Time
+	ExpiresAt time.Time
+}
+
+type KeySet struct {
+	keys []*SigningKey
+}
+
+type keyManifest struct {
+	Keys []struct {
+		Kid            string     `json:"kid"`
+		PrivateKeyFile string     `json:"private_key_file"`
+		NotBefore      time.Time  `json:"not_before"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+	} `json:"keys"`
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var keySet *KeySet
+
+func UseKeySet(k *KeySet) {
+	keySet = k
+}
+
+func NewKeySetFromEnv() (*KeySet, error) {
+	if manifest := os.Getenv("JWT_KEYS_FILE"); manifest != "" {
+		return LoadKeyManifest(manifest)
+	}
+	if keyFile := os.Getenv("JWT_PRIVATE_KEY_FILE"); keyFile != "" {
+		key, err := LoadSigningKey(keyFile, "")
+		if err != nil {
+			return nil, err
+		}
+		return NewKeySet(key)
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		if len(secret) < MIN_HMAC_SECRET_SIZE {
+			return nil, fmt.Errorf("%w: JWT_SECRET must be at least %d bytes", utils.WEAK_SIGNING_KEY, MIN_HMAC_SECRET_SIZE)
+		}
+		slog.Warn("signing tokens with the shared JWT_SECRET (HS256), configure JWT_KEYS_FILE to publish keys via JWKS")
+		return NewKeySet(&SigningKey{
+			Kid:     "hs256",
+			Method:  jwt.SigningMethodHS256,
+			Private: []byte(secret),
+			Public:  []byte(secret),
+		})
+	}
+	return nil, fmt.Errorf("%w: set JWT_KEYS_FILE, JWT_PRIVATE_KEY_FILE or JWT_SECRET", utils.MISSING_SIGNING_KEY)
+}
+
+func NewKeySet(keys ...*SigningKey) (*KeySet, error) {
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key.Kid] {
+			return nil, fmt.Errorf("%w: duplicate kid %q", utils.INVALID_SIGNING_KEY, key.Kid)
+		}
+		seen[key.Kid] = true
+	}
+	k := &KeySet{keys: keys}
+	sort.SliceStable(k.keys, func(i, j int) bool { return k.keys[i].NotBefore.Before(k.keys[j].NotBefore) })
+	if _, err := k.SigningKey(time.Now()); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func LoadKeyManifest(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest keyManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", utils.INVALID_SIGNING_KEY, err)
+	}
+
+	var keys []*SigningKey
+	for _, entry := range manifest.Keys {
+		keyFile := entry.PrivateKeyFile
+		if !filepath.IsAbs(keyFile) {
+			keyFile = filepath.Join(filepath.Dir(path), keyFile)
+		}
+		key, err := LoadSigningKey(keyFile, entry.Kid)
+		if err != nil {
+			return nil, err
+		}
+		key.NotBefore = entry.NotBefore
+		if entry.ExpiresAt != nil {
+			key.ExpiresAt = *entry.ExpiresAt
+		}
+		keys = append(keys, key)
+	}
+	keySet, err := NewKeySet(keys...)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("loaded JWT signing keys", "count", len(keys), "path", path)
+	return keySet, nil
+}
+
+func LoadSigningKey(path string, kid string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s is not a PEM file", utils.INVALID_SIGNING_KEY, path)
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", utils.INVALID_SIGNING_KEY, path, err)
+		}
+	}
+
+	key := &SigningKey{Private: private}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < MIN_RSA_KEY_BITS {
+			return nil, fmt.Errorf("%w: %s has %d bits, at least %d are required", utils.WEAK_SIGNING_KEY, path, private.N.BitLen(), MIN_RSA_KEY_BITS)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.Public = &private.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.Public = private.Public()
+	default:
+		return nil, fmt.Errorf("%w: %s must be an RSA or Ed25519 key", utils.INVALID_SIGNING_KEY, path)
+	}
+
+	key.Kid = kid
+	if key.Kid == "" {
+		key.Kid = key.thumbprint()
+	}
+	return key, nil
+}
+
+func (k *SigningKey) thumbprint() string {
+	der, err := x509.MarshalPKIXPublicKey(k.Public)
+	if err != nil {
+		return k.Method.Alg()
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+func (k *SigningKey) validAt(t time.Time) bool {
+	return !t.Before(k.NotBefore) && (k.ExpiresAt.IsZero() || t.Before(k.ExpiresAt))
+}
+
+func (k *KeySet) SigningKey(now time.Time) (*SigningKey, error) {
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if k.keys[i].validAt(now) {
+			return k.keys[i], nil
+		}
+	}
+	return nil, utils.MISSING_SIGNING_KEY
+}
+
+func (k *KeySet) VerificationKey(kid string, now time.Time) (*SigningKey, error) {
+	for _, key := range k.keys {
+		if key.Kid == kid && key.validAt(now) {
+			return key, nil
+		}
+	}
+	return nil, utils.INVALID_TOKEN
+}
+
+func (k *KeySet) Methods() []string {
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, key := range k.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := k.VerificationKey(kid, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, utils.INVALID_TOKEN
+	}
+	return key.Public, nil
+}
+
+func (k *KeySet) JWKS(now time.Time) JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		if !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt) {
+			continue
+		}
+		jwk := JWK{Kid: key.Kid, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
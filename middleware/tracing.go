@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"github.com/mathis-k/bank-api/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+
+type middlewareSpan struct {
+	span   trace.Span
+	parent trace.Span
+}
+
+func spanName(fn any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	name = closureSuffix.ReplaceAllString(strings.TrimSuffix(name, "-fm"), "")
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.NewReplacer("(*", "", ")", "").Replace(name)
+}
+
+func Traced(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	name := spanName(mw)
+	return func(next http.Handler) http.Handler {
+		handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if current, ok := ctx.Value("middleware_span").(*middlewareSpan); ok {
+				current.span.End()
+				ctx = trace.ContextWithSpan(ctx, current.parent)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := trace.SpanFromContext(r.Context())
+			ctx, span := tracing.Start(r.Context(), name)
+			defer span.End()
+			ctx = context.WithValue(ctx, "middleware_span", &middlewareSpan{span: span, parent: parent})
+			handler.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func TracedHandler(handler http.HandlerFunc) http.Handler {
+	name := spanName(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), name)
+		defer span.End()
+		handler(w, r.WithContext(ctx))
+	})
+}
@@ -2,55 +2,279 @@ package models
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Account struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	AccountNumber uint64             `bson:"account_number" json:"account_number"`
-	Balance       float64            `bson:"balance" json:"balance"`
+	IBAN          string             `bson:"iban,omitempty" json:"iban,omitempty"`
+	Balance       Money              `bson:"balance" json:"balance"`
+	Currency      Currency           `bson:"currency" json:"currency"`
+	Frozen        bool               `bson:"frozen" json:"frozen"`
 	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+
+	LegacyAccountNumber uint64 `bson:"legacy_account_number,omitempty" json:"legacy_account_number,omitempty"`
+}
+
+const AccountNumberAttempts = 5
+
+type AccountRequest struct {
+	Currency Currency `bson:"currency" json:"currency"`
+}
+
+func ValidateAccountRequest(request *AccountRequest) error {
+	if request.Currency == "" {
+		request.Currency = DefaultCurrency()
+		return nil
+	}
+	currency, err := ParseCurrency(string(request.Currency))
+	if err != nil {
+		return err
+	}
+	request.Currency = currency
+	return nil
+}
+
+func BankCode() string {
+	if code := os.Getenv("BANK_CODE"); code != "" {
+		return code
+	}
+	return "BANKAPI"
+}
+
+func AccountIBAN(accountNumber uint64) string {
+	countryCode := os.Getenv("IBAN_COUNTRY_CODE")
+	if countryCode == "" {
+		return ""
+	}
+	iban, err := utils.NewIBAN(countryCode, fmt.Sprintf("%s%0*d", BankCode(), utils.AccountNumberLength, accountNumber))
+	if err != nil {
+		slog.Warn("could not build IBAN", "account_number", accountNumber, "error", err)
+		return ""
+	}
+	return iban
+}
+
+func ParseAccountNumber(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, utils.MISSING_ACCOUNT_NUMBER
+	}
+	if c := s[0]; (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') {
+		iban := utils.NormalizeIBAN(s)
+		if !utils.ValidIBAN(iban) || len(iban) < utils.AccountNumberLength {
+			return 0, utils.INVALID_IBAN
+		}
+		s = iban[len(iban)-utils.AccountNumberLength:]
+	}
+	accountNumber, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, utils.INVALID_ACCOUNT_NUMBER
+	}
+	if !utils.ValidAccountNumber(accountNumber) {
+		return 0, utils.INVALID_ACCOUNT_NUMBER
+	}
+	return accountNumber, nil
 }
 
-func (db *DB) CreateAccount() (*Account, error) {
-	account := &Account{
+// ParseLegacyAccountNumber accepts a 10 digit number without a valid check digit while legacy account numbers are
+// enabled. It only makes sense as a lookup in legacy_account_number, never as a number of a current account.
+func ParseLegacyAccountNumber(s string) (uint64, bool) {
+	s = strings.TrimSpace(s)
+	if !AcceptLegacyAccountNumbers() || len(s) != utils.AccountNumberLength {
+		return 0, false
+	}
+	accountNumber, err := strconv.ParseUint(s, 10, 64)
+	return accountNumber, err == nil
+}
+
+func AcceptLegacyAccountNumbers() bool {
+	return os.Getenv("ACCEPT_LEGACY_ACCOUNT_NUMBERS") == "true"
+}
+
+func newAccount(currency Currency) (*Account, error) {
+	accountNumber, err := utils.GenerateAccountNumber()
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
 		ID:            primitive.NewObjectID(),
-		AccountNumber: uint64(time.Now().Unix()),
-		Balance:       0.0,
+		AccountNumber: accountNumber,
+		IBAN:          AccountIBAN(accountNumber),
+		Balance:       0,
+		Currency:      currency,
 		CreatedAt:     time.Now(),
+	}, nil
+}
+
+func (db *DB) CreateAccount(ctx context.Context, currency Currency) (_ *Account, err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateAccount")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	for attempt := 0; attempt < AccountNumberAttempts; attempt++ {
+		account, err := newAccount(currency)
+		if err != nil {
+			return nil, err
+		}
+		_, err = db.Db.Collection("accounts").InsertOne(ctx, account)
+		if mongo.IsDuplicateKeyError(err) {
+			utils.Logger(ctx).Warn("account number is already taken, retrying", "account_number", account.AccountNumber)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return account, nil
+	}
+	return nil, utils.ACCOUNT_NUMBER_EXHAUSTED
+}
+
+func (db *DB) migrateAccountNumbers(ctx context.Context) error {
+	cursor, err := db.Db.Collection("accounts").Find(ctx, primitive.M{},
+		options.Find().SetSort(primitive.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	seen := map[uint64]bool{}
+	for cursor.Next(ctx) {
+		account := &Account{}
+		if err := cursor.Decode(account); err != nil {
+			return err
+		}
+		if utils.ValidAccountNumber(account.AccountNumber) && !seen[account.AccountNumber] {
+			seen[account.AccountNumber] = true
+			if account.IBAN == "" {
+				if iban := AccountIBAN(account.AccountNumber); iban != "" {
+					if _, err := db.Db.Collection("accounts").UpdateByID(ctx, account.ID, primitive.M{"$set": primitive.M{"iban": iban}}); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+
+		duplicate := seen[account.AccountNumber]
+		var accountNumber uint64
+		for accountNumber == 0 || seen[accountNumber] {
+			if accountNumber, err = utils.GenerateAccountNumber(); err != nil {
+				return err
+			}
+			count, err := db.Db.Collection("accounts").CountDocuments(ctx, primitive.M{"account_number": accountNumber})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				seen[accountNumber] = true
+			}
+		}
+		seen[accountNumber] = true
+
+		update := primitive.M{
+			"account_number":        accountNumber,
+			"legacy_account_number": account.AccountNumber,
+		}
+		if iban := AccountIBAN(accountNumber); iban != "" {
+			update["iban"] = iban
+		}
+		if _, err := db.Db.Collection("accounts").UpdateByID(ctx, account.ID, primitive.M{"$set": update}); err != nil {
+			return err
+		}
+		if !duplicate {
+			_, err = db.Db.Collection("standing_orders").UpdateMany(ctx,
+				primitive.M{"to_account_number": account.AccountNumber},
+				primitive.M{"$set": primitive.M{"to_account_number": accountNumber}})
+			if err != nil {
+				return err
+			}
+		}
+		utils.Logger(ctx).Info("renumbered account", "from", account.AccountNumber, "to", accountNumber)
 	}
-	_, err := db.Db.Collection("accounts").InsertOne(context.TODO(), account)
+	return cursor.Err()
+}
+func (db *DB) GetAccountById(ctx context.Context, aId primitive.ObjectID) (_ *Account, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetAccountById")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	account := &Account{}
+	err = db.Db.Collection("accounts").FindOne(ctx, primitive.M{"_id": aId}).Decode(account)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.ACCOUNT_NOT_FOUND
+		}
 		return nil, err
 	}
 	return account, nil
 }
-func (db *DB) GetAccountById(aId primitive.ObjectID) (*Account, error) {
+func (db *DB) GetAccountByAccountNumber(ctx context.Context, accountNumber uint64) (_ *Account, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetAccountByAccountNumber")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	account := &Account{}
-	err := db.Db.Collection("accounts").FindOne(context.TODO(), primitive.M{"_id": aId}).Decode(account)
+	err = db.Db.Collection("accounts").FindOne(ctx, primitive.M{"account_number": accountNumber}).Decode(account)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.ACCOUNT_NOT_FOUND
+		}
 		return nil, err
 	}
 	return account, nil
 }
-func (db *DB) GetAccountByAccountNumber(accountNumber uint64) (*Account, error) {
+func (db *DB) GetAccountByLegacyAccountNumber(ctx context.Context, legacyAccountNumber uint64) (_ *Account, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetAccountByLegacyAccountNumber")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	account := &Account{}
-	err := db.Db.Collection("accounts").FindOne(context.TODO(), primitive.M{"account_number": accountNumber}).Decode(account)
+	err = db.Db.Collection("accounts").FindOne(ctx, primitive.M{"legacy_account_number": legacyAccountNumber}).Decode(account)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.ACCOUNT_NOT_FOUND
+		}
 		return nil, err
 	}
+	utils.Logger(ctx).Warn("account looked up by legacy account number", "legacy_account_number", legacyAccountNumber, "account_number", account.AccountNumber)
 	return account, nil
 }
-func (db *DB) GetAccountsFromUser(uId primitive.ObjectID) ([]*Account, error) {
-	user, err := db.GetUserById(uId)
+func (db *DB) GetAccountsFromUser(ctx context.Context, uId primitive.ObjectID) (_ []*Account, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetAccountsFromUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	user, err := db.GetUserById(ctx, uId)
 	if err != nil {
 		return nil, err
 	}
 	accounts := []*Account{}
 	for _, accountID := range user.Accounts {
 		account := &Account{}
-		err := db.Db.Collection("accounts").FindOne(context.TODO(), primitive.M{"_id": accountID}).Decode(account)
+		err := db.Db.Collection("accounts").FindOne(ctx, primitive.M{"_id": accountID}).Decode(account)
 		if err != nil {
 			return nil, err
 		}
@@ -58,8 +282,55 @@ func (db *DB) GetAccountsFromUser(uId primitive.ObjectID) ([]*Account, error) {
 	}
 	return accounts, nil
 }
-func (db *DB) DeleteAccount(aId primitive.ObjectID) error {
-	_, err := db.Db.Collection("accounts").DeleteOne(context.TODO(), primitive.M{"_id": aId})
+func (db *DB) GetAccountNumbers(ctx context.Context, ids []primitive.ObjectID) (_ map[primitive.ObjectID]uint64, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetAccountNumbers")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	accountNumbers := map[primitive.ObjectID]uint64{}
+	if len(ids) == 0 {
+		return accountNumbers, nil
+	}
+	cursor, err := db.Db.Collection("accounts").Find(ctx, primitive.M{"_id": primitive.M{"$in": ids}},
+		options.Find().SetProjection(primitive.M{"account_number": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		accountNumbers[account.ID] = account.AccountNumber
+	}
+	return accountNumbers, nil
+}
+func (db *DB) SetAccountFrozen(ctx context.Context, aId primitive.ObjectID, frozen bool) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.SetAccountFrozen")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	result, err := db.Db.Collection("accounts").UpdateOne(ctx, primitive.M{"_id": aId}, primitive.M{"$set": primitive.M{"frozen": frozen}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.ACCOUNT_NOT_FOUND
+	}
+	return nil
+}
+func (db *DB) DeleteAccount(ctx context.Context, aId primitive.ObjectID) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.DeleteAccount")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("accounts").DeleteOne(ctx, primitive.M{"_id": aId})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mathis-k/bank-api/utils"
+)
+
+func TestParseAccountNumberRequiresCheckDigit(t *testing.T) {
+	for _, legacy := range []string{"", "false", "true"} {
+		t.Setenv("ACCEPT_LEGACY_ACCOUNT_NUMBERS", legacy)
+		if _, err := ParseAccountNumber("4929561308"); err != nil {
+			t.Errorf("legacy=%q: valid number rejected: %v", legacy, err)
+		}
+		if _, err := ParseAccountNumber("4929561309"); !errors.Is(err, utils.INVALID_ACCOUNT_NUMBER) {
+			t.Errorf("legacy=%q: number with a wrong check digit got %v, want INVALID_ACCOUNT_NUMBER", legacy, err)
+		}
+	}
+}
+
+func TestParseLegacyAccountNumber(t *testing.T) {
+	for _, tc := range []struct {
+		legacy string
+		input  string
+		ok     bool
+	}{
+		{"", "4929561309", false},
+		{"false", "4929561309", false},
+		{"1", "4929561309", false},
+		{"true", "4929561309", true},
+		{"true", " 4929561309 ", true},
+		{"true", "492956130", false},
+		{"true", "49295613091", false},
+		{"true", "DE4929561309", false},
+	} {
+		t.Setenv("ACCEPT_LEGACY_ACCOUNT_NUMBERS", tc.legacy)
+		if _, ok := ParseLegacyAccountNumber(tc.input); ok != tc.ok {
+			t.Errorf("legacy=%q input=%q: ok = %v, want %v", tc.legacy, tc.input, ok, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net"
+	"strings"
+	"time"
+)
+
+type Scope string
+
+const (
+	ScopeUserRead            Scope = "user:read"
+	ScopeAccountsRead        Scope = "accounts:read"
+	ScopeAccountsWrite       Scope = "accounts:write"
+	ScopeTransactionsRead    Scope = "transactions:read"
+	ScopeTransactionsWrite   Scope = "transactions:write"
+	ScopeTransfersCreate     Scope = "transfers:create"
+	ScopeStandingOrdersRead  Scope = "standing_orders:read"
+	ScopeStandingOrdersWrite Scope = "standing_orders:write"
+	ScopeAdminRead           Scope = "admin:read"
+	ScopeAdminWrite          Scope = "admin:write"
+	ScopeSession             Scope = "session"
+)
+
+const (
+	API_KEY_PREFIX       = "bk"
+	MaxAPIKeysPerUser    = 20
+	apiKeyLastUsedWindow = time.Minute
+)
+
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `bson:"user_id"`
+	Name       string             `bson:"name"`
+	Prefix     string             `bson:"prefix"`
+	KeyHash    string             `bson:"key_hash"`
+	Scopes     []Scope            `bson:"scopes"`
+	AllowedIPs []string           `bson:"allowed_ips,omitempty"`
+	CreatedBy  primitive.ObjectID `bson:"created_by"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty"`
+}
+
+type APIKeyRequest struct {
+	Name       string     `json:"name" validate:"required,min=1,max=100"`
+	Scopes     []Scope    `json:"scopes" validate:"required,min=1,unique,dive,oneof=user:read accounts:read accounts:write transactions:read transactions:write transfers:create standing_orders:read standing_orders:write admin:read admin:write"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	AllowedIPs []string   `json:"allowed_ips" validate:"max=50"`
+}
+
+type AdminAPIKeyRequest struct {
+	APIKeyRequest
+	Reason string `json:"reason" validate:"required,min=5,max=500"`
+}
+
+func ValidateAPIKeyRequest(request *APIKeyRequest) error {
+	validate := newValidator()
+	if err := validate.Struct(request); err != nil {
+		return err
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: expires_at must be in the future", utils.INVALID_API_KEY_REQUEST)
+	}
+	for _, entry := range request.AllowedIPs {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("%w: %s is not an IP address or CIDR range", utils.INVALID_API_KEY_REQUEST, entry)
+		}
+	}
+	return nil
+}
+func ValidateAdminAPIKeyRequest(request *AdminAPIKeyRequest) error {
+	validate := newValidator()
+	if err := validate.Struct(request); err != nil {
+		return err
+	}
+	return ValidateAPIKeyRequest(&request.APIKeyRequest)
+}
+
+func NewAPIKey(uId primitive.ObjectID, createdBy primitive.ObjectID, request *APIKeyRequest) (*APIKey, string, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	secret, err := newTokenSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	prefix := API_KEY_PREFIX + "_" + hex.EncodeToString(id)
+	key := prefix + "_" + secret
+	return &APIKey{
+		ID:         primitive.NewObjectID(),
+		UserID:     uId,
+		Name:       request.Name,
+		Prefix:     prefix,
+		KeyHash:    HashAPIKey(key),
+		Scopes:     request.Scopes,
+		AllowedIPs: request.AllowedIPs,
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  request.ExpiresAt,
+	}, key, nil
+}
+
+func HashAPIKey(key string) string {
+	return HashRefreshToken(key)
+}
+
+func LooksLikeAPIKey(key string) bool {
+	return strings.HasPrefix(key, API_KEY_PREFIX+"_")
+}
+
+func (k *APIKey) Usable(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+func (k *APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, entry := range k.AllowedIPs {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Scope) ReadOnly() bool {
+	switch s {
+	case ScopeUserRead, ScopeAccountsRead, ScopeTransactionsRead, ScopeStandingOrdersRead, ScopeAdminRead:
+		return true
+	}
+	return false
+}
+
+func (k *APIKey) NeedsTouch(now time.Time) bool {
+	return k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyLastUsedWindow
+}
+
+func (db *DB) CreateAPIKey(ctx context.Context, key *APIKey) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateAPIKey")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	count, err := db.Db.Collection("api_keys").CountDocuments(ctx, primitive.M{"user_id": key.UserID, "revoked_at": primitive.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if count >= MaxAPIKeysPerUser {
+		return utils.TOO_MANY_API_KEYS
+	}
+	_, err = db.Db.Collection("api_keys").InsertOne(ctx, key)
+	return err
+}
+func (db *DB) GetAPIKeyByHash(ctx context.Context, keyHash string) (_ *APIKey, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetAPIKeyByHash")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	var key APIKey
+	err = db.Db.Collection("api_keys").FindOne(ctx, primitive.M{"key_hash": keyHash}).Decode(&key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.INVALID_API_KEY
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+func (db *DB) GetAPIKeys(ctx context.Context, uId primitive.ObjectID) (_ []*APIKey, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetAPIKeys")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	opts := options.Find().SetSort(primitive.D{{Key: "created_at", Value: -1}})
+	cursor, err := db.Db.Collection("api_keys").Find(ctx, primitive.M{"user_id": uId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	keys := []*APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+func (db *DB) RevokeAPIKey(ctx context.Context, uId primitive.ObjectID, kId primitive.ObjectID) (_ *APIKey, err error) {
+	ctx, span := tracing.Start(ctx, "DB.RevokeAPIKey")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	var key APIKey
+	err = db.Db.Collection("api_keys").FindOneAndUpdate(ctx,
+		primitive.M{"_id": kId, "user_id": uId, "revoked_at": primitive.M{"$exists": false}},
+		primitive.M{"$set": primitive.M{"revoked_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.API_KEY_NOT_FOUND
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+func (db *DB) TouchAPIKey(ctx context.Context, kId primitive.ObjectID, usedAt time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.TouchAPIKey")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("api_keys").UpdateOne(ctx, primitive.M{"_id": kId}, primitive.M{"$set": primitive.M{"last_used_at": usedAt}})
+	return err
+}
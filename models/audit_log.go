@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"github.com/mathis-k/bank-api/tracing"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditSearchUsers        AuditAction = "search_users"
+	AuditViewUser           AuditAction = "view_user"
+	AuditChangeRole         AuditAction = "change_role"
+	AuditViewAccount        AuditAction = "view_account"
+	AuditViewTransactions   AuditAction = "view_transactions"
+	AuditViewTransaction    AuditAction = "view_transaction"
+	AuditFreezeAccount      AuditAction = "freeze_account"
+	AuditUnfreezeAccount    AuditAction = "unfreeze_account"
+	AuditViewAuditLog       AuditAction = "view_audit_log"
+	AuditCreateAPIKey       AuditAction = "create_api_key"
+	AuditViewAPIKeys        AuditAction = "view_api_keys"
+	AuditRevokeAPIKey       AuditAction = "revoke_api_key"
+	AuditReverseTransaction AuditAction = "reverse_transaction"
+)
+
+const (
+	DefaultAuditLogLimit = 50
+	MaxAuditLogLimit     = 500
+)
+
+type AuditEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ActorID   primitive.ObjectID `bson:"actor_id" json:"actor_id"`
+	ActorRole Role               `bson:"actor_role" json:"actor_role"`
+	Action    AuditAction        `bson:"action" json:"action"`
+	Target    string             `bson:"target,omitempty" json:"target,omitempty"`
+	Reason    string             `bson:"reason" json:"reason"`
+	IP        string             `bson:"ip" json:"ip"`
+	UserAgent string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type AuditLogQuery struct {
+	ActorID *primitive.ObjectID
+	Action  AuditAction
+	Target  string
+	Limit   int64
+}
+
+type AdminActionRequest struct {
+	Reason string `json:"reason" validate:"required,min=5,max=500"`
+}
+
+func ValidateAdminActionRequest(request *AdminActionRequest) error {
+	validate := newValidator()
+	return validate.Struct(request)
+}
+
+func (q *AuditLogQuery) filter() primitive.M {
+	filter := primitive.M{}
+	if q.ActorID != nil {
+		filter["actor_id"] = *q.ActorID
+	}
+	if q.Action != "" {
+		filter["action"] = q.Action
+	}
+	if q.Target != "" {
+		filter["target"] = q.Target
+	}
+	return filter
+}
+
+func (q *AuditLogQuery) matches(entry *AuditEntry) bool {
+	return (q.ActorID == nil || entry.ActorID == *q.ActorID) &&
+		(q.Action == "" || entry.Action == q.Action) &&
+		(q.Target == "" || entry.Target == q.Target)
+}
+
+func (db *DB) CreateAuditEntry(ctx context.Context, entry *AuditEntry) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateAuditEntry")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	_, err = db.Db.Collection("audit_log").InsertOne(ctx, entry)
+	return err
+}
+func (db *DB) GetAuditLog(ctx context.Context, query *AuditLogQuery) (_ []*AuditEntry, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetAuditLog")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	opts := options.Find().SetSort(primitive.D{{Key: "created_at", Value: -1}}).SetLimit(query.Limit)
+	cursor, err := db.Db.Collection("audit_log").Find(ctx, query.filter(), opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := []*AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"github.com/mathis-k/bank-api/utils"
+	"math/big"
+	"os"
+	"strings"
+)
+
+type Currency string
+
+var currencyExponents = map[Currency]int{
+	"CHF": 2,
+	"CZK": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"NOK": 2,
+	"PLN": 2,
+	"SEK": 2,
+	"USD": 2,
+}
+
+const FallbackCurrency Currency = "EUR"
+
+func DefaultCurrency() Currency {
+	currency := Currency(strings.ToUpper(os.Getenv("DEFAULT_CURRENCY")))
+	if !currency.IsSupported() {
+		return FallbackCurrency
+	}
+	return currency
+}
+
+func ParseCurrency(s string) (Currency, error) {
+	currency := Currency(strings.ToUpper(strings.TrimSpace(s)))
+	if !currency.IsSupported() {
+		return "", utils.UNSUPPORTED_CURRENCY
+	}
+	return currency, nil
+}
+
+func (c Currency) IsSupported() bool {
+	_, ok := currencyExponents[c]
+	return ok
+}
+
+func (c Currency) Exponent() int {
+	return currencyExponents[c]
+}
+
+func (c Currency) quantum() int64 {
+	quantum := int64(1)
+	for i := c.Exponent(); i < MoneyScale; i++ {
+		quantum *= 10
+	}
+	return quantum
+}
+
+func (c Currency) ValidateAmount(amount Money) error {
+	if !c.IsSupported() {
+		return utils.UNSUPPORTED_CURRENCY
+	}
+	if int64(amount)%c.quantum() != 0 {
+		return utils.INVALID_AMOUNT_PRECISION
+	}
+	return nil
+}
+
+type Rate string
+
+const RateScale = 8
+
+func NewRate(r *big.Rat) Rate {
+	s := strings.TrimRight(r.FloatString(RateScale), "0")
+	return Rate(strings.TrimSuffix(s, "."))
+}
+
+func (r Rate) Rat() (*big.Rat, error) {
+	rat, ok := new(big.Rat).SetString(string(r))
+	if !ok || rat.Sign() <= 0 {
+		return nil, utils.INVALID_EXCHANGE_RATE
+	}
+	return rat, nil
+}
+
+func (r Rate) Convert(amount Money, to Currency) (Money, error) {
+	rate, err := r.Rat()
+	if err != nil {
+		return 0, err
+	}
+	quantum := big.NewInt(to.quantum())
+	converted := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(amount)), rate)
+	converted.Quo(converted, new(big.Rat).SetInt(quantum))
+
+	half := big.NewRat(1, 2)
+	if converted.Sign() < 0 {
+		half.Neg(half)
+	}
+	converted.Add(converted, half)
+	rounded := new(big.Int).Quo(converted.Num(), converted.Denom())
+	rounded.Mul(rounded, quantum)
+	if !rounded.IsInt64() {
+		return 0, utils.INVALID_AMOUNT
+	}
+	return Money(rounded.Int64()), nil
+}
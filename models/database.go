@@ -2,14 +2,16 @@ package models
 
 import (
 	"context"
-	"github.com/gorilla/mux"
+	"errors"
 	"github.com/joho/godotenv"
-	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/metrics"
+	"github.com/mathis-k/bank-api/tracing"
 	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
-	"net/http"
 	"os"
 	"time"
 )
@@ -25,9 +27,9 @@ const (
 	CheckConnectionTimeOut   = 2 * time.Second
 )
 
-func (db *DB) Connect() error {
+func (db *DB) Connect(ctx context.Context) error {
 	if err := godotenv.Load(); err != nil {
-		log.Println("✖ No .env file found")
+		utils.Logger(ctx).Warn("no .env file found")
 	}
 
 	uri := os.Getenv("MONGODB_URI")
@@ -35,30 +37,248 @@ func (db *DB) Connect() error {
 
 	startTime := time.Now()
 
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(combineMonitors(metrics.NewMongoMonitor(), tracing.NewMongoMonitor())))
 	if err != nil {
-		log.Println("✖ Error connecting to MongoDB")
+		utils.Logger(ctx).Error("error connecting to MongoDB", "error", err)
 		return err
 	}
 
 	elapsedTime := time.Since(startTime)
 
 	if elapsedTime > ConnectionWarningTimeOut {
-		log.Printf("⚠ Connection to MongoDB is taking longer than expected: %v", elapsedTime)
+		utils.Logger(ctx).Warn("connection to MongoDB is taking longer than expected", "elapsed", elapsedTime)
 	}
 
 	db.Client = client
 	db.Db = client.Database(database)
-	log.Println("✔ Successfully Connected to MongoDB")
+	utils.Logger(ctx).Info("connected to MongoDB", "database", database)
 
 	return nil
 }
 
-func (db *DB) IsConnected() bool {
+func (db *DB) Migrate(ctx context.Context) error {
+	legacyAmount := func(field string) primitive.M {
+		return primitive.M{field: primitive.M{"$type": primitive.A{"double", "decimal"}}}
+	}
+
+	result, err := db.Db.Collection("accounts").UpdateMany(ctx, legacyAmount("balance"), moneyMigrationPipeline("balance"))
+	if err != nil {
+		utils.Logger(ctx).Error("error migrating account balances", "error", err)
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		utils.Logger(ctx).Info("migrated account balances to minor units", "count", result.ModifiedCount)
+	}
+
+	result, err = db.Db.Collection("transactions").UpdateMany(ctx, legacyAmount("amount"), moneyMigrationPipeline("amount"))
+	if err != nil {
+		utils.Logger(ctx).Error("error migrating transaction amounts", "error", err)
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		utils.Logger(ctx).Info("migrated transaction amounts to minor units", "count", result.ModifiedCount)
+	}
+
+	missingCurrency := primitive.M{"currency": primitive.M{"$exists": false}}
+	setCurrency := primitive.M{"$set": primitive.M{"currency": DefaultCurrency()}}
+	for _, collection := range []string{"accounts", "transactions"} {
+		result, err = db.Db.Collection(collection).UpdateMany(ctx, missingCurrency, setCurrency)
+		if err != nil {
+			utils.Logger(ctx).Error("error setting default currency", "collection", collection, "error", err)
+			return err
+		}
+		if result.ModifiedCount > 0 {
+			utils.Logger(ctx).Info("set default currency", "currency", DefaultCurrency(), "collection", collection, "count", result.ModifiedCount)
+		}
+	}
+
+	if err := db.runMigration(ctx, "account_numbers", db.migrateAccountNumbers); err != nil {
+		utils.Logger(ctx).Error("error migrating account numbers", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("accounts").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "account_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "iban", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "legacy_account_number", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating account indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("journal").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "postings.account", Value: 1}}},
+		{Keys: bson.D{{Key: "transaction_id", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating journal indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("transactions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "from_account", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "to_account", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "from_account", Value: 1}, {Key: "amount", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "to_account", Value: 1}, {Key: "amount", Value: -1}, {Key: "_id", Value: -1}}},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating transaction indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("idempotency_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating idempotency key indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("standing_orders").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "account_id", Value: 1}}},
+		{Keys: bson.D{{Key: "active", Value: 1}, {Key: "next_run_at", Value: 1}}},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating standing order indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("standing_order_executions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "executed_at", Value: -1}},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating standing order execution indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("refresh_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "family_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating refresh token indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("revocations").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "revoked_at", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating revocation indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("user_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating user token indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("login_attempts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating login attempt indexes", "error", err)
+		return err
+	}
+	_, err = db.Db.Collection("auth_events").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating auth event indexes", "error", err)
+		return err
+	}
+	result, err = db.Db.Collection("users").UpdateMany(ctx,
+		primitive.M{"email_verified": primitive.M{"$exists": false}},
+		primitive.M{"$set": primitive.M{"email_verified": true}})
+	if err != nil {
+		utils.Logger(ctx).Error("error migrating email verification state", "error", err)
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		utils.Logger(ctx).Info("marked existing users as verified", "count", result.ModifiedCount)
+	}
+	_, err = db.Db.Collection("audit_log").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "target", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating audit log indexes", "error", err)
+		return err
+	}
+	result, err = db.Db.Collection("users").UpdateMany(ctx,
+		primitive.M{"role": primitive.M{"$exists": false}},
+		primitive.M{"$set": primitive.M{"role": RoleCustomer}})
+	if err != nil {
+		utils.Logger(ctx).Error("error migrating user roles", "error", err)
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		utils.Logger(ctx).Info("assigned default role to existing users", "role", RoleCustomer, "count", result.ModifiedCount)
+	}
+	_, err = db.Db.Collection("api_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("error creating API key indexes", "error", err)
+		return err
+	}
+	if err := db.runMigration(ctx, "opening_balances", db.migrateOpeningBalances); err != nil {
+		utils.Logger(ctx).Error("error posting opening balances to the journal", "error", err)
+		return err
+	}
+	if err := db.checkLedgerDrift(ctx); err != nil {
+		utils.Logger(ctx).Error("error checking the ledger", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) runMigration(ctx context.Context, name string, migrate func(context.Context) error) error {
+	err := db.Db.Collection("migrations").FindOne(ctx, primitive.M{"_id": name}).Err()
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if err := migrate(ctx); err != nil {
+		return err
+	}
+	if _, err := db.Db.Collection("migrations").InsertOne(ctx, primitive.M{"_id": name, "applied_at": time.Now()}); err != nil {
+		return err
+	}
+	utils.Logger(ctx).Info("applied migration", "migration", name)
+	return nil
+}
+
+func combineMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, monitor := range monitors {
+				monitor.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, monitor := range monitors {
+				monitor.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, monitor := range monitors {
+				monitor.Failed(ctx, e)
+			}
+		},
+	}
+}
+
+func (db *DB) IsConnected(ctx context.Context) bool {
 	if db.Db == nil || db.Client == nil {
 		return false
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), CheckConnectionTimeOut)
+	ctx, cancel := context.WithTimeout(ctx, CheckConnectionTimeOut)
 	defer cancel()
 
 	if err := db.Client.Ping(ctx, nil); err != nil {
@@ -68,58 +288,19 @@ func (db *DB) IsConnected() bool {
 	}
 }
 
-func (db *DB) Disconnect() error {
+func (db *DB) Disconnect(ctx context.Context) error {
 	if db.Client != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), CloseTimeOut)
+		ctx, cancel := context.WithTimeout(ctx, CloseTimeOut)
 		defer cancel()
 		if err := db.Client.Disconnect(ctx); err != nil {
-			log.Println("✖ Error disconnecting from MongoDB")
+			utils.Logger(ctx).Error("error disconnecting from MongoDB", "error", err)
 			return err
 		} else {
-			log.Println("✔ Successfully disconnected from MongoDB")
+			utils.Logger(ctx).Info("disconnected from MongoDB")
 			return nil
 		}
 	} else {
-		log.Println("✖ MongoDB connection is not active")
+		utils.Logger(ctx).Error("MongoDB connection is not active")
 		return utils.DATABASE_NOT_ACTIVVE
 	}
 }
-
-func (db *DB) CheckAccountPermissionMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		claims, ok := middleware.GetClaimsFromContext(r)
-		if !ok {
-			utils.ErrorMessage(w, http.StatusUnauthorized, utils.INVALID_TOKEN)
-			return
-		}
-
-		user, err := db.GetUserById(claims.User_Id)
-		if err != nil {
-			utils.ErrorMessage(w, http.StatusPreconditionFailed, err)
-		}
-
-		vars := mux.Vars(r)
-		accountNumber_str, ok := vars["number"]
-		if !ok {
-			utils.ErrorMessage(w, http.StatusBadRequest, utils.MISSING_ACCOUNT_NUMBER)
-			return
-		}
-		accountNumber, err := utils.StringToUint64(accountNumber_str)
-		if err != nil {
-			utils.ErrorMessage(w, http.StatusBadRequest, err)
-			return
-		}
-
-		account, err := db.GetAccountByAccountNumber(accountNumber)
-		if err != nil {
-			utils.ErrorMessage(w, http.StatusForbidden, err)
-			return
-		}
-		if !user.HasAccount(account.ID) {
-			utils.ErrorMessage(w, http.StatusNotFound, utils.ACCOUNT_NOT_FOUND)
-			return
-		}
-		ctx := context.WithValue(r.Context(), "account", account)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
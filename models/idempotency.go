@@ -0,0 +1,127 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type IdempotencyKey struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty"`
+	UserID        primitive.ObjectID  `bson:"user_id"`
+	Key           string              `bson:"key"`
+	RequestHash   string              `bson:"request_hash"`
+	Completed     bool                `bson:"completed"`
+	TransactionID *primitive.ObjectID `bson:"transaction_id,omitempty"`
+	StatusCode    int                 `bson:"status_code,omitempty"`
+	Response      []byte              `bson:"response,omitempty"`
+	CreatedAt     time.Time           `bson:"created_at"`
+	LockedUntil   time.Time           `bson:"locked_until"`
+	ExpiresAt     time.Time           `bson:"expires_at"`
+}
+
+func WithIdempotencyKey(ctx context.Context, key *IdempotencyKey) context.Context {
+	return context.WithValue(ctx, "idempotency_key", key)
+}
+
+func idempotencyKeyFromContext(ctx context.Context) *IdempotencyKey {
+	key, _ := ctx.Value("idempotency_key").(*IdempotencyKey)
+	return key
+}
+
+// claimIdempotencyKey links the reserved idempotency key of the request to the transaction in the same MongoDB
+// transaction that moves the money, so a retry can never run it a second time.
+func (db *DB) claimIdempotencyKey(sessCtx mongo.SessionContext, tId primitive.ObjectID) error {
+	key := idempotencyKeyFromContext(sessCtx)
+	if key == nil {
+		return nil
+	}
+	filter := primitive.M{"_id": key.ID, "completed": false, "transaction_id": primitive.M{"$exists": false}}
+	result, err := db.Db.Collection("idempotency_keys").UpdateOne(sessCtx, filter, primitive.M{"$set": primitive.M{"transaction_id": tId}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.IDEMPOTENCY_KEY_IN_USE
+	}
+	return nil
+}
+
+func (db *DB) ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey) (_ *IdempotencyKey, err error) {
+	ctx, span := tracing.Start(ctx, "DB.ReserveIdempotencyKey")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	now := time.Now()
+	filter := primitive.M{"user_id": key.UserID, "key": key.Key}
+	_, err = db.Db.Collection("idempotency_keys").DeleteOne(ctx, primitive.M{
+		"user_id": key.UserID,
+		"key":     key.Key,
+		"$or": primitive.A{
+			primitive.M{"expires_at": primitive.M{"$lte": now}},
+			primitive.M{"completed": false, "transaction_id": primitive.M{"$exists": false}, "locked_until": primitive.M{"$lte": now}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = primitive.NewObjectID()
+	update := primitive.M{"$setOnInsert": key}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	existing := &IdempotencyKey{}
+	err = db.Db.Collection("idempotency_keys").FindOneAndUpdate(ctx, filter, update, opts).Decode(existing)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			err = db.Db.Collection("idempotency_keys").FindOne(ctx, filter).Decode(existing)
+			if err != nil {
+				return nil, err
+			}
+			return existing, nil
+		}
+		return nil, err
+	}
+	return existing, nil
+}
+func (db *DB) CompleteIdempotencyKey(ctx context.Context, key *IdempotencyKey, statusCode int, response []byte) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.CompleteIdempotencyKey")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	filter := primitive.M{"_id": key.ID, "user_id": key.UserID, "key": key.Key}
+	update := primitive.M{"$set": primitive.M{
+		"completed":   true,
+		"status_code": statusCode,
+		"response":    response,
+	}}
+	_, err = db.Db.Collection("idempotency_keys").UpdateOne(ctx, filter, update)
+	return err
+}
+func (db *DB) ReleaseIdempotencyKey(ctx context.Context, key *IdempotencyKey) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.ReleaseIdempotencyKey")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("idempotency_keys").DeleteOne(ctx, primitive.M{
+		"_id":            key.ID,
+		"user_id":        key.UserID,
+		"key":            key.Key,
+		"transaction_id": primitive.M{"$exists": false},
+	})
+	return err
+}
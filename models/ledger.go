@@ -0,0 +1,297 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+type SystemAccount string
+
+const (
+	CashIn         SystemAccount = "cash-in"
+	CashOut        SystemAccount = "cash-out"
+	Fees           SystemAccount = "fees"
+	FXClearing     SystemAccount = "fx-clearing"
+	OpeningBalance SystemAccount = "opening-balance"
+)
+
+type Posting struct {
+	Account       primitive.ObjectID `bson:"account,omitempty" json:"account,omitempty"`
+	SystemAccount SystemAccount      `bson:"system_account,omitempty" json:"system_account,omitempty"`
+	Amount        Money              `bson:"amount" json:"amount"`
+	Currency      Currency           `bson:"currency" json:"currency"`
+}
+
+type JournalEntry struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TransactionID primitive.ObjectID `bson:"transaction_id,omitempty" json:"transaction_id,omitempty"`
+	Postings      []Posting          `bson:"postings" json:"postings"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func accountPosting(aId primitive.ObjectID, amount Money, currency Currency) Posting {
+	return Posting{Account: aId, Amount: amount, Currency: currency}
+}
+func systemPosting(account SystemAccount, amount Money, currency Currency) Posting {
+	return Posting{SystemAccount: account, Amount: amount, Currency: currency}
+}
+
+func NewJournalEntry(transaction *Transaction) (*JournalEntry, error) {
+	var postings []Posting
+	switch transaction.Type {
+	case Deposit:
+		postings = []Posting{
+			systemPosting(CashIn, -transaction.Amount, transaction.Currency),
+			accountPosting(transaction.ToAccount, transaction.Amount, transaction.Currency),
+		}
+	case Payout:
+		postings = []Posting{
+			accountPosting(transaction.FromAccount, -transaction.Amount, transaction.Currency),
+			systemPosting(CashOut, transaction.Amount, transaction.Currency),
+		}
+	case Transfer:
+		if transaction.ExchangeRate == "" {
+			postings = []Posting{
+				accountPosting(transaction.FromAccount, -transaction.Amount, transaction.Currency),
+				accountPosting(transaction.ToAccount, transaction.Amount, transaction.Currency),
+			}
+		} else {
+			postings = []Posting{
+				accountPosting(transaction.FromAccount, -transaction.Amount, transaction.Currency),
+				systemPosting(FXClearing, transaction.Amount, transaction.Currency),
+				systemPosting(FXClearing, -transaction.ConvertedAmount, transaction.ConvertedCurrency),
+				accountPosting(transaction.ToAccount, transaction.ConvertedAmount, transaction.ConvertedCurrency),
+			}
+		}
+	default:
+		return nil, utils.INVALID_TRANSACTION_TYPE
+	}
+
+	entry := &JournalEntry{
+		ID:            primitive.NewObjectID(),
+		TransactionID: transaction.ID,
+		Postings:      postings,
+		CreatedAt:     transaction.CreatedAt,
+	}
+	if err := entry.Validate(); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (e *JournalEntry) Validate() error {
+	if len(e.Postings) < 2 {
+		return utils.UNBALANCED_JOURNAL_ENTRY
+	}
+	sums := map[Currency]Money{}
+	for _, posting := range e.Postings {
+		if posting.Amount == 0 || !posting.Currency.IsSupported() {
+			return utils.UNBALANCED_JOURNAL_ENTRY
+		}
+		if (posting.Account == primitive.NilObjectID) == (posting.SystemAccount == "") {
+			return utils.UNBALANCED_JOURNAL_ENTRY
+		}
+		sums[posting.Currency] += posting.Amount
+	}
+	for _, sum := range sums {
+		if sum != 0 {
+			return utils.UNBALANCED_JOURNAL_ENTRY
+		}
+	}
+	return nil
+}
+
+func (db *DB) postJournalEntry(ctx context.Context, entry *JournalEntry) error {
+	for _, posting := range entry.Postings {
+		if posting.Account == primitive.NilObjectID {
+			continue
+		}
+		filter := primitive.M{"_id": posting.Account, "currency": posting.Currency, "frozen": primitive.M{"$ne": true}}
+		update := primitive.M{"$inc": primitive.M{"balance": posting.Amount}}
+
+		if posting.Amount < 0 {
+			filter["balance"] = primitive.M{"$gte": -posting.Amount}
+			account := db.Db.Collection("accounts").FindOneAndUpdate(ctx, filter, update)
+			if account.Err() != nil {
+				if errors.Is(account.Err(), mongo.ErrNoDocuments) {
+					if db.accountFrozen(ctx, posting.Account) {
+						return utils.ACCOUNT_FROZEN
+					}
+					return utils.INSUFFICIENT_FUNDS
+				}
+				return account.Err()
+			}
+			continue
+		}
+
+		result, err := db.Db.Collection("accounts").UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			if db.accountFrozen(ctx, posting.Account) {
+				return utils.ACCOUNT_FROZEN
+			}
+			return utils.ACCOUNT_NOT_FOUND
+		}
+	}
+
+	_, err := db.Db.Collection("journal").InsertOne(ctx, entry)
+	return err
+}
+
+func (db *DB) accountFrozen(ctx context.Context, aId primitive.ObjectID) bool {
+	count, err := db.Db.Collection("accounts").CountDocuments(ctx, primitive.M{"_id": aId, "frozen": true})
+	return err == nil && count > 0
+}
+
+func (db *DB) GetLedgerBalance(ctx context.Context, aId primitive.ObjectID) (_ Money, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetLedgerBalance")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return db.ledgerBalance(ctx, aId, primitive.M{"postings.account": aId})
+}
+func (db *DB) ledgerBalanceBefore(ctx context.Context, aId primitive.ObjectID, before time.Time) (Money, error) {
+	return db.ledgerBalance(ctx, aId, primitive.M{"postings.account": aId, "created_at": primitive.M{"$lt": before}})
+}
+
+// ledgerOpeningBalance is the balance a statement from `from` to `to` starts with. Opening balance entries have no
+// transaction that could show up as a statement line, so they count as soon as they fall before the end of the period.
+func (db *DB) ledgerOpeningBalance(ctx context.Context, aId primitive.ObjectID, from time.Time, to time.Time) (Money, error) {
+	return db.ledgerBalance(ctx, aId, primitive.M{
+		"postings.account": aId,
+		"$or": primitive.A{
+			primitive.M{"created_at": primitive.M{"$lt": from}},
+			primitive.M{"transaction_id": primitive.M{"$exists": false}, "created_at": primitive.M{"$lt": to}},
+		},
+	})
+}
+func (db *DB) ledgerBalance(ctx context.Context, aId primitive.ObjectID, match primitive.M) (Money, error) {
+	pipeline := primitive.A{
+		primitive.M{"$match": match},
+		primitive.M{"$unwind": "$postings"},
+		primitive.M{"$match": primitive.M{"postings.account": aId}},
+		primitive.M{"$group": primitive.M{"_id": nil, "balance": primitive.M{"$sum": "$postings.amount"}}},
+	}
+	cursor, err := db.Db.Collection("journal").Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Balance Money `bson:"balance"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Balance, cursor.Err()
+}
+
+func (db *DB) forEachLedgerDrift(ctx context.Context, handle func(account *Account, difference Money) error) error {
+	cursor, err := db.Db.Collection("accounts").Find(ctx, primitive.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		account := &Account{}
+		if err := cursor.Decode(account); err != nil {
+			return err
+		}
+		ledgerBalance, err := db.GetLedgerBalance(ctx, account.ID)
+		if err != nil {
+			return err
+		}
+		if difference := account.Balance - ledgerBalance; difference != 0 {
+			if err := handle(account, difference); err != nil {
+				return err
+			}
+		}
+	}
+	return cursor.Err()
+}
+
+// newOpeningBalanceEntry explains the part of a balance that no transaction accounts for. It is dated when the account
+// was opened, so statements of any later period start from a balance that includes it.
+func newOpeningBalanceEntry(account *Account, difference Money) *JournalEntry {
+	openedAt := account.CreatedAt
+	if openedAt.IsZero() {
+		openedAt = account.ID.Timestamp()
+	}
+	return &JournalEntry{
+		ID: primitive.NewObjectID(),
+		Postings: []Posting{
+			systemPosting(OpeningBalance, -difference, account.Currency),
+			accountPosting(account.ID, difference, account.Currency),
+		},
+		CreatedAt: openedAt,
+	}
+}
+func (db *DB) migrateOpeningBalances(ctx context.Context) error {
+	if err := db.journalTransactions(ctx); err != nil {
+		return err
+	}
+	return db.forEachLedgerDrift(ctx, func(account *Account, difference Money) error {
+		if _, err := db.Db.Collection("journal").InsertOne(ctx, newOpeningBalanceEntry(account, difference)); err != nil {
+			return err
+		}
+		utils.Logger(ctx).Info("posted opening balance", "amount", difference.String(), "currency", account.Currency, "account_number", account.AccountNumber)
+		return nil
+	})
+}
+
+// journalTransactions posts the journal entries of transactions from before the ledger without touching balances, so
+// statements list them against a ledger balance that already contains them.
+func (db *DB) journalTransactions(ctx context.Context) error {
+	cursor, err := db.Db.Collection("transactions").Find(ctx, primitive.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		transaction := &Transaction{}
+		if err := cursor.Decode(transaction); err != nil {
+			return err
+		}
+		journaled, err := db.Db.Collection("journal").CountDocuments(ctx, primitive.M{"transaction_id": transaction.ID})
+		if err != nil {
+			return err
+		}
+		if journaled > 0 {
+			continue
+		}
+		entry, err := NewJournalEntry(transaction)
+		if err != nil {
+			utils.Logger(ctx).Warn("could not journal transaction", "transaction_id", transaction.ID.Hex(), "error", err)
+			continue
+		}
+		if _, err := db.Db.Collection("journal").InsertOne(ctx, entry); err != nil {
+			return err
+		}
+		count++
+	}
+	if count > 0 {
+		utils.Logger(ctx).Info("journaled transactions from before the ledger", "count", count)
+	}
+	return cursor.Err()
+}
+func (db *DB) checkLedgerDrift(ctx context.Context) error {
+	return db.forEachLedgerDrift(ctx, func(account *Account, difference Money) error {
+		utils.Logger(ctx).Warn("account balance differs from the ledger", "difference", difference.String(), "currency", account.Currency, "account_number", account.AccountNumber)
+		return nil
+	})
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewJournalEntry(t *testing.T) {
+	from, to := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, tc := range []struct {
+		name        string
+		transaction *Transaction
+		accounts    map[primitive.ObjectID]Money
+		system      map[SystemAccount]Money
+		err         error
+	}{
+		{
+			name:        "deposit",
+			transaction: &Transaction{Type: Deposit, Amount: 1000, Currency: "EUR", ToAccount: to},
+			accounts:    map[primitive.ObjectID]Money{to: 1000},
+			system:      map[SystemAccount]Money{CashIn: -1000},
+		},
+		{
+			name:        "payout",
+			transaction: &Transaction{Type: Payout, Amount: 250, Currency: "EUR", FromAccount: from},
+			accounts:    map[primitive.ObjectID]Money{from: -250},
+			system:      map[SystemAccount]Money{CashOut: 250},
+		},
+		{
+			name:        "transfer",
+			transaction: &Transaction{Type: Transfer, Amount: 1, Currency: "EUR", FromAccount: from, ToAccount: to},
+			accounts:    map[primitive.ObjectID]Money{from: -1, to: 1},
+			system:      map[SystemAccount]Money{},
+		},
+		{
+			name: "transfer with exchange",
+			transaction: &Transaction{Type: Transfer, Amount: 1000, Currency: "EUR", FromAccount: from, ToAccount: to,
+				ExchangeRate: "1.1", ConvertedAmount: 1100, ConvertedCurrency: "USD"},
+			accounts: map[primitive.ObjectID]Money{from: -1000, to: 1100},
+			system:   map[SystemAccount]Money{FXClearing: 1000 - 1100},
+		},
+		{
+			name:        "reversal",
+			transaction: &Transaction{Type: Reversal, Amount: 1000, Currency: "EUR", FromAccount: to, ToAccount: from},
+			err:         utils.INVALID_TRANSACTION_TYPE,
+		},
+		{
+			name:        "zero amount",
+			transaction: &Transaction{Type: Deposit, Amount: 0, Currency: "EUR", ToAccount: to},
+			err:         utils.UNBALANCED_JOURNAL_ENTRY,
+		},
+		{
+			name:        "unsupported currency",
+			transaction: &Transaction{Type: Deposit, Amount: 1000, Currency: "XXX", ToAccount: to},
+			err:         utils.UNBALANCED_JOURNAL_ENTRY,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.transaction.ID = primitive.NewObjectID()
+			entry, err := NewJournalEntry(tc.transaction)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("error = %v, want %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			if entry.TransactionID != tc.transaction.ID {
+				t.Errorf("transaction id = %s, want %s", entry.TransactionID.Hex(), tc.transaction.ID.Hex())
+			}
+			accounts, system := map[primitive.ObjectID]Money{}, map[SystemAccount]Money{}
+			for _, posting := range entry.Postings {
+				if posting.SystemAccount != "" {
+					system[posting.SystemAccount] += posting.Amount
+				} else {
+					accounts[posting.Account] += posting.Amount
+				}
+			}
+			for id, want := range tc.accounts {
+				if accounts[id] != want {
+					t.Errorf("account %s = %s, want %s", id.Hex(), accounts[id], want)
+				}
+			}
+			for account, want := range tc.system {
+				if system[account] != want {
+					t.Errorf("%s = %s, want %s", account, system[account], want)
+				}
+			}
+		})
+	}
+}
+
+func TestJournalEntryValidate(t *testing.T) {
+	account := primitive.NewObjectID()
+	for _, tc := range []struct {
+		name     string
+		postings []Posting
+		valid    bool
+	}{
+		{"balanced", []Posting{systemPosting(CashIn, -100, "EUR"), accountPosting(account, 100, "EUR")}, true},
+		{"balanced per currency", []Posting{
+			accountPosting(account, -100, "EUR"), systemPosting(FXClearing, 100, "EUR"),
+			systemPosting(FXClearing, -110, "USD"), accountPosting(account, 110, "USD"),
+		}, true},
+		{"single posting", []Posting{accountPosting(account, 100, "EUR")}, false},
+		{"unbalanced", []Posting{systemPosting(CashIn, -100, "EUR"), accountPosting(account, 99, "EUR")}, false},
+		{"balanced across currencies only", []Posting{systemPosting(CashIn, -100, "EUR"), accountPosting(account, 100, "USD")}, false},
+		{"zero amounts", []Posting{systemPosting(CashIn, 0, "EUR"), accountPosting(account, 0, "EUR")}, false},
+		{"account and system account", []Posting{
+			{Account: account, SystemAccount: CashIn, Amount: -100, Currency: "EUR"}, accountPosting(account, 100, "EUR"),
+		}, false},
+		{"neither account nor system account", []Posting{{Amount: -100, Currency: "EUR"}, accountPosting(account, 100, "EUR")}, false},
+	} {
+		err := (&JournalEntry{Postings: tc.postings}).Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, utils.UNBALANCED_JOURNAL_ENTRY) {
+			t.Errorf("%s: error = %v, want %v", tc.name, err, utils.UNBALANCED_JOURNAL_ENTRY)
+		}
+	}
+}
+
+func TestNewReversalNegatesPostings(t *testing.T) {
+	original := &Transaction{ID: primitive.NewObjectID(), Type: Transfer, Amount: 1000, Currency: "EUR",
+		FromAccount: primitive.NewObjectID(), ToAccount: primitive.NewObjectID(),
+		ExchangeRate: "1.1", ConvertedAmount: 1100, ConvertedCurrency: "USD"}
+	originalEntry, err := NewJournalEntry(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversal, entry, err := NewReversal(original, "duplicate payment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.TransactionID != reversal.ID || *reversal.ReversalOf != original.ID {
+		t.Errorf("reversal is not linked to the original transaction")
+	}
+	for i, posting := range entry.Postings {
+		if posting.Amount != -originalEntry.Postings[i].Amount {
+			t.Errorf("posting %d = %s, want %s", i, posting.Amount, -originalEntry.Postings[i].Amount)
+		}
+	}
+
+	original.ReversedBy = &reversal.ID
+	if _, _, err := NewReversal(original, "duplicate payment"); !errors.Is(err, utils.TRANSACTION_ALREADY_REVERSED) {
+		t.Errorf("second reversal error = %v, want %v", err, utils.TRANSACTION_ALREADY_REVERSED)
+	}
+	if _, _, err := NewReversal(reversal, "duplicate payment"); !errors.Is(err, utils.CANNOT_REVERSE_REVERSAL) {
+		t.Errorf("reversal of a reversal error = %v, want %v", err, utils.CANNOT_REVERSE_REVERSAL)
+	}
+}
+
+func TestMigratedAccountStatementBalances(t *testing.T) {
+	m := NewMemoryStore()
+	opened := time.Date(2024, time.January, 10, 9, 0, 0, 0, time.UTC)
+	account := &Account{ID: primitive.NewObjectID(), Currency: "EUR", Balance: 17500, CreatedAt: opened}
+	other := &Account{ID: primitive.NewObjectID(), Currency: "EUR", Balance: 2500, CreatedAt: opened}
+	m.accounts[account.ID] = account
+	m.accounts[other.ID] = other
+	m.transactions = []*Transaction{
+		{ID: primitive.NewObjectID(), Type: Deposit, Amount: 10000, Currency: "EUR", ToAccount: account.ID, CreatedAt: opened.AddDate(0, 1, 0)},
+		{ID: primitive.NewObjectID(), Type: Transfer, Amount: 2500, Currency: "EUR", FromAccount: account.ID, ToAccount: other.ID, CreatedAt: opened.AddDate(0, 2, 0)},
+		{ID: primitive.NewObjectID(), Type: Deposit, Amount: 5000, Currency: "EUR", ToAccount: account.ID, CreatedAt: opened.AddDate(0, 3, 0)},
+	}
+
+	// The same steps as migrateOpeningBalances: journal the old transactions, then post what they leave unexplained.
+	for _, transaction := range m.transactions {
+		entry, err := NewJournalEntry(transaction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.journal = append(m.journal, entry)
+	}
+	for _, a := range []*Account{account, other} {
+		ledgerBalance, err := m.GetLedgerBalance(context.Background(), a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if difference := a.Balance - ledgerBalance; difference != 0 {
+			m.journal = append(m.journal, newOpeningBalanceEntry(a, difference))
+		}
+	}
+
+	for _, period := range []struct{ from, to time.Time }{
+		{opened.AddDate(0, 0, -1), opened.AddDate(1, 0, 0)},
+		{opened.AddDate(0, 1, 15), opened.AddDate(0, 2, 15)},
+		{opened.AddDate(0, 2, 15), time.Now()},
+	} {
+		statement, err := m.GetStatement(context.Background(), account, period.from, period.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		balance := statement.OpeningBalance
+		for _, line := range statement.Lines {
+			balance += line.Amount
+		}
+		if balance != statement.ClosingBalance {
+			t.Errorf("%s - %s: opening %s plus lines is %s, closing is %s", period.from, period.to, statement.OpeningBalance, balance, statement.ClosingBalance)
+		}
+	}
+	statement, err := m.GetStatement(context.Background(), account, opened.AddDate(0, 0, 1), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.OpeningBalance != 5000 || statement.ClosingBalance != account.Balance {
+		t.Errorf("opening %s, closing %s, want 50.00 and %s", statement.OpeningBalance, statement.ClosingBalance, account.Balance)
+	}
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/mathis-k/bank-api/tracing"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+type AuthEventType string
+
+const (
+	LoginSucceeded    AuthEventType = "login_succeeded"
+	LoginFailed       AuthEventType = "login_failed"
+	LoginLocked       AuthEventType = "login_locked"
+	LoginBlocked      AuthEventType = "login_blocked"
+	PasswordChanged   AuthEventType = "password_changed"
+	PasswordRecovered AuthEventType = "password_reset"
+	APIKeyCreated     AuthEventType = "api_key_created"
+	APIKeyRevoked     AuthEventType = "api_key_revoked"
+)
+
+type LoginAttempt struct {
+	Key         string     `bson:"_id"`
+	Failures    int        `bson:"failures"`
+	LastFailure time.Time  `bson:"last_failure"`
+	LockedUntil *time.Time `bson:"locked_until,omitempty"`
+	ExpiresAt   time.Time  `bson:"expires_at"`
+}
+
+type LoginPolicy struct {
+	MaxAttempts int
+	Window      time.Duration
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+}
+
+type AuthEvent struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Type      AuthEventType       `bson:"type" json:"type"`
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Email     string              `bson:"email,omitempty" json:"email,omitempty"`
+	IP        string              `bson:"ip" json:"ip"`
+	UserAgent string              `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Detail    string              `bson:"detail,omitempty" json:"detail,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+}
+
+func EmailAttemptKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IPAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+func PasswordResetAttemptKey(key string) string {
+	return "password_reset:" + key
+}
+
+func (p LoginPolicy) Lockout(failures int) time.Duration {
+	if failures < p.MaxAttempts {
+		return 0
+	}
+	lockout := p.BaseLockout
+	for i := p.MaxAttempts; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > p.MaxLockout {
+		return p.MaxLockout
+	}
+	return lockout
+}
+
+func (a *LoginAttempt) Locked(now time.Time) (time.Duration, bool) {
+	if a == nil || a.LockedUntil == nil || !now.Before(*a.LockedUntil) {
+		return 0, false
+	}
+	return a.LockedUntil.Sub(now), true
+}
+
+func (a *LoginAttempt) recordFailure(now time.Time, policy LoginPolicy) {
+	if !now.Before(a.ExpiresAt) {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailure = now
+	a.LockedUntil = nil
+	a.ExpiresAt = now.Add(policy.Window)
+	if lockout := policy.Lockout(a.Failures); lockout > 0 {
+		lockedUntil := now.Add(lockout)
+		a.LockedUntil = &lockedUntil
+		a.ExpiresAt = lockedUntil.Add(policy.Window)
+	}
+}
+
+func (db *DB) GetLoginAttempt(ctx context.Context, key string) (_ *LoginAttempt, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetLoginAttempt")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	attempt := &LoginAttempt{}
+	err = db.Db.Collection("login_attempts").FindOne(ctx, primitive.M{"_id": key}).Decode(attempt)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+func (db *DB) RecordLoginFailure(ctx context.Context, key string, policy LoginPolicy) (_ *LoginAttempt, err error) {
+	ctx, span := tracing.Start(ctx, "DB.RecordLoginFailure")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	now := time.Now()
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: primitive.M{
+			"failures": primitive.M{"$cond": primitive.A{
+				primitive.M{"$gt": primitive.A{"$expires_at", now}},
+				primitive.M{"$add": primitive.A{"$failures", 1}},
+				1,
+			}},
+			"last_failure": now,
+		}}},
+	}
+	attempt := &LoginAttempt{}
+	err = db.Db.Collection("login_attempts").FindOneAndUpdate(ctx, primitive.M{"_id": key}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt.ExpiresAt = now.Add(policy.Window)
+	set := primitive.M{"expires_at": attempt.ExpiresAt}
+	if lockout := policy.Lockout(attempt.Failures); lockout > 0 {
+		lockedUntil := now.Add(lockout)
+		attempt.LockedUntil = &lockedUntil
+		attempt.ExpiresAt = lockedUntil.Add(policy.Window)
+		set = primitive.M{"locked_until": lockedUntil, "expires_at": attempt.ExpiresAt}
+	}
+	_, err = db.Db.Collection("login_attempts").UpdateOne(ctx, primitive.M{"_id": key}, primitive.M{"$set": set})
+	if err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+func (db *DB) ResetLoginAttempts(ctx context.Context, key string) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.ResetLoginAttempts")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("login_attempts").DeleteOne(ctx, primitive.M{"_id": key})
+	return err
+}
+
+func (db *DB) CreateAuthEvent(ctx context.Context, event *AuthEvent) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateAuthEvent")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	_, err = db.Db.Collection("auth_events").InsertOne(ctx, event)
+	return err
+}
+func (db *DB) GetAuthEvents(ctx context.Context, uId primitive.ObjectID, limit int64) (_ []*AuthEvent, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetAuthEvents")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	opts := options.Find().SetSort(primitive.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+	cursor, err := db.Db.Collection("auth_events").Find(ctx, primitive.M{"user_id": uId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := []*AuthEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
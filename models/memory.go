@@ -0,0 +1,987 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryStore struct {
+	mu           sync.RWMutex
+	users        map[primitive.ObjectID]*User
+	accounts     map[primitive.ObjectID]*Account
+	transactions []*Transaction
+	journal      []*JournalEntry
+	keys         map[string]*IdempotencyKey
+	orders       map[primitive.ObjectID]*StandingOrder
+	executions   []*StandingOrderExecution
+	refresh      map[string]*RefreshToken
+	revocations  []*Revocation
+	userTokens   []*UserToken
+	attempts     map[string]*LoginAttempt
+	authEvents   []*AuthEvent
+	auditLog     []*AuditEntry
+	apiKeys      map[primitive.ObjectID]*APIKey
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:        map[primitive.ObjectID]*User{},
+		accounts:     map[primitive.ObjectID]*Account{},
+		transactions: []*Transaction{},
+		journal:      []*JournalEntry{},
+		keys:         map[string]*IdempotencyKey{},
+		orders:       map[primitive.ObjectID]*StandingOrder{},
+		executions:   []*StandingOrderExecution{},
+		refresh:      map[string]*RefreshToken{},
+		revocations:  []*Revocation{},
+		userTokens:   []*UserToken{},
+		attempts:     map[string]*LoginAttempt{},
+		authEvents:   []*AuthEvent{},
+		auditLog:     []*AuditEntry{},
+		apiKeys:      map[primitive.ObjectID]*APIKey{},
+	}
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.Accounts = append([]primitive.ObjectID{}, u.Accounts...)
+	if u.TOTP != nil {
+		c.TOTP = u.TOTP.clone()
+	}
+	return &c
+}
+func (a *Account) clone() *Account {
+	c := *a
+	return &c
+}
+func (t *Transaction) clone() *Transaction {
+	c := *t
+	if t.ReversedBy != nil {
+		reversedBy := *t.ReversedBy
+		c.ReversedBy = &reversedBy
+	}
+	return &c
+}
+
+func (m *MemoryStore) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, userRequest *UserRequest) (*User, error) {
+	password, err := utils.HashPassword(userRequest.Password)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Email == userRequest.Email {
+			return nil, utils.EMAIL_ALREADY_EXISTS
+		}
+	}
+	user := &User{
+		ID:        primitive.NewObjectID(),
+		FirstName: userRequest.FirstName,
+		LastName:  userRequest.LastName,
+		Email:     userRequest.Email,
+		Password:  password,
+		Role:      RoleCustomer,
+		Accounts:  []primitive.ObjectID{},
+		CreatedAt: time.Now(),
+	}
+	m.users[user.ID] = user
+	return user.clone(), nil
+}
+func (m *MemoryStore) GetUserById(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[id]
+	if !ok {
+		return nil, utils.USER_NOT_FOUND
+	}
+	return user.clone(), nil
+}
+func (m *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, user := range m.users {
+		if user.Email == email {
+			return user.clone(), nil
+		}
+	}
+	return nil, utils.USER_NOT_FOUND
+}
+func (m *MemoryStore) UpdateUser(ctx context.Context, uId primitive.ObjectID, userUpdate *UserUpdate) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok {
+		return nil, utils.USER_NOT_FOUND
+	}
+	if userUpdate.Email != "" && userUpdate.Email != user.Email {
+		for _, u := range m.users {
+			if u.Email == userUpdate.Email {
+				return nil, utils.EMAIL_ALREADY_EXISTS
+			}
+		}
+	}
+	if userUpdate.FirstName != "" {
+		user.FirstName = userUpdate.FirstName
+	}
+	if userUpdate.LastName != "" {
+		user.LastName = userUpdate.LastName
+	}
+	if userUpdate.Email != "" && userUpdate.Email != user.Email {
+		user.Email = userUpdate.Email
+		user.EmailVerified = false
+	}
+	return user.clone(), nil
+}
+func (m *MemoryStore) SetPassword(ctx context.Context, uId primitive.ObjectID, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok {
+		return utils.USER_NOT_FOUND
+	}
+	user.Password = passwordHash
+	return nil
+}
+func (m *MemoryStore) MarkEmailVerified(ctx context.Context, uId primitive.ObjectID, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok || user.Email != email {
+		return utils.INVALID_USER_TOKEN
+	}
+	user.EmailVerified = true
+	return nil
+}
+func (m *MemoryStore) DeleteUser(ctx context.Context, uId primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, uId)
+	return nil
+}
+func (m *MemoryStore) LoginUser(ctx context.Context, userLogin *UserLogin) (*User, error) {
+	user, err := m.GetUserByEmail(ctx, userLogin.Email)
+	if errors.Is(err, utils.USER_NOT_FOUND) {
+		utils.CheckDummyPasswordHash(userLogin.Password)
+		return nil, utils.INVALID_CREDENTIALS
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !utils.CheckPasswordHash(userLogin.Password, user.Password) {
+		return nil, utils.INVALID_CREDENTIALS
+	}
+	rehashPassword(ctx, m.SetPassword, user, userLogin.Password)
+	return user, nil
+}
+func (m *MemoryStore) AddAccountToUser(ctx context.Context, uId primitive.ObjectID, aId primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok {
+		return utils.USER_NOT_FOUND
+	}
+	if !user.HasAccount(aId) {
+		user.Accounts = append(user.Accounts, aId)
+	}
+	return nil
+}
+func (m *MemoryStore) RemoveAccountFromUser(ctx context.Context, uId primitive.ObjectID, aId primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok {
+		return utils.USER_NOT_FOUND
+	}
+	for i, account := range user.Accounts {
+		if account == aId {
+			user.Accounts = append(user.Accounts[:i], user.Accounts[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateAccount(ctx context.Context, currency Currency) (*Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for attempt := 0; attempt < AccountNumberAttempts; attempt++ {
+		account, err := newAccount(currency)
+		if err != nil {
+			return nil, err
+		}
+		if m.accountByNumber(account.AccountNumber) != nil {
+			continue
+		}
+		m.accounts[account.ID] = account
+		return account.clone(), nil
+	}
+	return nil, utils.ACCOUNT_NUMBER_EXHAUSTED
+}
+func (m *MemoryStore) accountByNumber(accountNumber uint64) *Account {
+	for _, account := range m.accounts {
+		if account.AccountNumber == accountNumber {
+			return account
+		}
+	}
+	return nil
+}
+func (m *MemoryStore) GetAccountById(ctx context.Context, aId primitive.ObjectID) (*Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	account, ok := m.accounts[aId]
+	if !ok {
+		return nil, utils.ACCOUNT_NOT_FOUND
+	}
+	return account.clone(), nil
+}
+func (m *MemoryStore) GetAccountByAccountNumber(ctx context.Context, accountNumber uint64) (*Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	account := m.accountByNumber(accountNumber)
+	if account == nil {
+		return nil, utils.ACCOUNT_NOT_FOUND
+	}
+	return account.clone(), nil
+}
+func (m *MemoryStore) GetAccountByLegacyAccountNumber(ctx context.Context, legacyAccountNumber uint64) (*Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, account := range m.accounts {
+		if account.LegacyAccountNumber != 0 && account.LegacyAccountNumber == legacyAccountNumber {
+			return account.clone(), nil
+		}
+	}
+	return nil, utils.ACCOUNT_NOT_FOUND
+}
+func (m *MemoryStore) GetAccountsFromUser(ctx context.Context, uId primitive.ObjectID) ([]*Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[uId]
+	if !ok {
+		return nil, utils.USER_NOT_FOUND
+	}
+	accounts := []*Account{}
+	for _, accountID := range user.Accounts {
+		account, ok := m.accounts[accountID]
+		if !ok {
+			return nil, utils.ACCOUNT_NOT_FOUND
+		}
+		accounts = append(accounts, account.clone())
+	}
+	return accounts, nil
+}
+func (m *MemoryStore) GetAccountNumbers(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	accountNumbers := map[primitive.ObjectID]uint64{}
+	for _, id := range ids {
+		if account, ok := m.accounts[id]; ok {
+			accountNumbers[id] = account.AccountNumber
+		}
+	}
+	return accountNumbers, nil
+}
+func (m *MemoryStore) SetAccountFrozen(ctx context.Context, aId primitive.ObjectID, frozen bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	account, ok := m.accounts[aId]
+	if !ok {
+		return utils.ACCOUNT_NOT_FOUND
+	}
+	account.Frozen = frozen
+	return nil
+}
+func (m *MemoryStore) DeleteAccount(ctx context.Context, aId primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.accounts, aId)
+	return nil
+}
+
+func (m *MemoryStore) CreateTransaction(ctx context.Context, transactionRequest *TransactionRequest) (*Transaction, error) {
+	transaction := NewTransaction(transactionRequest)
+	entry, err := NewJournalEntry(transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.idempotencyKeyToClaim(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.postJournalEntry(entry); err != nil {
+		return nil, err
+	}
+	m.transactions = append(m.transactions, transaction)
+	if key != nil {
+		key.TransactionID = &transaction.ID
+	}
+	return transaction.clone(), nil
+}
+func (m *MemoryStore) FindTransactions(ctx context.Context, query *TransactionQuery) (*TransactionPage, error) {
+	m.mu.RLock()
+	transactions := []*Transaction{}
+	for _, transaction := range m.transactions {
+		if query.matches(transaction) {
+			transactions = append(transactions, transaction.clone())
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(transactions, func(i, j int) bool { return query.scanLess(transactions[i], transactions[j]) })
+	if len(transactions) > query.Limit+1 {
+		transactions = transactions[:query.Limit+1]
+	}
+	return query.page(transactions), nil
+}
+func (m *MemoryStore) GetStatement(ctx context.Context, account *Account, from time.Time, to time.Time) (*Statement, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var openingBalance, closingBalance Money
+	for _, entry := range m.journal {
+		for _, posting := range entry.Postings {
+			if posting.Account != account.ID {
+				continue
+			}
+			if entry.CreatedAt.Before(from) || (entry.TransactionID.IsZero() && entry.CreatedAt.Before(to)) {
+				openingBalance += posting.Amount
+			}
+			if entry.CreatedAt.Before(to) {
+				closingBalance += posting.Amount
+			}
+		}
+	}
+	transactions := []*Transaction{}
+	counterparties := map[primitive.ObjectID]uint64{}
+	for _, transaction := range m.transactions {
+		if transaction.FromAccount != account.ID && transaction.ToAccount != account.ID {
+			continue
+		}
+		if transaction.CreatedAt.Before(from) || !transaction.CreatedAt.Before(to) {
+			continue
+		}
+		transactions = append(transactions, transaction.clone())
+		if counterparty, ok := m.accounts[transaction.CounterpartyOf(account.ID)]; ok {
+			counterparties[counterparty.ID] = counterparty.AccountNumber
+		}
+	}
+	sort.SliceStable(transactions, func(i, j int) bool { return transactions[i].CreatedAt.Before(transactions[j].CreatedAt) })
+	return NewStatement(account, from, to, openingBalance, closingBalance, transactions, counterparties), nil
+}
+func (m *MemoryStore) ReverseTransaction(ctx context.Context, tId primitive.ObjectID, reason string) (*Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var original *Transaction
+	for _, transaction := range m.transactions {
+		if transaction.ID == tId {
+			original = transaction
+			break
+		}
+	}
+	if original == nil {
+		return nil, utils.TRANSACTION_NOT_FOUND
+	}
+	reversal, entry, err := NewReversal(original, reason)
+	if err != nil {
+		return nil, err
+	}
+	key, err := m.idempotencyKeyToClaim(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.postJournalEntry(entry); err != nil {
+		if errors.Is(err, utils.INSUFFICIENT_FUNDS) {
+			return nil, utils.REVERSAL_INSUFFICIENT_FUNDS
+		}
+		return nil, err
+	}
+	original.ReversedBy = &reversal.ID
+	m.transactions = append(m.transactions, reversal)
+	if key != nil {
+		key.TransactionID = &reversal.ID
+	}
+	return reversal.clone(), nil
+}
+func (m *MemoryStore) postJournalEntry(entry *JournalEntry) error {
+	for _, posting := range entry.Postings {
+		if posting.Account == primitive.NilObjectID {
+			continue
+		}
+		account, ok := m.accounts[posting.Account]
+		if !ok || account.Currency != posting.Currency {
+			if posting.Amount < 0 {
+				return utils.INSUFFICIENT_FUNDS
+			}
+			return utils.ACCOUNT_NOT_FOUND
+		}
+		if account.Frozen {
+			return utils.ACCOUNT_FROZEN
+		}
+		if account.Balance+posting.Amount < 0 {
+			return utils.INSUFFICIENT_FUNDS
+		}
+	}
+	for _, posting := range entry.Postings {
+		if posting.Account != primitive.NilObjectID {
+			m.accounts[posting.Account].Balance += posting.Amount
+		}
+	}
+	m.journal = append(m.journal, entry)
+	return nil
+}
+func (m *MemoryStore) GetLedgerBalance(ctx context.Context, aId primitive.ObjectID) (Money, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var balance Money
+	for _, entry := range m.journal {
+		for _, posting := range entry.Postings {
+			if posting.Account == aId {
+				balance += posting.Amount
+			}
+		}
+	}
+	return balance, nil
+}
+func (m *MemoryStore) GetTransactionById(ctx context.Context, tId primitive.ObjectID) (*Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, transaction := range m.transactions {
+		if transaction.ID == tId {
+			return transaction.clone(), nil
+		}
+	}
+	return nil, utils.TRANSACTION_NOT_FOUND
+}
+func idempotencyKeyId(uId primitive.ObjectID, key string) string {
+	return uId.Hex() + ":" + key
+}
+func (m *MemoryStore) ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey) (*IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := idempotencyKeyId(key.UserID, key.Key)
+	now := time.Now()
+	if existing, ok := m.keys[id]; ok && existing.ExpiresAt.After(now) && (existing.Completed || existing.TransactionID != nil || existing.LockedUntil.After(now)) {
+		c := *existing
+		return &c, nil
+	}
+	key.ID = primitive.NewObjectID()
+	c := *key
+	m.keys[id] = &c
+	return nil, nil
+}
+func (m *MemoryStore) idempotencyKeyToClaim(ctx context.Context) (*IdempotencyKey, error) {
+	key := idempotencyKeyFromContext(ctx)
+	if key == nil {
+		return nil, nil
+	}
+	existing, ok := m.keys[idempotencyKeyId(key.UserID, key.Key)]
+	if !ok || existing.ID != key.ID || existing.Completed || existing.TransactionID != nil {
+		return nil, utils.IDEMPOTENCY_KEY_IN_USE
+	}
+	return existing, nil
+}
+func (m *MemoryStore) CompleteIdempotencyKey(ctx context.Context, key *IdempotencyKey, statusCode int, response []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.keys[idempotencyKeyId(key.UserID, key.Key)]; ok && existing.ID == key.ID {
+		existing.Completed = true
+		existing.StatusCode = statusCode
+		existing.Response = append([]byte{}, response...)
+	}
+	return nil
+}
+func (m *MemoryStore) ReleaseIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := idempotencyKeyId(key.UserID, key.Key)
+	if existing, ok := m.keys[id]; ok && existing.ID == key.ID && existing.TransactionID == nil {
+		delete(m.keys, id)
+	}
+	return nil
+}
+
+func (t *RefreshToken) clone() *RefreshToken {
+	c := *t
+	return &c
+}
+
+func (m *MemoryStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refresh[token.TokenHash] = token.clone()
+	return nil
+}
+func (m *MemoryStore) RotateRefreshToken(ctx context.Context, tokenHash string, next *RefreshToken) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.refresh[tokenHash]
+	if !ok {
+		return nil, utils.INVALID_REFRESH_TOKEN
+	}
+	if !current.active(time.Now()) {
+		if current.ReplacedBy != nil {
+			m.revokeRefreshTokenFamily(ctx, current.FamilyID)
+			return nil, utils.REFRESH_TOKEN_REUSED
+		}
+		return nil, utils.INVALID_REFRESH_TOKEN
+	}
+
+	current.ReplacedBy = &next.ID
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	m.refresh[next.TokenHash] = next.clone()
+	return current.clone(), nil
+}
+func (m *MemoryStore) RevokeRefreshToken(ctx context.Context, uId primitive.ObjectID, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.refresh[tokenHash]
+	if !ok || token.UserID != uId {
+		return utils.INVALID_REFRESH_TOKEN
+	}
+	m.revokeRefreshTokenFamily(ctx, token.FamilyID)
+	return nil
+}
+func (m *MemoryStore) revokeRefreshTokenFamily(ctx context.Context, familyId primitive.ObjectID) {
+	now := time.Now()
+	for _, token := range m.refresh {
+		if token.FamilyID == familyId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+}
+func (m *MemoryStore) RevokeUserRefreshTokens(ctx context.Context, uId primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, token := range m.refresh {
+		if token.UserID == uId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+func (m *MemoryStore) CreateRevocation(ctx context.Context, revocation *Revocation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if revocation.ID.IsZero() {
+		revocation.ID = primitive.NewObjectID()
+	}
+	c := *revocation
+	m.revocations = append(m.revocations, &c)
+	return nil
+}
+func (m *MemoryStore) GetRevocations(ctx context.Context, since time.Time) ([]*Revocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	revocations := []*Revocation{}
+	for _, revocation := range m.revocations {
+		if !revocation.RevokedAt.Before(since) && revocation.ExpiresAt.After(now) {
+			c := *revocation
+			revocations = append(revocations, &c)
+		}
+	}
+	return revocations, nil
+}
+
+func (o *StandingOrder) clone() *StandingOrder {
+	c := *o
+	return &c
+}
+func (m *MemoryStore) CreateStandingOrder(ctx context.Context, aId primitive.ObjectID, request *StandingOrderRequest) (*StandingOrder, error) {
+	order, err := NewStandingOrder(aId, request)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.orders[order.ID] = order
+	return order.clone(), nil
+}
+func (m *MemoryStore) GetStandingOrderById(ctx context.Context, oId primitive.ObjectID) (*StandingOrder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	order, ok := m.orders[oId]
+	if !ok {
+		return nil, utils.STANDING_ORDER_NOT_FOUND
+	}
+	return order.clone(), nil
+}
+func (m *MemoryStore) GetStandingOrdersFromAccount(ctx context.Context, aId primitive.ObjectID) ([]*StandingOrder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	orders := []*StandingOrder{}
+	for _, order := range m.orders {
+		if order.AccountID == aId {
+			orders = append(orders, order.clone())
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	return orders, nil
+}
+func (m *MemoryStore) UpdateStandingOrder(ctx context.Context, oId primitive.ObjectID, update *StandingOrderUpdate) (*StandingOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, ok := m.orders[oId]
+	if !ok {
+		return nil, utils.STANDING_ORDER_NOT_FOUND
+	}
+	updated := order.clone()
+	if err := updated.ApplyUpdate(update); err != nil {
+		return nil, err
+	}
+	m.orders[oId] = updated
+	return updated.clone(), nil
+}
+func (m *MemoryStore) DeleteStandingOrder(ctx context.Context, oId primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.orders, oId)
+	return nil
+}
+func (m *MemoryStore) ClaimDueStandingOrder(ctx context.Context, now time.Time) (*StandingOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due *StandingOrder
+	for _, order := range m.orders {
+		if !order.Active || order.NextRunAt == nil || order.NextRunAt.After(now) || order.LockedUntil.After(now) {
+			continue
+		}
+		if due == nil || order.NextRunAt.Before(*due.NextRunAt) {
+			due = order
+		}
+	}
+	if due == nil {
+		return nil, nil
+	}
+	due.LockedUntil = now.Add(StandingOrderLockTime)
+	return due.clone(), nil
+}
+func (m *MemoryStore) RecordStandingOrderExecution(ctx context.Context, order *StandingOrder, execution *StandingOrderExecution) error {
+	if err := order.Advance(execution); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.advanceStandingOrder(order, execution); err != nil {
+		return err
+	}
+	m.executions = append(m.executions, execution)
+	return nil
+}
+func (m *MemoryStore) ExecuteStandingOrder(ctx context.Context, order *StandingOrder, transactionRequest *TransactionRequest, execution *StandingOrderExecution) (*Transaction, error) {
+	transaction := NewTransaction(transactionRequest)
+	entry, err := NewJournalEntry(transaction)
+	if err != nil {
+		return nil, err
+	}
+	execution.Status = ExecutionSucceeded
+	execution.TransactionID = &transaction.ID
+	if err := order.Advance(execution); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.orders[order.ID]
+	if !ok || stored.NextRunAt == nil || !stored.NextRunAt.Equal(execution.ScheduledFor) {
+		return nil, utils.STANDING_ORDER_ALREADY_EXECUTED
+	}
+	if err := m.postJournalEntry(entry); err != nil {
+		return nil, err
+	}
+	m.transactions = append(m.transactions, transaction)
+	if err := m.advanceStandingOrder(order, execution); err != nil {
+		return nil, err
+	}
+	m.executions = append(m.executions, execution)
+	return transaction.clone(), nil
+}
+func (m *MemoryStore) advanceStandingOrder(order *StandingOrder, execution *StandingOrderExecution) error {
+	stored, ok := m.orders[order.ID]
+	if !ok || stored.NextRunAt == nil || !stored.NextRunAt.Equal(execution.ScheduledFor) {
+		return utils.STANDING_ORDER_ALREADY_EXECUTED
+	}
+	stored.NextRunAt = order.NextRunAt
+	stored.LastRunAt = order.LastRunAt
+	stored.LastStatus = order.LastStatus
+	stored.Active = order.Active
+	stored.LockedUntil = order.LockedUntil
+	return nil
+}
+func (m *MemoryStore) GetStandingOrderExecutions(ctx context.Context, oId primitive.ObjectID) ([]*StandingOrderExecution, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	executions := []*StandingOrderExecution{}
+	for i := len(m.executions) - 1; i >= 0; i-- {
+		if m.executions[i].OrderID == oId {
+			c := *m.executions[i]
+			executions = append(executions, &c)
+		}
+	}
+	return executions, nil
+}
+
+func (m *MemoryStore) SetTOTPSecret(ctx context.Context, uId primitive.ObjectID, secret string, recoveryCodes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok {
+		return utils.USER_NOT_FOUND
+	}
+	if user.TOTPEnabled() {
+		return utils.TOTP_ALREADY_ENABLED
+	}
+	user.TOTP = &TOTPSettings{Secret: secret, RecoveryCodes: append([]string{}, recoveryCodes...)}
+	return nil
+}
+func (m *MemoryStore) EnableTOTP(ctx context.Context, uId primitive.ObjectID, counter int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok || user.TOTP == nil || user.TOTP.Enabled {
+		return utils.TOTP_NOT_ENROLLED
+	}
+	now := time.Now()
+	user.TOTP.Enabled = true
+	user.TOTP.LastCounter = counter
+	user.TOTP.EnabledAt = &now
+	return nil
+}
+func (m *MemoryStore) DisableTOTP(ctx context.Context, uId primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[uId]; ok {
+		user.TOTP = nil
+	}
+	return nil
+}
+func (m *MemoryStore) UseTOTPCounter(ctx context.Context, uId primitive.ObjectID, counter int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok || !user.TOTPEnabled() || user.TOTP.LastCounter >= counter {
+		return utils.TOTP_CODE_REUSED
+	}
+	user.TOTP.LastCounter = counter
+	return nil
+}
+func (m *MemoryStore) UseRecoveryCode(ctx context.Context, uId primitive.ObjectID, codeHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok || !user.TOTPEnabled() {
+		return utils.INVALID_TOTP_CODE
+	}
+	for i, code := range user.TOTP.RecoveryCodes {
+		if code == codeHash {
+			user.TOTP.RecoveryCodes = append(user.TOTP.RecoveryCodes[:i], user.TOTP.RecoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+	return utils.INVALID_TOTP_CODE
+}
+
+func (m *MemoryStore) CreateUserToken(ctx context.Context, token *UserToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.userTokens {
+		if t.UserID == token.UserID && t.Purpose == token.Purpose && t.UsedAt == nil {
+			usedAt := token.CreatedAt
+			t.UsedAt = &usedAt
+		}
+	}
+	c := *token
+	m.userTokens = append(m.userTokens, &c)
+	return nil
+}
+func (m *MemoryStore) ConsumeUserToken(ctx context.Context, purpose UserTokenPurpose, tokenHash string) (*UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, t := range m.userTokens {
+		if t.TokenHash == tokenHash && t.Purpose == purpose && t.usable(now) {
+			t.UsedAt = &now
+			c := *t
+			return &c, nil
+		}
+	}
+	return nil, utils.INVALID_USER_TOKEN
+}
+
+func (m *MemoryStore) GetLoginAttempt(ctx context.Context, key string) (*LoginAttempt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	attempt, ok := m.attempts[key]
+	if !ok || !time.Now().Before(attempt.ExpiresAt) {
+		return nil, nil
+	}
+	c := *attempt
+	return &c, nil
+}
+func (m *MemoryStore) RecordLoginFailure(ctx context.Context, key string, policy LoginPolicy) (*LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	attempt, ok := m.attempts[key]
+	if !ok {
+		attempt = &LoginAttempt{Key: key}
+		m.attempts[key] = attempt
+	}
+	attempt.recordFailure(time.Now(), policy)
+	c := *attempt
+	return &c, nil
+}
+func (m *MemoryStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *MemoryStore) CreateAuthEvent(ctx context.Context, event *AuthEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	c := *event
+	m.authEvents = append(m.authEvents, &c)
+	return nil
+}
+func (m *MemoryStore) GetAuthEvents(ctx context.Context, uId primitive.ObjectID, limit int64) ([]*AuthEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	events := []*AuthEvent{}
+	for i := len(m.authEvents) - 1; i >= 0 && int64(len(events)) < limit; i-- {
+		if event := m.authEvents[i]; event.UserID != nil && *event.UserID == uId {
+			c := *event
+			events = append(events, &c)
+		}
+	}
+	return events, nil
+}
+func (m *MemoryStore) SearchUsers(ctx context.Context, query string, limit int64) ([]*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := []*User{}
+	for _, user := range m.users {
+		if user.matchesSearch(query) {
+			users = append(users, user.clone())
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	if int64(len(users)) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+func (m *MemoryStore) SetUserRole(ctx context.Context, uId primitive.ObjectID, role Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uId]
+	if !ok {
+		return utils.USER_NOT_FOUND
+	}
+	user.Role = role
+	return nil
+}
+
+func (m *MemoryStore) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	c := *entry
+	m.auditLog = append(m.auditLog, &c)
+	return nil
+}
+func (m *MemoryStore) GetAuditLog(ctx context.Context, query *AuditLogQuery) ([]*AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := []*AuditEntry{}
+	for i := len(m.auditLog) - 1; i >= 0 && int64(len(entries)) < query.Limit; i-- {
+		if entry := m.auditLog[i]; query.matches(entry) {
+			c := *entry
+			entries = append(entries, &c)
+		}
+	}
+	return entries, nil
+}
+
+func (k *APIKey) clone() *APIKey {
+	c := *k
+	c.Scopes = append([]Scope{}, k.Scopes...)
+	c.AllowedIPs = append([]string{}, k.AllowedIPs...)
+	return &c
+}
+
+func (m *MemoryStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	active := 0
+	for _, k := range m.apiKeys {
+		if k.UserID == key.UserID && k.RevokedAt == nil {
+			active++
+		}
+	}
+	if active >= MaxAPIKeysPerUser {
+		return utils.TOO_MANY_API_KEYS
+	}
+	m.apiKeys[key.ID] = key.clone()
+	return nil
+}
+func (m *MemoryStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.apiKeys {
+		if key.KeyHash == keyHash {
+			return key.clone(), nil
+		}
+	}
+	return nil, utils.INVALID_API_KEY
+}
+func (m *MemoryStore) GetAPIKeys(ctx context.Context, uId primitive.ObjectID) ([]*APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := []*APIKey{}
+	for _, key := range m.apiKeys {
+		if key.UserID == uId {
+			keys = append(keys, key.clone())
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+func (m *MemoryStore) RevokeAPIKey(ctx context.Context, uId primitive.ObjectID, kId primitive.ObjectID) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.apiKeys[kId]
+	if !ok || key.UserID != uId || key.RevokedAt != nil {
+		return nil, utils.API_KEY_NOT_FOUND
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	return key.clone(), nil
+}
+func (m *MemoryStore) TouchAPIKey(ctx context.Context, kId primitive.ObjectID, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key, ok := m.apiKeys[kId]; ok {
+		key.LastUsedAt = &usedAt
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type Money int64
+
+const (
+	MoneyScale      = 2
+	minorUnitFactor = 100
+)
+
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	if whole == "" || (hasPoint && fraction == "") || !isDigits(whole) || !isDigits(fraction) {
+		return 0, utils.INVALID_AMOUNT
+	}
+	if len(fraction) > MoneyScale {
+		return 0, utils.INVALID_AMOUNT_PRECISION
+	}
+	fraction += strings.Repeat("0", MoneyScale-len(fraction))
+
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, utils.INVALID_AMOUNT
+	}
+	if negative {
+		units = -units
+	}
+	return Money(units), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) String() string {
+	sign := ""
+	digits := strconv.FormatInt(int64(m), 10)
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) <= MoneyScale {
+		digits = strings.Repeat("0", MoneyScale-len(digits)+1) + digits
+	}
+	return fmt.Sprintf("%s%s.%s", sign, digits[:len(digits)-MoneyScale], digits[len(digits)-MoneyScale:])
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	s := string(data)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return utils.INVALID_AMOUNT
+		}
+	}
+	money, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = money
+	return nil
+}
+
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(int64(m))
+}
+
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.Int64:
+		*m = Money(raw.Int64())
+	case bsontype.Int32:
+		*m = Money(raw.Int32())
+	case bsontype.Double:
+		*m = Money(math.Round(raw.Double() * minorUnitFactor))
+	case bsontype.Decimal128:
+		coefficient, exp, err := raw.Decimal128().BigInt()
+		if err != nil {
+			return err
+		}
+		exp += MoneyScale
+		scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(absInt(exp))), nil)
+		if exp >= 0 {
+			coefficient.Mul(coefficient, scale)
+		} else if _, remainder := coefficient.QuoRem(coefficient, scale, new(big.Int)); remainder.Sign() != 0 {
+			return utils.INVALID_AMOUNT_PRECISION
+		}
+		if !coefficient.IsInt64() {
+			return utils.INVALID_AMOUNT
+		}
+		*m = Money(coefficient.Int64())
+	case bsontype.Null:
+		*m = 0
+	default:
+		return fmt.Errorf("cannot decode %v into Money", t)
+	}
+	return nil
+}
+
+func absInt(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func moneyMigrationPipeline(field string) primitive.A {
+	return primitive.A{
+		primitive.M{"$set": primitive.M{
+			field: primitive.M{"$toLong": primitive.M{"$round": primitive.A{
+				primitive.M{"$multiply": primitive.A{"$" + field, minorUnitFactor}}, 0,
+			}}},
+		}},
+	}
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseMoney(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  Money
+		err   error
+	}{
+		{"0", 0, nil},
+		{"12", 1200, nil},
+		{"12.3", 1230, nil},
+		{"12.34", 1234, nil},
+		{" 12.34 ", 1234, nil},
+		{"+1.05", 105, nil},
+		{"-1.05", -105, nil},
+		{"0.01", 1, nil},
+		{"92233720368547758.07", math.MaxInt64, nil},
+		{"12.345", 0, utils.INVALID_AMOUNT_PRECISION},
+		{"0.001", 0, utils.INVALID_AMOUNT_PRECISION},
+		{"", 0, utils.INVALID_AMOUNT},
+		{".5", 0, utils.INVALID_AMOUNT},
+		{"5.", 0, utils.INVALID_AMOUNT},
+		{"1,50", 0, utils.INVALID_AMOUNT},
+		{"1e3", 0, utils.INVALID_AMOUNT},
+		{"--1", 0, utils.INVALID_AMOUNT},
+		{"92233720368547758.08", 0, utils.INVALID_AMOUNT},
+	} {
+		got, err := ParseMoney(tc.input)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("ParseMoney(%q) error = %v, want %v", tc.input, err, tc.err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	for _, tc := range []struct {
+		money Money
+		json  string
+	}{
+		{0, `"0.00"`},
+		{5, `"0.05"`},
+		{-5, `"-0.05"`},
+		{1234, `"12.34"`},
+		{-100000, `"-1000.00"`},
+	} {
+		data, err := json.Marshal(tc.money)
+		if err != nil || string(data) != tc.json {
+			t.Errorf("json.Marshal(%d) = %s, %v, want %s", tc.money, data, err, tc.json)
+		}
+		var decoded Money
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != tc.money {
+			t.Errorf("json.Unmarshal(%s) = %d, %v, want %d", data, decoded, err, tc.money)
+		}
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte(`12.5`), &m); err != nil || m != 1250 {
+		t.Errorf("json.Unmarshal(12.5) = %d, %v, want 1250", m, err)
+	}
+	if err := json.Unmarshal([]byte(`"12.555"`), &m); !errors.Is(err, utils.INVALID_AMOUNT_PRECISION) {
+		t.Errorf("json.Unmarshal(\"12.555\") error = %v, want %v", err, utils.INVALID_AMOUNT_PRECISION)
+	}
+}
+
+func TestMoneyUnmarshalBSONValue(t *testing.T) {
+	decimal := func(s string) primitive.Decimal128 {
+		d, err := primitive.ParseDecimal128(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	for _, tc := range []struct {
+		name  string
+		value any
+		want  Money
+		err   error
+	}{
+		{"int64", int64(1234), 1234, nil},
+		{"int32", int32(-7), -7, nil},
+		{"legacy double", 12.34, 1234, nil},
+		{"legacy double rounding", 0.1 + 0.2, 30, nil},
+		{"decimal", decimal("12.34"), 1234, nil},
+		{"decimal with trailing zeros", decimal("12.3400"), 1234, nil},
+		{"decimal integer", decimal("12"), 1200, nil},
+		{"decimal exponent", decimal("1.5E+3"), 150000, nil},
+		{"negative decimal", decimal("-0.05"), -5, nil},
+		{"decimal beyond scale", decimal("12.345"), 0, utils.INVALID_AMOUNT_PRECISION},
+		{"tiny decimal", decimal("0.001"), 0, utils.INVALID_AMOUNT_PRECISION},
+		{"decimal out of range", decimal("1E+30"), 0, utils.INVALID_AMOUNT},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			typ, data, err := bson.MarshalValue(tc.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m Money
+			if err := m.UnmarshalBSONValue(typ, data); !errors.Is(err, tc.err) {
+				t.Fatalf("error = %v, want %v", err, tc.err)
+			}
+			if m != tc.want {
+				t.Errorf("got %d, want %d", m, tc.want)
+			}
+		})
+	}
+}
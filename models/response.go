@@ -0,0 +1,370 @@
+package models
+
+import (
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type UserResponse struct {
+	ID            primitive.ObjectID `json:"id"`
+	FirstName     string             `json:"first_name"`
+	LastName      string             `json:"last_name"`
+	Email         string             `json:"email"`
+	EmailVerified bool               `json:"email_verified"`
+	Role          Role               `json:"role"`
+	TOTPEnabled   bool               `json:"totp_enabled"`
+	Accounts      []uint64           `json:"accounts"`
+	CreatedAt     time.Time          `json:"created_at"`
+}
+
+type AccountResponse struct {
+	AccountNumber uint64    `json:"account_number"`
+	IBAN          string    `json:"iban,omitempty"`
+	Balance       Money     `json:"balance"`
+	Currency      Currency  `json:"currency"`
+	Frozen        bool      `json:"frozen"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type TransactionResponse struct {
+	ID          primitive.ObjectID `json:"id"`
+	Type        TransactionType    `json:"type"`
+	Amount      Money              `json:"amount"`
+	Currency    Currency           `json:"currency"`
+	FromAccount uint64             `json:"from_account,omitempty"`
+	ToAccount   uint64             `json:"to_account,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+
+	ExchangeRate      Rate     `json:"exchange_rate,omitempty"`
+	ConvertedAmount   Money    `json:"converted_amount,omitempty"`
+	ConvertedCurrency Currency `json:"converted_currency,omitempty"`
+
+	ReversalOf *primitive.ObjectID `json:"reversal_of,omitempty"`
+	ReversedBy *primitive.ObjectID `json:"reversed_by,omitempty"`
+	Reason     string              `json:"reason,omitempty"`
+}
+
+type TransactionPageResponse struct {
+	Transactions []*TransactionResponse `json:"transactions"`
+	NextCursor   string                 `json:"next_cursor,omitempty"`
+	PrevCursor   string                 `json:"prev_cursor,omitempty"`
+}
+
+type StandingOrderResponse struct {
+	ID              primitive.ObjectID `json:"id"`
+	ToAccountNumber uint64             `json:"to_account_number"`
+	Amount          Money              `json:"amount"`
+	Currency        Currency           `json:"currency"`
+	Frequency       Frequency          `json:"frequency"`
+	Schedule        string             `json:"schedule,omitempty"`
+	Reference       string             `json:"reference,omitempty"`
+	StartDate       time.Time          `json:"start_date"`
+	EndDate         *time.Time         `json:"end_date,omitempty"`
+	NextRunAt       *time.Time         `json:"next_run_at,omitempty"`
+	LastRunAt       *time.Time         `json:"last_run_at,omitempty"`
+	LastStatus      ExecutionStatus    `json:"last_status,omitempty"`
+	Active          bool               `json:"active"`
+	CreatedAt       time.Time          `json:"created_at"`
+}
+
+type StandingOrderExecutionResponse struct {
+	ID            primitive.ObjectID  `json:"id"`
+	OrderID       primitive.ObjectID  `json:"order_id"`
+	TransactionID *primitive.ObjectID `json:"transaction_id,omitempty"`
+	Status        ExecutionStatus     `json:"status"`
+	Error         string              `json:"error,omitempty"`
+	ScheduledFor  time.Time           `json:"scheduled_for"`
+	ExecutedAt    time.Time           `json:"executed_at"`
+}
+
+type AuditEntryResponse struct {
+	ID        primitive.ObjectID `json:"id"`
+	ActorID   primitive.ObjectID `json:"actor_id"`
+	ActorRole Role               `json:"actor_role"`
+	Action    AuditAction        `json:"action"`
+	Target    string             `json:"target,omitempty"`
+	Reason    string             `json:"reason"`
+	IP        string             `json:"ip"`
+	UserAgent string             `json:"user_agent,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+type AuthEventResponse struct {
+	ID        primitive.ObjectID `json:"id"`
+	Type      AuthEventType      `json:"type"`
+	IP        string             `json:"ip"`
+	UserAgent string             `json:"user_agent,omitempty"`
+	Detail    string             `json:"detail,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+type StatementResponse struct {
+	ID             primitive.ObjectID       `json:"id"`
+	AccountNumber  uint64                   `json:"account_number"`
+	Currency       Currency                 `json:"currency"`
+	From           time.Time                `json:"from"`
+	To             time.Time                `json:"to"`
+	OpeningBalance Money                    `json:"opening_balance"`
+	ClosingBalance Money                    `json:"closing_balance"`
+	Lines          []*StatementLineResponse `json:"lines"`
+	CreatedAt      time.Time                `json:"created_at"`
+}
+
+type StatementLineResponse struct {
+	Transaction  *TransactionResponse `json:"transaction"`
+	Amount       Money                `json:"amount"`
+	Balance      Money                `json:"balance"`
+	Counterparty uint64               `json:"counterparty,omitempty"`
+}
+
+type APIKeyResponse struct {
+	ID         primitive.ObjectID `json:"id"`
+	Name       string             `json:"name"`
+	Prefix     string             `json:"prefix"`
+	Scopes     []Scope            `json:"scopes"`
+	AllowedIPs []string           `json:"allowed_ips,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	ExpiresAt  *time.Time         `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty"`
+}
+
+type CreatedAPIKeyResponse struct {
+	*APIKeyResponse
+	Key string `json:"key"`
+}
+
+func NewUserResponse(user *User, accountNumbers map[primitive.ObjectID]uint64) *UserResponse {
+	response := &UserResponse{
+		ID:            user.ID,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.EffectiveRole(),
+		TOTPEnabled:   user.TOTPEnabled(),
+		Accounts:      []uint64{},
+		CreatedAt:     user.CreatedAt,
+	}
+	for _, aId := range user.Accounts {
+		if accountNumber, ok := accountNumbers[aId]; ok {
+			response.Accounts = append(response.Accounts, accountNumber)
+		}
+	}
+	return response
+}
+
+func NewUserResponses(users []*User, accountNumbers map[primitive.ObjectID]uint64) []*UserResponse {
+	responses := make([]*UserResponse, 0, len(users))
+	for _, user := range users {
+		responses = append(responses, NewUserResponse(user, accountNumbers))
+	}
+	return responses
+}
+
+func UserAccountIDs(users ...*User) []primitive.ObjectID {
+	ids := []primitive.ObjectID{}
+	for _, user := range users {
+		ids = append(ids, user.Accounts...)
+	}
+	return ids
+}
+
+func NewAccountResponse(account *Account) *AccountResponse {
+	return &AccountResponse{
+		AccountNumber: account.AccountNumber,
+		IBAN:          account.IBAN,
+		Balance:       account.Balance,
+		Currency:      account.Currency,
+		Frozen:        account.Frozen,
+		CreatedAt:     account.CreatedAt,
+	}
+}
+
+func NewAccountResponses(accounts []*Account) []*AccountResponse {
+	responses := make([]*AccountResponse, 0, len(accounts))
+	for _, account := range accounts {
+		responses = append(responses, NewAccountResponse(account))
+	}
+	return responses
+}
+
+func NewTransactionResponse(transaction *Transaction, accountNumbers map[primitive.ObjectID]uint64) *TransactionResponse {
+	return &TransactionResponse{
+		ID:                transaction.ID,
+		Type:              transaction.Type,
+		Amount:            transaction.Amount,
+		Currency:          transaction.Currency,
+		FromAccount:       accountNumbers[transaction.FromAccount],
+		ToAccount:         accountNumbers[transaction.ToAccount],
+		CreatedAt:         transaction.CreatedAt,
+		ExchangeRate:      transaction.ExchangeRate,
+		ConvertedAmount:   transaction.ConvertedAmount,
+		ConvertedCurrency: transaction.ConvertedCurrency,
+		ReversalOf:        transaction.ReversalOf,
+		ReversedBy:        transaction.ReversedBy,
+		Reason:            transaction.Reason,
+	}
+}
+
+func NewTransactionResponses(transactions []*Transaction, accountNumbers map[primitive.ObjectID]uint64) []*TransactionResponse {
+	responses := make([]*TransactionResponse, 0, len(transactions))
+	for _, transaction := range transactions {
+		responses = append(responses, NewTransactionResponse(transaction, accountNumbers))
+	}
+	return responses
+}
+
+func TransactionAccountIDs(transactions ...*Transaction) []primitive.ObjectID {
+	seen := map[primitive.ObjectID]bool{}
+	ids := []primitive.ObjectID{}
+	for _, transaction := range transactions {
+		for _, id := range []primitive.ObjectID{transaction.FromAccount, transaction.ToAccount} {
+			if id != primitive.NilObjectID && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func NewStandingOrderResponse(order *StandingOrder) *StandingOrderResponse {
+	return &StandingOrderResponse{
+		ID:              order.ID,
+		ToAccountNumber: order.ToAccountNumber,
+		Amount:          order.Amount,
+		Currency:        order.Currency,
+		Frequency:       order.Frequency,
+		Schedule:        order.Schedule,
+		Reference:       order.Reference,
+		StartDate:       order.StartDate,
+		EndDate:         order.EndDate,
+		NextRunAt:       order.NextRunAt,
+		LastRunAt:       order.LastRunAt,
+		LastStatus:      order.LastStatus,
+		Active:          order.Active,
+		CreatedAt:       order.CreatedAt,
+	}
+}
+
+func NewStandingOrderResponses(orders []*StandingOrder) []*StandingOrderResponse {
+	responses := make([]*StandingOrderResponse, 0, len(orders))
+	for _, order := range orders {
+		responses = append(responses, NewStandingOrderResponse(order))
+	}
+	return responses
+}
+
+func NewStandingOrderExecutionResponses(executions []*StandingOrderExecution) []*StandingOrderExecutionResponse {
+	responses := make([]*StandingOrderExecutionResponse, 0, len(executions))
+	for _, execution := range executions {
+		responses = append(responses, &StandingOrderExecutionResponse{
+			ID:            execution.ID,
+			OrderID:       execution.OrderID,
+			TransactionID: execution.TransactionID,
+			Status:        execution.Status,
+			Error:         execution.Error,
+			ScheduledFor:  execution.ScheduledFor,
+			ExecutedAt:    execution.ExecutedAt,
+		})
+	}
+	return responses
+}
+
+func NewAuditEntryResponses(entries []*AuditEntry) []*AuditEntryResponse {
+	responses := make([]*AuditEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		responses = append(responses, &AuditEntryResponse{
+			ID:        entry.ID,
+			ActorID:   entry.ActorID,
+			ActorRole: entry.ActorRole,
+			Action:    entry.Action,
+			Target:    entry.Target,
+			Reason:    entry.Reason,
+			IP:        entry.IP,
+			UserAgent: entry.UserAgent,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+	return responses
+}
+
+func NewAuthEventResponses(events []*AuthEvent) []*AuthEventResponse {
+	responses := make([]*AuthEventResponse, 0, len(events))
+	for _, event := range events {
+		responses = append(responses, &AuthEventResponse{
+			ID:        event.ID,
+			Type:      event.Type,
+			IP:        event.IP,
+			UserAgent: event.UserAgent,
+			Detail:    event.Detail,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+	return responses
+}
+
+func NewAPIKeyResponse(key *APIKey) *APIKeyResponse {
+	return &APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		AllowedIPs: key.AllowedIPs,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
+
+func NewAPIKeyResponses(keys []*APIKey) []*APIKeyResponse {
+	responses := make([]*APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		responses = append(responses, NewAPIKeyResponse(key))
+	}
+	return responses
+}
+
+func NewStatementResponse(statement *Statement) *StatementResponse {
+	accountNumbers := map[primitive.ObjectID]uint64{statement.AccountID: statement.AccountNumber}
+	for _, line := range statement.Lines {
+		if counterparty := line.Transaction.CounterpartyOf(statement.AccountID); counterparty != primitive.NilObjectID {
+			accountNumbers[counterparty] = line.Counterparty
+		}
+	}
+
+	response := &StatementResponse{
+		ID:             statement.ID,
+		AccountNumber:  statement.AccountNumber,
+		Currency:       statement.Currency,
+		From:           statement.From,
+		To:             statement.To,
+		OpeningBalance: statement.OpeningBalance,
+		ClosingBalance: statement.ClosingBalance,
+		Lines:          make([]*StatementLineResponse, 0, len(statement.Lines)),
+		CreatedAt:      statement.CreatedAt,
+	}
+	for _, line := range statement.Lines {
+		response.Lines = append(response.Lines, &StatementLineResponse{
+			Transaction:  NewTransactionResponse(line.Transaction, accountNumbers),
+			Amount:       line.Amount,
+			Balance:      line.Balance,
+			Counterparty: line.Counterparty,
+		})
+	}
+	return response
+}
+
+func (User) MarshalJSON() ([]byte, error)          { return nil, utils.STORAGE_TYPE_SERIALIZED }
+func (Account) MarshalJSON() ([]byte, error)       { return nil, utils.STORAGE_TYPE_SERIALIZED }
+func (Transaction) MarshalJSON() ([]byte, error)   { return nil, utils.STORAGE_TYPE_SERIALIZED }
+func (StandingOrder) MarshalJSON() ([]byte, error) { return nil, utils.STORAGE_TYPE_SERIALIZED }
+func (APIKey) MarshalJSON() ([]byte, error)        { return nil, utils.STORAGE_TYPE_SERIALIZED }
+func (StandingOrderExecution) MarshalJSON() ([]byte, error) {
+	return nil, utils.STORAGE_TYPE_SERIALIZED
+}
+func (AuditEntry) MarshalJSON() ([]byte, error) { return nil, utils.STORAGE_TYPE_SERIALIZED }
+func (AuthEvent) MarshalJSON() ([]byte, error)  { return nil, utils.STORAGE_TYPE_SERIALIZED }
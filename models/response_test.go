@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStorageTypesRefuseToMarshal(t *testing.T) {
+	user := &User{ID: primitive.NewObjectID(), Email: "j@example.com", Password: "$argon2id$v=19$secret"}
+	for _, v := range []any{
+		user,
+		*user,
+		[]*User{user},
+		map[string]any{"user": user},
+		&Account{ID: primitive.NewObjectID()},
+		&Transaction{ID: primitive.NewObjectID()},
+		&StandingOrder{ID: primitive.NewObjectID()},
+		&APIKey{ID: primitive.NewObjectID(), KeyHash: "hash"},
+		[]*StandingOrderExecution{{ID: primitive.NewObjectID()}},
+		[]*AuditEntry{{ID: primitive.NewObjectID()}},
+		[]*AuthEvent{{ID: primitive.NewObjectID()}},
+	} {
+		if _, err := json.Marshal(v); !errors.Is(err, utils.STORAGE_TYPE_SERIALIZED) {
+			t.Errorf("json.Marshal(%T) = %v, want %v", v, err, utils.STORAGE_TYPE_SERIALIZED)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	utils.ResponseMessage(w, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, user)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("ResponseMessage status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(w.Body.String(), user.Password) || strings.Contains(w.Body.String(), user.ID.Hex()) {
+		t.Errorf("ResponseMessage leaked the user: %s", w.Body.String())
+	}
+
+	if _, err := json.Marshal(NewUserResponse(user, nil)); err != nil {
+		t.Errorf("json.Marshal(UserResponse) = %v", err)
+	}
+}
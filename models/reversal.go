@@ -0,0 +1,121 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+type ReversalRequest struct {
+	Reason string `bson:"reason" json:"reason" validate:"required,min=5,max=500"`
+}
+
+func ValidateReversalRequest(request *ReversalRequest) error {
+	validate := newValidator()
+	return validate.Struct(request)
+}
+
+func NewReversal(original *Transaction, reason string) (*Transaction, *JournalEntry, error) {
+	if original.Type == Reversal {
+		return nil, nil, utils.CANNOT_REVERSE_REVERSAL
+	}
+	if original.ReversedBy != nil {
+		return nil, nil, utils.TRANSACTION_ALREADY_REVERSED
+	}
+
+	originalEntry, err := NewJournalEntry(original)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reversal := &Transaction{
+		ID:                primitive.NewObjectID(),
+		Type:              Reversal,
+		Amount:            original.Amount,
+		Currency:          original.Currency,
+		FromAccount:       original.ToAccount,
+		ToAccount:         original.FromAccount,
+		CreatedAt:         time.Now(),
+		ExchangeRate:      original.ExchangeRate,
+		ConvertedAmount:   original.ConvertedAmount,
+		ConvertedCurrency: original.ConvertedCurrency,
+		ReversalOf:        &original.ID,
+		Reason:            reason,
+	}
+
+	postings := make([]Posting, len(originalEntry.Postings))
+	for i, posting := range originalEntry.Postings {
+		posting.Amount = -posting.Amount
+		postings[i] = posting
+	}
+	entry := &JournalEntry{
+		ID:            primitive.NewObjectID(),
+		TransactionID: reversal.ID,
+		Postings:      postings,
+		CreatedAt:     reversal.CreatedAt,
+	}
+	if err := entry.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return reversal, entry, nil
+}
+
+func (db *DB) ReverseTransaction(ctx context.Context, tId primitive.ObjectID, reason string) (_ *Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "DB.ReverseTransaction")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	original, err := db.GetTransactionById(ctx, tId)
+	if err != nil {
+		return nil, err
+	}
+	reversal, entry, err := NewReversal(original, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := db.Db.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if err := db.claimIdempotencyKey(sessCtx, reversal.ID); err != nil {
+			return nil, err
+		}
+		filter := primitive.M{"_id": original.ID, "reversed_by": primitive.M{"$exists": false}}
+		update := primitive.M{"$set": primitive.M{"reversed_by": reversal.ID}}
+		result, err := db.Db.Collection("transactions").UpdateOne(sessCtx, filter, update)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, utils.TRANSACTION_ALREADY_REVERSED
+		}
+
+		if err := db.postJournalEntry(sessCtx, entry); err != nil {
+			if errors.Is(err, utils.INSUFFICIENT_FUNDS) {
+				return nil, utils.REVERSAL_INSUFFICIENT_FUNDS
+			}
+			return nil, err
+		}
+		_, err = db.Db.Collection("transactions").InsertOne(sessCtx, reversal)
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	_, err = session.WithTransaction(ctx, callback)
+	if err != nil {
+		return nil, err
+	}
+	return reversal, nil
+}
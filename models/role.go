@@ -0,0 +1,102 @@
+package models
+
+import (
+	"context"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleSupport  Role = "support"
+	RoleAdmin    Role = "admin"
+	RoleAuditor  Role = "auditor"
+)
+
+const (
+	DefaultUserSearchLimit = 20
+	MaxUserSearchLimit     = 100
+)
+
+type RoleUpdate struct {
+	Role   Role   `json:"role" validate:"required,oneof=customer support admin auditor"`
+	Reason string `json:"reason" validate:"required,min=5,max=500"`
+}
+
+func ValidateRoleUpdate(request *RoleUpdate) error {
+	validate := newValidator()
+	return validate.Struct(request)
+}
+
+func (u *User) EffectiveRole() Role {
+	if u.Role == "" {
+		return RoleCustomer
+	}
+	return u.Role
+}
+
+func userSearchFilter(query string) primitive.M {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return primitive.M{}
+	}
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+	filter := primitive.M{"$or": primitive.A{
+		primitive.M{"email": pattern},
+		primitive.M{"first_name": pattern},
+		primitive.M{"last_name": pattern},
+	}}
+	if id, err := primitive.ObjectIDFromHex(query); err == nil {
+		filter["$or"] = append(filter["$or"].(primitive.A), primitive.M{"_id": id})
+	}
+	return filter
+}
+
+func (u *User) matchesSearch(query string) bool {
+	query = strings.ToLower(strings.TrimSpace(query))
+	return query == "" || u.ID.Hex() == query ||
+		strings.Contains(strings.ToLower(u.Email), query) ||
+		strings.Contains(strings.ToLower(u.FirstName), query) ||
+		strings.Contains(strings.ToLower(u.LastName), query)
+}
+
+func (db *DB) SearchUsers(ctx context.Context, query string, limit int64) (_ []*User, err error) {
+	ctx, span := tracing.Start(ctx, "DB.SearchUsers")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	opts := options.Find().SetSort(primitive.D{{Key: "email", Value: 1}}).SetLimit(limit)
+	cursor, err := db.Db.Collection("users").Find(ctx, userSearchFilter(query), opts)
+	if err != nil {
+		return nil, err
+	}
+	users := []*User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+func (db *DB) SetUserRole(ctx context.Context, uId primitive.ObjectID, role Role) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.SetUserRole")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	result, err := db.Db.Collection("users").UpdateOne(ctx, primitive.M{"_id": uId}, primitive.M{"$set": primitive.M{"role": role}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.USER_NOT_FOUND
+	}
+	return nil
+}
@@ -0,0 +1,473 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "daily"
+	Weekly  Frequency = "weekly"
+	Monthly Frequency = "monthly"
+	Cron    Frequency = "cron"
+)
+
+type ExecutionStatus string
+
+const (
+	ExecutionSucceeded ExecutionStatus = "succeeded"
+	ExecutionFailed    ExecutionStatus = "failed"
+)
+
+const StandingOrderLockTime = 5 * time.Minute
+
+type StandingOrder struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	AccountID       primitive.ObjectID `bson:"account_id" json:"account_id"`
+	ToAccountNumber uint64             `bson:"to_account_number" json:"to_account_number"`
+	Amount          Money              `bson:"amount" json:"amount"`
+	Currency        Currency           `bson:"currency" json:"currency"`
+	Frequency       Frequency          `bson:"frequency" json:"frequency"`
+	Schedule        string             `bson:"schedule,omitempty" json:"schedule,omitempty"`
+	Reference       string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	StartDate       time.Time          `bson:"start_date" json:"start_date"`
+	EndDate         *time.Time         `bson:"end_date,omitempty" json:"end_date,omitempty"`
+	NextRunAt       *time.Time         `bson:"next_run_at,omitempty" json:"next_run_at,omitempty"`
+	LastRunAt       *time.Time         `bson:"last_run_at,omitempty" json:"last_run_at,omitempty"`
+	LastStatus      ExecutionStatus    `bson:"last_status,omitempty" json:"last_status,omitempty"`
+	Active          bool               `bson:"active" json:"active"`
+	LockedUntil     time.Time          `bson:"locked_until" json:"-"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type StandingOrderExecution struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	OrderID       primitive.ObjectID  `bson:"order_id" json:"order_id"`
+	TransactionID *primitive.ObjectID `bson:"transaction_id,omitempty" json:"transaction_id,omitempty"`
+	Status        ExecutionStatus     `bson:"status" json:"status"`
+	Error         string              `bson:"error,omitempty" json:"error,omitempty"`
+	ScheduledFor  time.Time           `bson:"scheduled_for" json:"scheduled_for"`
+	ExecutedAt    time.Time           `bson:"executed_at" json:"executed_at"`
+}
+
+type StandingOrderRequest struct {
+	ToAccount string     `bson:"to_account" json:"to_account" validate:"required"`
+	Amount    Money      `bson:"amount" json:"amount" validate:"required,gt=0"`
+	Currency  Currency   `bson:"currency" json:"currency"`
+	Frequency Frequency  `bson:"frequency" json:"frequency" validate:"required,oneof=daily weekly monthly cron"`
+	Schedule  string     `bson:"schedule" json:"schedule"`
+	Reference string     `bson:"reference" json:"reference" validate:"max=140"`
+	StartDate time.Time  `bson:"start_date" json:"start_date" validate:"required"`
+	EndDate   *time.Time `bson:"end_date" json:"end_date"`
+}
+
+type StandingOrderUpdate struct {
+	Amount    Money      `bson:"amount" json:"amount" validate:"omitempty,gt=0"`
+	Reference string     `bson:"reference" json:"reference" validate:"max=140"`
+	EndDate   *time.Time `bson:"end_date" json:"end_date"`
+	Active    *bool      `bson:"active" json:"active"`
+}
+
+func ValidateStandingOrderRequest(request *StandingOrderRequest) error {
+	validate := newValidator()
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		req := sl.Current().Interface().(StandingOrderRequest)
+
+		if _, err := ParseAccountNumber(req.ToAccount); err != nil {
+			sl.ReportError(req.ToAccount, "to_account", "ToAccount", "accountNumber", "")
+		}
+		if req.Frequency == Cron {
+			if _, err := utils.ParseCron(req.Schedule); err != nil {
+				sl.ReportError(req.Schedule, "schedule", "Schedule", "cron", "")
+			}
+		}
+		if today := time.Now().UTC().Truncate(24 * time.Hour); req.StartDate.Before(today) {
+			sl.ReportError(req.StartDate, "start_date", "StartDate", "notInPast", "")
+		}
+		if req.EndDate != nil && !req.EndDate.After(req.StartDate) {
+			sl.ReportError(req.EndDate, "end_date", "EndDate", "gtfield", "start_date")
+		}
+	}, StandingOrderRequest{})
+
+	return validate.Struct(request)
+}
+func ValidateStandingOrderUpdate(request *StandingOrderUpdate) error {
+	validate := newValidator()
+	return validate.Struct(request)
+}
+
+func NewStandingOrder(aId primitive.ObjectID, request *StandingOrderRequest) (*StandingOrder, error) {
+	toAccountNumber, err := ParseAccountNumber(request.ToAccount)
+	if err != nil {
+		return nil, err
+	}
+	order := &StandingOrder{
+		ID:              primitive.NewObjectID(),
+		AccountID:       aId,
+		ToAccountNumber: toAccountNumber,
+		Amount:          request.Amount,
+		Currency:        request.Currency,
+		Frequency:       request.Frequency,
+		Reference:       request.Reference,
+		StartDate:       request.StartDate.UTC(),
+		EndDate:         request.EndDate,
+		Active:          true,
+		CreatedAt:       time.Now(),
+	}
+	if order.Frequency == Cron {
+		order.Schedule = request.Schedule
+	}
+	first, err := order.firstRun()
+	if err != nil {
+		return nil, err
+	}
+	order.scheduleNext(first)
+	return order, nil
+}
+
+func (o *StandingOrder) firstRun() (time.Time, error) {
+	if o.Frequency != Cron {
+		return o.StartDate, nil
+	}
+	schedule, err := utils.ParseCron(o.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(o.StartDate.Add(-time.Minute)), nil
+}
+
+func (o *StandingOrder) NextRun(after time.Time) (time.Time, error) {
+	if after.Before(o.StartDate) {
+		return o.firstRun()
+	}
+	switch o.Frequency {
+	case Daily:
+		days := int(after.Sub(o.StartDate).Hours()/24) + 1
+		return o.StartDate.AddDate(0, 0, days), nil
+	case Weekly:
+		weeks := int(after.Sub(o.StartDate).Hours()/(24*7)) + 1
+		return o.StartDate.AddDate(0, 0, 7*weeks), nil
+	case Monthly:
+		months := (after.Year()-o.StartDate.Year())*12 + int(after.Month()-o.StartDate.Month())
+		for {
+			next := addMonthsClamped(o.StartDate, months)
+			if next.After(after) {
+				return next, nil
+			}
+			months++
+		}
+	case Cron:
+		schedule, err := utils.ParseCron(o.Schedule)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return schedule.Next(after), nil
+	}
+	return time.Time{}, utils.INVALID_FREQUENCY
+}
+
+func addMonthsClamped(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+func (o *StandingOrder) scheduleNext(next time.Time) {
+	if next.IsZero() || (o.EndDate != nil && next.After(*o.EndDate)) {
+		o.NextRunAt = nil
+		o.Active = false
+		return
+	}
+	o.NextRunAt = &next
+}
+
+func (o *StandingOrder) Advance(execution *StandingOrderExecution) error {
+	// after a downtime only the oldest missed date is executed, the others are skipped
+	after := execution.ScheduledFor
+	if execution.ExecutedAt.After(after) {
+		after = execution.ExecutedAt
+	}
+	next, err := o.NextRun(after)
+	if err != nil {
+		return err
+	}
+	executedAt := execution.ExecutedAt
+	o.LastRunAt = &executedAt
+	o.LastStatus = execution.Status
+	o.LockedUntil = time.Time{}
+	o.scheduleNext(next)
+	return nil
+}
+
+func (o *StandingOrder) ApplyUpdate(update *StandingOrderUpdate) error {
+	if update.Amount != 0 {
+		o.Amount = update.Amount
+	}
+	if update.Reference != "" {
+		o.Reference = update.Reference
+	}
+	if update.EndDate != nil {
+		o.EndDate = update.EndDate
+	}
+	if update.Active != nil {
+		wasActive := o.Active
+		o.Active = *update.Active
+		if o.Active && !wasActive {
+			next, err := o.NextRun(time.Now())
+			if err != nil {
+				return err
+			}
+			o.NextRunAt = &next
+		}
+	}
+	if o.Active && o.NextRunAt != nil {
+		o.scheduleNext(*o.NextRunAt)
+	}
+	return nil
+}
+
+func (db *DB) CreateStandingOrder(ctx context.Context, aId primitive.ObjectID, request *StandingOrderRequest) (_ *StandingOrder, err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateStandingOrder")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	order, err := NewStandingOrder(aId, request)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Db.Collection("standing_orders").InsertOne(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+func (db *DB) GetStandingOrderById(ctx context.Context, oId primitive.ObjectID) (_ *StandingOrder, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetStandingOrderById")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	order := &StandingOrder{}
+	err = db.Db.Collection("standing_orders").FindOne(ctx, primitive.M{"_id": oId}).Decode(order)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.STANDING_ORDER_NOT_FOUND
+		}
+		return nil, err
+	}
+	return order, nil
+}
+func (db *DB) GetStandingOrdersFromAccount(ctx context.Context, aId primitive.ObjectID) (_ []*StandingOrder, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetStandingOrdersFromAccount")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	cursor, err := db.Db.Collection("standing_orders").Find(ctx, primitive.M{"account_id": aId})
+	if err != nil {
+		return nil, err
+	}
+	orders := []*StandingOrder{}
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+func (db *DB) UpdateStandingOrder(ctx context.Context, oId primitive.ObjectID, update *StandingOrderUpdate) (_ *StandingOrder, err error) {
+	ctx, span := tracing.Start(ctx, "DB.UpdateStandingOrder")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	order, err := db.GetStandingOrderById(ctx, oId)
+	if err != nil {
+		return nil, err
+	}
+	filter := primitive.M{"_id": oId, "next_run_at": order.NextRunAt}
+	if err := order.ApplyUpdate(update); err != nil {
+		return nil, err
+	}
+
+	set := primitive.M{"active": order.Active}
+	if update.Amount != 0 {
+		set["amount"] = order.Amount
+	}
+	if update.Reference != "" {
+		set["reference"] = order.Reference
+	}
+	if update.EndDate != nil {
+		set["end_date"] = order.EndDate
+	}
+	changes := primitive.M{"$set": set}
+	if order.NextRunAt != nil {
+		set["next_run_at"] = order.NextRunAt
+	} else {
+		changes["$unset"] = primitive.M{"next_run_at": ""}
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	updated := &StandingOrder{}
+	err = db.Db.Collection("standing_orders").FindOneAndUpdate(ctx, filter, changes, opts).Decode(updated)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.STANDING_ORDER_CHANGED
+		}
+		return nil, err
+	}
+	return updated, nil
+}
+func (db *DB) DeleteStandingOrder(ctx context.Context, oId primitive.ObjectID) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.DeleteStandingOrder")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("standing_orders").DeleteOne(ctx, primitive.M{"_id": oId})
+	return err
+}
+func (db *DB) ClaimDueStandingOrder(ctx context.Context, now time.Time) (_ *StandingOrder, err error) {
+	ctx, span := tracing.Start(ctx, "DB.ClaimDueStandingOrder")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	filter := primitive.M{
+		"active":       true,
+		"next_run_at":  primitive.M{"$lte": now},
+		"locked_until": primitive.M{"$lte": now},
+	}
+	update := primitive.M{"$set": primitive.M{"locked_until": now.Add(StandingOrderLockTime)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_run_at", Value: 1}}).SetReturnDocument(options.After)
+
+	order := &StandingOrder{}
+	err = db.Db.Collection("standing_orders").FindOneAndUpdate(ctx, filter, update, opts).Decode(order)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return order, nil
+}
+func (db *DB) RecordStandingOrderExecution(ctx context.Context, order *StandingOrder, execution *StandingOrderExecution) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.RecordStandingOrderExecution")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if err := order.Advance(execution); err != nil {
+		return err
+	}
+	session, err := db.Db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if err := db.advanceStandingOrder(sessCtx, order, execution); err != nil {
+			return nil, err
+		}
+		_, err := db.Db.Collection("standing_order_executions").InsertOne(sessCtx, execution)
+		return nil, err
+	}
+	_, err = session.WithTransaction(ctx, callback)
+	return err
+}
+func (db *DB) ExecuteStandingOrder(ctx context.Context, order *StandingOrder, transactionRequest *TransactionRequest, execution *StandingOrderExecution) (_ *Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "DB.ExecuteStandingOrder")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	transaction := NewTransaction(transactionRequest)
+	entry, err := NewJournalEntry(transaction)
+	if err != nil {
+		return nil, err
+	}
+	execution.Status = ExecutionSucceeded
+	execution.TransactionID = &transaction.ID
+	if err := order.Advance(execution); err != nil {
+		return nil, err
+	}
+
+	session, err := db.Db.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if err := db.advanceStandingOrder(sessCtx, order, execution); err != nil {
+			return nil, err
+		}
+		if err := db.insertTransaction(sessCtx, transaction, entry); err != nil {
+			return nil, err
+		}
+		_, err := db.Db.Collection("standing_order_executions").InsertOne(sessCtx, execution)
+		return nil, err
+	}
+	if _, err := session.WithTransaction(ctx, callback); err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+func (db *DB) advanceStandingOrder(sessCtx mongo.SessionContext, order *StandingOrder, execution *StandingOrderExecution) error {
+	filter := primitive.M{"_id": order.ID, "next_run_at": execution.ScheduledFor}
+	update := primitive.M{"$set": primitive.M{
+		"next_run_at":  order.NextRunAt,
+		"last_run_at":  order.LastRunAt,
+		"last_status":  order.LastStatus,
+		"active":       order.Active,
+		"locked_until": order.LockedUntil,
+	}}
+	result, err := db.Db.Collection("standing_orders").UpdateOne(sessCtx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.STANDING_ORDER_ALREADY_EXECUTED
+	}
+	return nil
+}
+func (db *DB) GetStandingOrderExecutions(ctx context.Context, oId primitive.ObjectID) (_ []*StandingOrderExecution, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetStandingOrderExecutions")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	opts := options.Find().SetSort(bson.D{{Key: "executed_at", Value: -1}})
+	cursor, err := db.Db.Collection("standing_order_executions").Find(ctx, primitive.M{"order_id": oId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	executions := []*StandingOrderExecution{}
+	if err := cursor.All(ctx, &executions); err != nil {
+		return nil, err
+	}
+	return executions, nil
+}
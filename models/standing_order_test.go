@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+func TestAdvanceSkipsMissedRuns(t *testing.T) {
+	now := time.Now().UTC()
+	for _, frequency := range []Frequency{Daily, Weekly, Monthly} {
+		start := now.AddDate(0, -3, 0)
+		order := &StandingOrder{Frequency: frequency, StartDate: start, NextRunAt: &start, Active: true}
+		if err := order.Advance(&StandingOrderExecution{ScheduledFor: start, ExecutedAt: now, Status: ExecutionSucceeded}); err != nil {
+			t.Fatal(err)
+		}
+		if order.NextRunAt == nil || !order.NextRunAt.After(now) {
+			t.Errorf("%s: next run %v is not after %v", frequency, order.NextRunAt, now)
+		}
+		if order.NextRunAt.After(now.AddDate(0, 1, 1)) {
+			t.Errorf("%s: next run %v skips a future date", frequency, order.NextRunAt)
+		}
+	}
+}
+
+func TestNextRunMonthEnd(t *testing.T) {
+	start := time.Date(2027, time.January, 31, 8, 0, 0, 0, time.UTC)
+	order := &StandingOrder{Frequency: Monthly, StartDate: start}
+	after := start
+	for _, want := range []time.Time{
+		time.Date(2027, time.February, 28, 8, 0, 0, 0, time.UTC),
+		time.Date(2027, time.March, 31, 8, 0, 0, 0, time.UTC),
+		time.Date(2027, time.April, 30, 8, 0, 0, 0, time.UTC),
+		time.Date(2027, time.May, 31, 8, 0, 0, 0, time.UTC),
+	} {
+		next, err := order.NextRun(after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !next.Equal(want) {
+			t.Fatalf("next run after %v = %v, want %v", after, next, want)
+		}
+		after = next
+	}
+
+	leap := &StandingOrder{Frequency: Monthly, StartDate: time.Date(2028, time.January, 30, 8, 0, 0, 0, time.UTC)}
+	if next, _ := leap.NextRun(leap.StartDate); !next.Equal(time.Date(2028, time.February, 29, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("next run in a leap year = %v, want 2028-02-29", next)
+	}
+}
+
+func TestValidateStandingOrderRequestStartDate(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for _, tc := range []struct {
+		start time.Time
+		valid bool
+	}{
+		{today, true},
+		{time.Now().Add(time.Hour), true},
+		{today.Add(-time.Second), false},
+		{today.AddDate(0, -1, 0), false},
+	} {
+		request := &StandingOrderRequest{ToAccount: "4929561308", Amount: 100, Frequency: Daily, StartDate: tc.start}
+		err := ValidateStandingOrderRequest(request)
+		var validationErrors validator.ValidationErrors
+		if tc.valid && err != nil {
+			t.Errorf("start %v: unexpected error %v", tc.start, err)
+		}
+		if !tc.valid && (!errors.As(err, &validationErrors) || validationErrors[0].Tag() != "notInPast") {
+			t.Errorf("start %v: got %v, want notInPast", tc.start, err)
+		}
+	}
+}
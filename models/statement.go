@@ -0,0 +1,130 @@
+package models
+
+import (
+	"context"
+	"github.com/mathis-k/bank-api/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type Statement struct {
+	ID             primitive.ObjectID `json:"id"`
+	AccountID      primitive.ObjectID `json:"account_id"`
+	AccountNumber  uint64             `json:"account_number"`
+	Currency       Currency           `json:"currency"`
+	From           time.Time          `json:"from"`
+	To             time.Time          `json:"to"`
+	OpeningBalance Money              `json:"opening_balance"`
+	ClosingBalance Money              `json:"closing_balance"`
+	Lines          []*StatementLine   `json:"lines"`
+	CreatedAt      time.Time          `json:"created_at"`
+}
+
+type StatementLine struct {
+	Transaction  *Transaction `json:"transaction"`
+	Amount       Money        `json:"amount"`
+	Balance      Money        `json:"balance"`
+	Counterparty uint64       `json:"counterparty,omitempty"`
+}
+
+func (t *Transaction) AmountFor(aId primitive.ObjectID) Money {
+	credit, debit := t.Amount, t.Amount
+	if t.ConvertedAmount != 0 {
+		switch t.Type {
+		case Transfer:
+			credit = t.ConvertedAmount
+		case Reversal:
+			debit = t.ConvertedAmount
+		}
+	}
+
+	var amount Money
+	if t.ToAccount == aId {
+		amount += credit
+	}
+	if t.FromAccount == aId {
+		amount -= debit
+	}
+	return amount
+}
+
+func (t *Transaction) CounterpartyOf(aId primitive.ObjectID) primitive.ObjectID {
+	if t.FromAccount == aId {
+		return t.ToAccount
+	}
+	return t.FromAccount
+}
+
+func NewStatement(account *Account, from time.Time, to time.Time, openingBalance Money, closingBalance Money, transactions []*Transaction, counterparties map[primitive.ObjectID]uint64) *Statement {
+	statement := &Statement{
+		ID:             primitive.NewObjectID(),
+		AccountID:      account.ID,
+		AccountNumber:  account.AccountNumber,
+		Currency:       account.Currency,
+		From:           from,
+		To:             to,
+		OpeningBalance: openingBalance,
+		ClosingBalance: closingBalance,
+		Lines:          []*StatementLine{},
+		CreatedAt:      time.Now(),
+	}
+
+	balance := openingBalance
+	for _, transaction := range transactions {
+		amount := transaction.AmountFor(account.ID)
+		balance += amount
+		statement.Lines = append(statement.Lines, &StatementLine{
+			Transaction:  transaction,
+			Amount:       amount,
+			Balance:      balance,
+			Counterparty: counterparties[transaction.CounterpartyOf(account.ID)],
+		})
+	}
+	return statement
+}
+
+func (db *DB) GetStatement(ctx context.Context, account *Account, from time.Time, to time.Time) (_ *Statement, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetStatement")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	openingBalance, err := db.ledgerOpeningBalance(ctx, account.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	closingBalance, err := db.ledgerBalanceBefore(ctx, account.ID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"$or":        bson.A{bson.M{"from_account": account.ID}, bson.M{"to_account": account.ID}},
+		"created_at": bson.M{"$gte": from, "$lt": to},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := db.Db.Collection("transactions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	transactions := []*Transaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+
+	var ids []primitive.ObjectID
+	for _, transaction := range transactions {
+		if id := transaction.CounterpartyOf(account.ID); id != primitive.NilObjectID {
+			ids = append(ids, id)
+		}
+	}
+	counterparties, err := db.GetAccountNumbers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStatement(account, from, to, openingBalance, closingBalance, transactions, counterparties), nil
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type Store interface {
+	Disconnect(ctx context.Context) error
+
+	CreateUser(ctx context.Context, userRequest *UserRequest) (*User, error)
+	GetUserById(ctx context.Context, id primitive.ObjectID) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	UpdateUser(ctx context.Context, uId primitive.ObjectID, userUpdate *UserUpdate) (*User, error)
+	DeleteUser(ctx context.Context, uId primitive.ObjectID) error
+	LoginUser(ctx context.Context, userLogin *UserLogin) (*User, error)
+	SetPassword(ctx context.Context, uId primitive.ObjectID, passwordHash string) error
+	MarkEmailVerified(ctx context.Context, uId primitive.ObjectID, email string) error
+	SearchUsers(ctx context.Context, query string, limit int64) ([]*User, error)
+	SetUserRole(ctx context.Context, uId primitive.ObjectID, role Role) error
+	CreateUserToken(ctx context.Context, token *UserToken) error
+	ConsumeUserToken(ctx context.Context, purpose UserTokenPurpose, tokenHash string) (*UserToken, error)
+	AddAccountToUser(ctx context.Context, uId primitive.ObjectID, aId primitive.ObjectID) error
+	RemoveAccountFromUser(ctx context.Context, uId primitive.ObjectID, aId primitive.ObjectID) error
+	SetTOTPSecret(ctx context.Context, uId primitive.ObjectID, secret string, recoveryCodes []string) error
+	EnableTOTP(ctx context.Context, uId primitive.ObjectID, counter int64) error
+	DisableTOTP(ctx context.Context, uId primitive.ObjectID) error
+	UseTOTPCounter(ctx context.Context, uId primitive.ObjectID, counter int64) error
+	UseRecoveryCode(ctx context.Context, uId primitive.ObjectID, codeHash string) error
+
+	CreateAccount(ctx context.Context, currency Currency) (*Account, error)
+	GetAccountById(ctx context.Context, aId primitive.ObjectID) (*Account, error)
+	GetAccountByAccountNumber(ctx context.Context, accountNumber uint64) (*Account, error)
+	GetAccountByLegacyAccountNumber(ctx context.Context, legacyAccountNumber uint64) (*Account, error)
+	GetAccountsFromUser(ctx context.Context, uId primitive.ObjectID) ([]*Account, error)
+	GetAccountNumbers(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]uint64, error)
+	SetAccountFrozen(ctx context.Context, aId primitive.ObjectID, frozen bool) error
+	DeleteAccount(ctx context.Context, aId primitive.ObjectID) error
+
+	CreateTransaction(ctx context.Context, transactionRequest *TransactionRequest) (*Transaction, error)
+	GetTransactionById(ctx context.Context, tId primitive.ObjectID) (*Transaction, error)
+	FindTransactions(ctx context.Context, query *TransactionQuery) (*TransactionPage, error)
+	GetStatement(ctx context.Context, account *Account, from time.Time, to time.Time) (*Statement, error)
+	ReverseTransaction(ctx context.Context, tId primitive.ObjectID, reason string) (*Transaction, error)
+	GetLedgerBalance(ctx context.Context, aId primitive.ObjectID) (Money, error)
+
+	CreateStandingOrder(ctx context.Context, aId primitive.ObjectID, request *StandingOrderRequest) (*StandingOrder, error)
+	GetStandingOrderById(ctx context.Context, oId primitive.ObjectID) (*StandingOrder, error)
+	GetStandingOrdersFromAccount(ctx context.Context, aId primitive.ObjectID) ([]*StandingOrder, error)
+	UpdateStandingOrder(ctx context.Context, oId primitive.ObjectID, update *StandingOrderUpdate) (*StandingOrder, error)
+	DeleteStandingOrder(ctx context.Context, oId primitive.ObjectID) error
+	ClaimDueStandingOrder(ctx context.Context, now time.Time) (*StandingOrder, error)
+	RecordStandingOrderExecution(ctx context.Context, order *StandingOrder, execution *StandingOrderExecution) error
+	ExecuteStandingOrder(ctx context.Context, order *StandingOrder, transactionRequest *TransactionRequest, execution *StandingOrderExecution) (*Transaction, error)
+	GetStandingOrderExecutions(ctx context.Context, oId primitive.ObjectID) ([]*StandingOrderExecution, error)
+
+	ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey) (*IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, key *IdempotencyKey, statusCode int, response []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, key *IdempotencyKey) error
+
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	RotateRefreshToken(ctx context.Context, tokenHash string, next *RefreshToken) (*RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, uId primitive.ObjectID, tokenHash string) error
+	RevokeUserRefreshTokens(ctx context.Context, uId primitive.ObjectID) error
+	CreateRevocation(ctx context.Context, revocation *Revocation) error
+	GetRevocations(ctx context.Context, since time.Time) ([]*Revocation, error)
+
+	GetLoginAttempt(ctx context.Context, key string) (*LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, key string, policy LoginPolicy) (*LoginAttempt, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+	CreateAuthEvent(ctx context.Context, event *AuthEvent) error
+	GetAuthEvents(ctx context.Context, uId primitive.ObjectID, limit int64) ([]*AuthEvent, error)
+
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) error
+	GetAuditLog(ctx context.Context, query *AuditLogQuery) ([]*AuditEntry, error)
+
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	GetAPIKeys(ctx context.Context, uId primitive.ObjectID) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, uId primitive.ObjectID, kId primitive.ObjectID) (*APIKey, error)
+	TouchAPIKey(ctx context.Context, kId primitive.ObjectID, usedAt time.Time) error
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
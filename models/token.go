@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type RefreshToken struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID  `bson:"user_id"`
+	FamilyID   primitive.ObjectID  `bson:"family_id"`
+	TokenHash  string              `bson:"token_hash"`
+	ReplacedBy *primitive.ObjectID `bson:"replaced_by,omitempty"`
+	RevokedAt  *time.Time          `bson:"revoked_at,omitempty"`
+	CreatedAt  time.Time           `bson:"created_at"`
+	ExpiresAt  time.Time           `bson:"expires_at"`
+}
+
+type Revocation struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty"`
+	TokenID   string              `bson:"jti,omitempty"`
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty"`
+	RevokedAt time.Time           `bson:"revoked_at"`
+	ExpiresAt time.Time           `bson:"expires_at"`
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTokenSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func NewRefreshToken(uId primitive.ObjectID, ttl time.Duration) (*RefreshToken, string, error) {
+	token, err := newTokenSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	refreshToken := &RefreshToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    uId,
+		TokenHash: HashRefreshToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	refreshToken.FamilyID = refreshToken.ID
+	return refreshToken, token, nil
+}
+
+func (t *RefreshToken) active(now time.Time) bool {
+	return t.ReplacedBy == nil && t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+func (db *DB) CreateRefreshToken(ctx context.Context, token *RefreshToken) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateRefreshToken")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("refresh_tokens").InsertOne(ctx, token)
+	return err
+}
+func (db *DB) RotateRefreshToken(ctx context.Context, tokenHash string, next *RefreshToken) (_ *RefreshToken, err error) {
+	ctx, span := tracing.Start(ctx, "DB.RotateRefreshToken")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	now := time.Now()
+	filter := primitive.M{
+		"token_hash":  tokenHash,
+		"replaced_by": primitive.M{"$exists": false},
+		"revoked_at":  primitive.M{"$exists": false},
+		"expires_at":  primitive.M{"$gt": now},
+	}
+	update := primitive.M{"$set": primitive.M{"replaced_by": next.ID}}
+
+	current := &RefreshToken{}
+	err = db.Db.Collection("refresh_tokens").FindOneAndUpdate(ctx, filter, update).Decode(current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		existing := &RefreshToken{}
+		err = db.Db.Collection("refresh_tokens").FindOne(ctx, primitive.M{"token_hash": tokenHash}).Decode(existing)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.INVALID_REFRESH_TOKEN
+		}
+		if err != nil {
+			return nil, err
+		}
+		if existing.ReplacedBy != nil {
+			utils.Logger(ctx).Warn("refresh token reuse detected, revoking the session", "user_id", existing.UserID.Hex())
+			if err := db.revokeRefreshTokenFamily(ctx, existing.FamilyID); err != nil {
+				return nil, err
+			}
+			return nil, utils.REFRESH_TOKEN_REUSED
+		}
+		return nil, utils.INVALID_REFRESH_TOKEN
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	if err := db.CreateRefreshToken(ctx, next); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+func (db *DB) RevokeRefreshToken(ctx context.Context, uId primitive.ObjectID, tokenHash string) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.RevokeRefreshToken")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	token := &RefreshToken{}
+	err = db.Db.Collection("refresh_tokens").FindOne(ctx, primitive.M{"token_hash": tokenHash, "user_id": uId}).Decode(token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return utils.INVALID_REFRESH_TOKEN
+		}
+		return err
+	}
+	return db.revokeRefreshTokenFamily(ctx, token.FamilyID)
+}
+func (db *DB) revokeRefreshTokenFamily(ctx context.Context, familyId primitive.ObjectID) error {
+	_, err := db.Db.Collection("refresh_tokens").UpdateMany(ctx,
+		primitive.M{"family_id": familyId, "revoked_at": primitive.M{"$exists": false}},
+		primitive.M{"$set": primitive.M{"revoked_at": time.Now()}})
+	return err
+}
+func (db *DB) RevokeUserRefreshTokens(ctx context.Context, uId primitive.ObjectID) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.RevokeUserRefreshTokens")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("refresh_tokens").UpdateMany(ctx,
+		primitive.M{"user_id": uId, "revoked_at": primitive.M{"$exists": false}},
+		primitive.M{"$set": primitive.M{"revoked_at": time.Now()}})
+	return err
+}
+
+func (db *DB) CreateRevocation(ctx context.Context, revocation *Revocation) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateRevocation")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if revocation.ID.IsZero() {
+		revocation.ID = primitive.NewObjectID()
+	}
+	_, err = db.Db.Collection("revocations").InsertOne(ctx, revocation)
+	return err
+}
+func (db *DB) GetRevocations(ctx context.Context, since time.Time) (_ []*Revocation, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetRevocations")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	filter := primitive.M{
+		"revoked_at": primitive.M{"$gte": since},
+		"expires_at": primitive.M{"$gt": time.Now()},
+	}
+	cursor, err := db.Db.Collection("revocations").Find(ctx, filter, options.Find().SetSort(primitive.M{"revoked_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	revocations := []*Revocation{}
+	if err := cursor.All(ctx, &revocations); err != nil {
+		return nil, err
+	}
+	return revocations, nil
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type TOTPSettings struct {
+	Secret        string     `bson:"secret"`
+	Enabled       bool       `bson:"enabled"`
+	LastCounter   int64      `bson:"last_counter"`
+	RecoveryCodes []string   `bson:"recovery_codes"`
+	EnabledAt     *time.Time `bson:"enabled_at,omitempty"`
+}
+
+type TOTPRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type TOTPLoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+func ValidateTOTPRequest(request *TOTPRequest) error {
+	validate := newValidator()
+	return validate.Struct(request)
+}
+func ValidateTOTPLoginRequest(request *TOTPLoginRequest) error {
+	validate := newValidator()
+	return validate.Struct(request)
+}
+
+func (u User) TOTPEnabled() bool {
+	return u.TOTP != nil && u.TOTP.Enabled
+}
+
+func (t *TOTPSettings) clone() *TOTPSettings {
+	c := *t
+	c.RecoveryCodes = append([]string{}, t.RecoveryCodes...)
+	if t.EnabledAt != nil {
+		enabledAt := *t.EnabledAt
+		c.EnabledAt = &enabledAt
+	}
+	return &c
+}
+
+func (db *DB) SetTOTPSecret(ctx context.Context, uId primitive.ObjectID, secret string, recoveryCodes []string) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.SetTOTPSecret")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	filter := primitive.M{"_id": uId, "totp.enabled": primitive.M{"$ne": true}}
+	update := primitive.M{"$set": primitive.M{"totp": &TOTPSettings{Secret: secret, RecoveryCodes: recoveryCodes}}}
+	result, err := db.Db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.TOTP_ALREADY_ENABLED
+	}
+	return nil
+}
+func (db *DB) EnableTOTP(ctx context.Context, uId primitive.ObjectID, counter int64) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.EnableTOTP")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	filter := primitive.M{"_id": uId, "totp.secret": primitive.M{"$exists": true}, "totp.enabled": false}
+	update := primitive.M{"$set": primitive.M{"totp.enabled": true, "totp.last_counter": counter, "totp.enabled_at": time.Now()}}
+	result, err := db.Db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.TOTP_NOT_ENROLLED
+	}
+	return nil
+}
+func (db *DB) DisableTOTP(ctx context.Context, uId primitive.ObjectID) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.DisableTOTP")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("users").UpdateOne(ctx, primitive.M{"_id": uId}, primitive.M{"$unset": primitive.M{"totp": ""}})
+	return err
+}
+func (db *DB) UseTOTPCounter(ctx context.Context, uId primitive.ObjectID, counter int64) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.UseTOTPCounter")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	filter := primitive.M{"_id": uId, "totp.enabled": true, "totp.last_counter": primitive.M{"$lt": counter}}
+	update := primitive.M{"$set": primitive.M{"totp.last_counter": counter}}
+	result, err := db.Db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.TOTP_CODE_REUSED
+	}
+	return nil
+}
+func (db *DB) UseRecoveryCode(ctx context.Context, uId primitive.ObjectID, codeHash string) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.UseRecoveryCode")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	filter := primitive.M{"_id": uId, "totp.enabled": true, "totp.recovery_codes": codeHash}
+	update := primitive.M{"$pull": primitive.M{"totp.recovery_codes": codeHash}}
+	result, err := db.Db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.INVALID_TOTP_CODE
+	}
+	return nil
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"errors"
 	"github.com/go-playground/validator/v10"
+	"github.com/mathis-k/bank-api/tracing"
 	"github.com/mathis-k/bank-api/utils"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"time"
@@ -17,51 +17,71 @@ const (
 	Deposit  TransactionType = "Deposit"
 	Payout   TransactionType = "Payout"
 	Transfer TransactionType = "Transfer"
+	Reversal TransactionType = "Reversal"
 )
 
+const MaxTransactionAmount Money = 10000_00
+
 type Transaction struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Type        TransactionType    `bson:"type" json:"type"`
-	Amount      float64            `bson:"amount" json:"amount"`
+	Amount      Money              `bson:"amount" json:"amount"`
+	Currency    Currency           `bson:"currency" json:"currency"`
 	FromAccount primitive.ObjectID `bson:"from_account" json:"from_account"`
 	ToAccount   primitive.ObjectID `bson:"to_account" json:"to_account"`
 	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+
+	ExchangeRate      Rate     `bson:"exchange_rate,omitempty" json:"exchange_rate,omitempty"`
+	ConvertedAmount   Money    `bson:"converted_amount,omitempty" json:"converted_amount,omitempty"`
+	ConvertedCurrency Currency `bson:"converted_currency,omitempty" json:"converted_currency,omitempty"`
+
+	ReversalOf *primitive.ObjectID `bson:"reversal_of,omitempty" json:"reversal_of,omitempty"`
+	ReversedBy *primitive.ObjectID `bson:"reversed_by,omitempty" json:"reversed_by,omitempty"`
+	Reason     string              `bson:"reason,omitempty" json:"reason,omitempty"`
 }
 
 type TransactionRequest struct {
 	Type        TransactionType    `bson:"type" json:"type" validate:"required"`
-	Amount      float64            `bson:"amount" json:"amount" validate:"required,gt=0,lte=10000"`
+	Amount      Money              `bson:"amount" json:"amount" validate:"required,gt=0"`
 	FromAccount primitive.ObjectID `bson:"from_account" json:"from_account"`
 	ToAccount   string             `bson:"to_account" json:"to_account"`
-	ToAccountID primitive.ObjectID
+	Currency    Currency           `bson:"currency" json:"currency"`
+	ToAccountID primitive.ObjectID `bson:"-" json:"-"`
+
+	ToAmount     Money    `bson:"-" json:"-"`
+	ToCurrency   Currency `bson:"-" json:"-"`
+	ExchangeRate Rate     `bson:"-" json:"-"`
 }
 
 func ValidateTransactionRequest(request *TransactionRequest) error {
-	validate := validator.New()
+	validate := newValidator()
 	validate.RegisterStructValidation(func(sl validator.StructLevel) {
 		req := sl.Current().Interface().(TransactionRequest)
 
 		switch req.Type {
 		case Transfer:
+			if req.ToAmount <= 0 {
+				sl.ReportError(req.ToAmount, "to_amount", "ToAmount", "requiredForTransfer", "")
+			}
 			if req.FromAccount == primitive.NilObjectID {
-				sl.ReportError(req.FromAccount, "FromAccount", "from_account", "requiredForTransfer", "")
+				sl.ReportError(req.FromAccount, "from_account", "FromAccount", "requiredForTransfer", "")
 			}
 			if req.ToAccountID == primitive.NilObjectID {
-				sl.ReportError(req.ToAccountID, "ToAccountID", "to_account_id", "requiredForTransfer", "")
+				sl.ReportError(req.ToAccountID, "to_account_id", "ToAccountID", "requiredForTransfer", "")
 			}
 		case Deposit:
 			if req.ToAccountID == primitive.NilObjectID {
-				sl.ReportError(req.ToAccountID, "ToAccountID", "to_account_id", "requiredForDeposit", "")
+				sl.ReportError(req.ToAccountID, "to_account_id", "ToAccountID", "requiredForDeposit", "")
 			}
 			if req.FromAccount != primitive.NilObjectID {
-				sl.ReportError(req.FromAccount, "FromAccount", "from_account", "shouldBeEmptyForDeposit", "")
+				sl.ReportError(req.FromAccount, "from_account", "FromAccount", "shouldBeEmptyForDeposit", "")
 			}
 		case Payout:
 			if req.FromAccount == primitive.NilObjectID {
-				sl.ReportError(req.FromAccount, "FromAccount", "from_account", "requiredForPayout", "")
+				sl.ReportError(req.FromAccount, "from_account", "FromAccount", "requiredForPayout", "")
 			}
 			if req.ToAccountID != primitive.NilObjectID {
-				sl.ReportError(req.ToAccountID, "ToAccountID", "to_account_id", "shouldBeEmptyForPayout", "")
+				sl.ReportError(req.ToAccountID, "to_account_id", "ToAccountID", "shouldBeEmptyForPayout", "")
 			}
 		}
 	}, TransactionRequest{})
@@ -69,197 +89,77 @@ func ValidateTransactionRequest(request *TransactionRequest) error {
 	return validate.Struct(request)
 }
 
-func (db *DB) CreateTransaction(transactionRequest *TransactionRequest) (*Transaction, error) {
-
-	switch transactionRequest.Type {
-	case Deposit:
-		err := db.MakeDeposit(transactionRequest.Amount, transactionRequest.ToAccountID)
-		if err != nil {
-			return nil, err
-		}
-	case Payout:
-		err := db.MakePayout(transactionRequest.Amount, transactionRequest.FromAccount)
-		if err != nil {
-			return nil, err
-		}
-	case Transfer:
-		err := db.MakeTransfer(transactionRequest.Amount, transactionRequest.FromAccount, transactionRequest.ToAccountID)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, utils.INVALID_TRANSACTION_TYPE
-	}
-
+func NewTransaction(transactionRequest *TransactionRequest) *Transaction {
 	transaction := &Transaction{
 		ID:          primitive.NewObjectID(),
 		Type:        transactionRequest.Type,
 		Amount:      transactionRequest.Amount,
+		Currency:    transactionRequest.Currency,
 		FromAccount: transactionRequest.FromAccount,
 		ToAccount:   transactionRequest.ToAccountID,
 		CreatedAt:   time.Now(),
 	}
-	_, err := db.Db.Collection("transactions").InsertOne(context.TODO(), transaction)
-	if err != nil {
-		return nil, err
+	if transactionRequest.ExchangeRate != "" {
+		transaction.ExchangeRate = transactionRequest.ExchangeRate
+		transaction.ConvertedAmount = transactionRequest.ToAmount
+		transaction.ConvertedCurrency = transactionRequest.ToCurrency
 	}
-	return transaction, nil
+	return transaction
 }
-func (db *DB) MakeDeposit(amount float64, toAccount primitive.ObjectID) error {
-	filter := primitive.M{"_id": toAccount}
-	update := primitive.M{
-		"$inc": primitive.M{"balance": amount},
-	}
 
-	_, err := db.Db.Collection("accounts").UpdateOne(context.TODO(), filter, update)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-func (db *DB) MakePayout(amount float64, fromAccount primitive.ObjectID) error {
-	filter := primitive.M{
-		"_id":     fromAccount,
-		"balance": primitive.M{"$gte": amount},
-	}
+func (db *DB) CreateTransaction(ctx context.Context, transactionRequest *TransactionRequest) (_ *Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateTransaction")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
 
-	update := primitive.M{
-		"$inc": primitive.M{"balance": -amount},
+	transaction := NewTransaction(transactionRequest)
+	entry, err := NewJournalEntry(transaction)
+	if err != nil {
+		return nil, err
 	}
 
-	account := db.Db.Collection("accounts").FindOneAndUpdate(context.TODO(), filter, update)
-	if account.Err() != nil {
-		if errors.Is(account.Err(), mongo.ErrNoDocuments) {
-			return utils.INSUFFICIENT_FUNDS
-		}
-		return account.Err()
-	}
-	return nil
-}
-func (db *DB) MakeTransfer(amount float64, from_aId primitive.ObjectID, to_aId primitive.ObjectID) error {
 	session, err := db.Db.Client().StartSession()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer session.EndSession(context.TODO())
+	defer session.EndSession(ctx)
 
 	callback := func(sessCtx mongo.SessionContext) (interface{}, error) {
-		from_filter := primitive.M{
-			"_id":     from_aId,
-			"balance": primitive.M{"$gte": amount},
-		}
-
-		to_filter := primitive.M{"_id": to_aId}
-
-		from_update := primitive.M{
-			"$inc": primitive.M{"balance": -amount},
-		}
-
-		to_update := primitive.M{
-			"$inc": primitive.M{"balance": amount},
-		}
-
-		fromAccount := db.Db.Collection("accounts").FindOneAndUpdate(sessCtx, from_filter, from_update)
-		if fromAccount.Err() != nil {
-			if errors.Is(fromAccount.Err(), mongo.ErrNoDocuments) {
-				return nil, utils.INSUFFICIENT_FUNDS
-			}
-			return nil, fromAccount.Err()
-		}
-
-		_, err = db.Db.Collection("accounts").UpdateOne(sessCtx, to_filter, to_update)
-		if err != nil {
-			return nil, err
-		}
-
-		return nil, nil
+		return nil, db.insertTransaction(sessCtx, transaction, entry)
 	}
 
-	_, err = session.WithTransaction(context.TODO(), callback)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-func (db *DB) GetTransactionById(tId primitive.ObjectID) (*Transaction, error) {
-	transaction := &Transaction{}
-	err := db.Db.Collection("transactions").FindOne(context.TODO(), primitive.M{"_id": tId}).Decode(transaction)
+	_, err = session.WithTransaction(ctx, callback)
 	if err != nil {
 		return nil, err
 	}
 	return transaction, nil
 }
-func (db *DB) GetTransactionsFromAccount(aId primitive.ObjectID) ([]*Transaction, error) {
-	filter := bson.M{
-		"$or": []bson.M{
-			{"from_account": aId},
-			{"to_account": aId},
-		},
-	}
-	cursor, err := db.Db.Collection("transactions").Find(context.TODO(), filter)
-	if err != nil {
-		return nil, err
-	}
-	defer func(cursor *mongo.Cursor, ctx context.Context) {
-		err := cursor.Close(ctx)
-		if err != nil {
-			return
-		}
-	}(cursor, context.TODO())
-
-	var transactions []*Transaction
-	for cursor.Next(context.Background()) {
-		transaction := &Transaction{}
-		err := cursor.Decode(transaction)
-		if err != nil {
-			return nil, err
-		}
-		transactions = append(transactions, transaction)
+func (db *DB) insertTransaction(sessCtx mongo.SessionContext, transaction *Transaction, entry *JournalEntry) error {
+	if err := db.claimIdempotencyKey(sessCtx, transaction.ID); err != nil {
+		return err
 	}
-
-	if err := cursor.Err(); err != nil {
-		return nil, err
+	if err := db.postJournalEntry(sessCtx, entry); err != nil {
+		return err
 	}
-
-	return transactions, nil
+	_, err := db.Db.Collection("transactions").InsertOne(sessCtx, transaction)
+	return err
 }
-func (db *DB) GetTransactionsFromUser(uId primitive.ObjectID) ([]*Transaction, error) {
-	user, err := db.GetUserById(uId)
-	if err != nil {
-		return nil, err
-	}
-
-	filter := bson.M{
-		"$or": []bson.M{
-			{"from_account": bson.M{"$in": user.Accounts}},
-			{"to_account": bson.M{"$in": user.Accounts}},
-		},
-	}
+func (db *DB) GetTransactionById(ctx context.Context, tId primitive.ObjectID) (_ *Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetTransactionById")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
 
-	cursor, err := db.Db.Collection("transactions").Find(context.TODO(), filter)
+	transaction := &Transaction{}
+	err = db.Db.Collection("transactions").FindOne(ctx, primitive.M{"_id": tId}).Decode(transaction)
 	if err != nil {
-		return nil, err
-	}
-	defer func(cursor *mongo.Cursor, ctx context.Context) {
-		err := cursor.Close(ctx)
-		if err != nil {
-			return
-		}
-	}(cursor, context.TODO())
-
-	var transactions []*Transaction
-	for cursor.Next(context.Background()) {
-		transaction := &Transaction{}
-		err := cursor.Decode(transaction)
-		if err != nil {
-			return nil, err
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.TRANSACTION_NOT_FOUND
 		}
-		transactions = append(transactions, transaction)
-	}
-
-	if err := cursor.Err(); err != nil {
 		return nil, err
 	}
-
-	return transactions, nil
+	return transaction, nil
 }
@@ -0,0 +1,265 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type TransactionSort string
+
+const (
+	SortByCreatedAt TransactionSort = "created_at"
+	SortByAmount    TransactionSort = "amount"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+type TransactionQuery struct {
+	Accounts     []primitive.ObjectID
+	Counterparty *primitive.ObjectID
+	Types        []TransactionType
+	MinAmount    *Money
+	MaxAmount    *Money
+	From         *time.Time
+	To           *time.Time
+	SortBy       TransactionSort
+	Descending   bool
+	Limit        int
+	Cursor       *TransactionCursor
+}
+
+type TransactionCursor struct {
+	Sort      string             `json:"s"`
+	CreatedAt time.Time          `json:"c"`
+	Amount    Money              `json:"a"`
+	ID        primitive.ObjectID `json:"id"`
+	Backward  bool               `json:"b,omitempty"`
+}
+
+type TransactionPage struct {
+	Transactions []*Transaction `json:"transactions"`
+	NextCursor   string         `json:"next_cursor,omitempty"`
+	PrevCursor   string         `json:"prev_cursor,omitempty"`
+}
+
+func (q *TransactionQuery) sortKey() string {
+	if q.Descending {
+		return "-" + string(q.SortBy)
+	}
+	return string(q.SortBy)
+}
+
+func (q *TransactionQuery) newCursor(t *Transaction, backward bool) string {
+	cursor := TransactionCursor{
+		Sort:      q.sortKey(),
+		CreatedAt: t.CreatedAt,
+		Amount:    t.Amount,
+		ID:        t.ID,
+		Backward:  backward,
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q *TransactionQuery) ParseCursor(s string) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return utils.INVALID_CURSOR
+	}
+	cursor := &TransactionCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil || cursor.Sort != q.sortKey() {
+		return utils.INVALID_CURSOR
+	}
+	q.Cursor = cursor
+	return nil
+}
+
+func (q *TransactionQuery) scanDescending() bool {
+	backward := q.Cursor != nil && q.Cursor.Backward
+	return q.Descending != backward
+}
+
+func (q *TransactionQuery) filter() bson.M {
+	accounts := bson.M{"$in": q.Accounts}
+	conditions := []bson.M{}
+	if q.Counterparty == nil {
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"from_account": accounts},
+			{"to_account": accounts},
+		}})
+	} else {
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"from_account": accounts, "to_account": *q.Counterparty},
+			{"to_account": accounts, "from_account": *q.Counterparty},
+		}})
+	}
+	if len(q.Types) > 0 {
+		conditions = append(conditions, bson.M{"type": bson.M{"$in": q.Types}})
+	}
+	amount := bson.M{}
+	if q.MinAmount != nil {
+		amount["$gte"] = *q.MinAmount
+	}
+	if q.MaxAmount != nil {
+		amount["$lte"] = *q.MaxAmount
+	}
+	if len(amount) > 0 {
+		conditions = append(conditions, bson.M{"amount": amount})
+	}
+	createdAt := bson.M{}
+	if q.From != nil {
+		createdAt["$gte"] = *q.From
+	}
+	if q.To != nil {
+		createdAt["$lt"] = *q.To
+	}
+	if len(createdAt) > 0 {
+		conditions = append(conditions, bson.M{"created_at": createdAt})
+	}
+
+	if q.Cursor != nil {
+		op := "$gt"
+		if q.scanDescending() {
+			op = "$lt"
+		}
+		var value interface{} = q.Cursor.CreatedAt
+		if q.SortBy == SortByAmount {
+			value = q.Cursor.Amount
+		}
+		field := string(q.SortBy)
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{field: bson.M{op: value}},
+			{field: value, "_id": bson.M{op: q.Cursor.ID}},
+		}})
+	}
+	return bson.M{"$and": conditions}
+}
+
+func (q *TransactionQuery) matches(t *Transaction) bool {
+	inAccounts := func(id primitive.ObjectID) bool {
+		for _, account := range q.Accounts {
+			if account == id {
+				return true
+			}
+		}
+		return false
+	}
+	if q.Counterparty == nil {
+		if !inAccounts(t.FromAccount) && !inAccounts(t.ToAccount) {
+			return false
+		}
+	} else if !(inAccounts(t.FromAccount) && t.ToAccount == *q.Counterparty) &&
+		!(inAccounts(t.ToAccount) && t.FromAccount == *q.Counterparty) {
+		return false
+	}
+	if len(q.Types) > 0 {
+		found := false
+		for _, transactionType := range q.Types {
+			if t.Type == transactionType {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if (q.MinAmount != nil && t.Amount < *q.MinAmount) || (q.MaxAmount != nil && t.Amount > *q.MaxAmount) {
+		return false
+	}
+	if (q.From != nil && t.CreatedAt.Before(*q.From)) || (q.To != nil && !t.CreatedAt.Before(*q.To)) {
+		return false
+	}
+	if q.Cursor != nil {
+		cursor := &Transaction{ID: q.Cursor.ID, CreatedAt: q.Cursor.CreatedAt, Amount: q.Cursor.Amount}
+		if !q.scanLess(cursor, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *TransactionQuery) compare(a *Transaction, b *Transaction) int {
+	if q.SortBy == SortByAmount {
+		if a.Amount != b.Amount {
+			if a.Amount < b.Amount {
+				return -1
+			}
+			return 1
+		}
+	} else if !a.CreatedAt.Equal(b.CreatedAt) {
+		if a.CreatedAt.Before(b.CreatedAt) {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+func (q *TransactionQuery) scanLess(a *Transaction, b *Transaction) bool {
+	if q.scanDescending() {
+		return q.compare(a, b) > 0
+	}
+	return q.compare(a, b) < 0
+}
+
+func (q *TransactionQuery) page(transactions []*Transaction) *TransactionPage {
+	hasMore := len(transactions) > q.Limit
+	if hasMore {
+		transactions = transactions[:q.Limit]
+	}
+	backward := q.Cursor != nil && q.Cursor.Backward
+	if backward {
+		for i, j := 0, len(transactions)-1; i < j; i, j = i+1, j-1 {
+			transactions[i], transactions[j] = transactions[j], transactions[i]
+		}
+	}
+
+	page := &TransactionPage{Transactions: transactions}
+	if len(transactions) == 0 {
+		return page
+	}
+	if backward || hasMore {
+		page.NextCursor = q.newCursor(transactions[len(transactions)-1], false)
+	}
+	if (backward && hasMore) || (!backward && q.Cursor != nil) {
+		page.PrevCursor = q.newCursor(transactions[0], true)
+	}
+	return page
+}
+
+func (db *DB) FindTransactions(ctx context.Context, query *TransactionQuery) (_ *TransactionPage, err error) {
+	ctx, span := tracing.Start(ctx, "DB.FindTransactions")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	direction := 1
+	if query.scanDescending() {
+		direction = -1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: string(query.SortBy), Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(query.Limit + 1))
+
+	cursor, err := db.Db.Collection("transactions").Find(ctx, query.filter(), opts)
+	if err != nil {
+		return nil, err
+	}
+	transactions := []*Transaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+	return query.page(transactions), nil
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTransactionCursorRoundTrip(t *testing.T) {
+	query := &TransactionQuery{SortBy: SortByAmount, Descending: true}
+	transaction := &Transaction{ID: primitive.NewObjectID(), Amount: 1234, CreatedAt: time.Date(2026, 10, 16, 9, 30, 15, 123000000, time.UTC)}
+	for _, backward := range []bool{false, true} {
+		parsed := &TransactionQuery{SortBy: SortByAmount, Descending: true}
+		if err := parsed.ParseCursor(query.newCursor(transaction, backward)); err != nil {
+			t.Fatal(err)
+		}
+		want := TransactionCursor{Sort: "-amount", CreatedAt: transaction.CreatedAt, Amount: transaction.Amount, ID: transaction.ID, Backward: backward}
+		if !reflect.DeepEqual(*parsed.Cursor, want) {
+			t.Errorf("parsed cursor = %+v, want %+v", *parsed.Cursor, want)
+		}
+	}
+
+	cursor := query.newCursor(transaction, false)
+	for _, tc := range []struct {
+		name   string
+		query  *TransactionQuery
+		cursor string
+	}{
+		{"other sort", &TransactionQuery{SortBy: SortByCreatedAt, Descending: true}, cursor},
+		{"other direction", &TransactionQuery{SortBy: SortByAmount}, cursor},
+		{"not base64", &TransactionQuery{SortBy: SortByAmount, Descending: true}, "not a cursor!"},
+		{"not json", &TransactionQuery{SortBy: SortByAmount, Descending: true}, "bm90IGpzb24"},
+	} {
+		if err := tc.query.ParseCursor(tc.cursor); !errors.Is(err, utils.INVALID_CURSOR) {
+			t.Errorf("%s: error = %v, want %v", tc.name, err, utils.INVALID_CURSOR)
+		}
+	}
+}
+
+func TestTransactionPagination(t *testing.T) {
+	m := NewMemoryStore()
+	account := primitive.NewObjectID()
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 11; i++ {
+		m.transactions = append(m.transactions, &Transaction{
+			ID:        primitive.NewObjectID(),
+			Type:      Deposit,
+			Amount:    Money(100 * (i % 4)),
+			Currency:  "EUR",
+			ToAccount: account,
+			CreatedAt: start.Add(time.Duration(i/3) * time.Hour),
+		})
+	}
+
+	for _, tc := range []struct {
+		sortBy     TransactionSort
+		descending bool
+	}{
+		{SortByCreatedAt, false},
+		{SortByCreatedAt, true},
+		{SortByAmount, false},
+		{SortByAmount, true},
+	} {
+		want := make([]*Transaction, len(m.transactions))
+		copy(want, m.transactions)
+		sort.Slice(want, func(i, j int) bool {
+			a, b := want[i], want[j]
+			if tc.descending {
+				a, b = b, a
+			}
+			if tc.sortBy == SortByAmount && a.Amount != b.Amount {
+				return a.Amount < b.Amount
+			}
+			if tc.sortBy == SortByCreatedAt && !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+			return bytes.Compare(a.ID[:], b.ID[:]) < 0
+		})
+
+		find := func(cursor string) *TransactionPage {
+			query := &TransactionQuery{Accounts: []primitive.ObjectID{account}, SortBy: tc.sortBy, Descending: tc.descending, Limit: 3}
+			if cursor != "" {
+				if err := query.ParseCursor(cursor); err != nil {
+					t.Fatal(err)
+				}
+			}
+			page, err := m.FindTransactions(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return page
+		}
+		ids := func(page *TransactionPage) []primitive.ObjectID {
+			ids := []primitive.ObjectID{}
+			for _, transaction := range page.Transactions {
+				ids = append(ids, transaction.ID)
+			}
+			return ids
+		}
+
+		pages := []*TransactionPage{find("")}
+		if pages[0].PrevCursor != "" {
+			t.Errorf("%s descending=%v: first page has a previous cursor", tc.sortBy, tc.descending)
+		}
+		for pages[len(pages)-1].NextCursor != "" {
+			pages = append(pages, find(pages[len(pages)-1].NextCursor))
+		}
+		var got []primitive.ObjectID
+		for _, page := range pages {
+			got = append(got, ids(page)...)
+		}
+		if !reflect.DeepEqual(got, ids(&TransactionPage{Transactions: want})) {
+			t.Errorf("%s descending=%v: pages do not list every transaction once in order", tc.sortBy, tc.descending)
+		}
+
+		for i := len(pages) - 1; i > 0; i-- {
+			if pages[i].PrevCursor == "" {
+				t.Fatalf("%s descending=%v: page %d has no previous cursor", tc.sortBy, tc.descending, i+1)
+			}
+			prev := find(pages[i].PrevCursor)
+			if !reflect.DeepEqual(ids(prev), ids(pages[i-1])) {
+				t.Errorf("%s descending=%v: previous of page %d differs from page %d", tc.sortBy, tc.descending, i+1, i)
+			}
+			if (prev.PrevCursor == "") != (i == 1) {
+				t.Errorf("%s descending=%v: previous cursor of page %d is %q", tc.sortBy, tc.descending, i, prev.PrevCursor)
+			}
+			if prev.NextCursor == "" {
+				t.Errorf("%s descending=%v: page %d reached backwards has no next cursor", tc.sortBy, tc.descending, i)
+			}
+		}
+	}
+}
@@ -3,21 +3,25 @@ package models
 import (
 	"context"
 	"errors"
-	"github.com/go-playground/validator/v10"
+	"github.com/mathis-k/bank-api/tracing"
 	"github.com/mathis-k/bank-api/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 )
 
 type User struct {
-	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
-	FirstName string               `bson:"first_name" json:"first_name"`
-	LastName  string               `bson:"last_name" json:"last_name"`
-	Email     string               `bson:"email" json:"email"`
-	Password  string               `bson:"password" json:"password"`
-	Accounts  []primitive.ObjectID `bson:"accounts" json:"accounts"`
-	CreatedAt time.Time            `bson:"created_at" json:"created_at"`
+	ID            primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	FirstName     string               `bson:"first_name" json:"first_name"`
+	LastName      string               `bson:"last_name" json:"last_name"`
+	Email         string               `bson:"email" json:"email"`
+	EmailVerified bool                 `bson:"email_verified" json:"email_verified"`
+	Password      string               `bson:"password" json:"-"`
+	Accounts      []primitive.ObjectID `bson:"accounts" json:"accounts"`
+	Role          Role                 `bson:"role" json:"role"`
+	TOTP          *TOTPSettings        `bson:"totp,omitempty" json:"-"`
+	CreatedAt     time.Time            `bson:"created_at" json:"created_at"`
 }
 type UserRequest struct {
 	FirstName string `bson:"first_name" json:"first_name" validate:"required,min=2,max=50"`
@@ -30,21 +34,29 @@ type UserUpdate struct {
 	LastName  string `bson:"last_name" json:"last_name" validate:"omitempty,min=2,max=50"`
 	Email     string `bson:"email" json:"email" validate:"omitempty,email"`
 }
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,nefield=CurrentPassword"`
+}
 type UserLogin struct {
 	Email    string `bson:"email" json:"email" validate:"required,email"`
 	Password string `bson:"password" json:"password" validate:"required,min=8"`
 }
 
 func ValidateUserRequest(request *UserRequest) error {
-	validate := validator.New()
+	validate := newValidator()
 	return validate.Struct(request)
 }
 func ValidateUserUpdate(request *UserUpdate) error {
-	validate := validator.New()
+	validate := newValidator()
 	return validate.Struct(request)
 }
 func ValidateUserLogin(request *UserLogin) error {
-	validate := validator.New()
+	validate := newValidator()
+	return validate.Struct(request)
+}
+func ValidateChangePasswordRequest(request *ChangePasswordRequest) error {
+	validate := newValidator()
 	return validate.Struct(request)
 }
 
@@ -56,36 +68,45 @@ func (u User) HasAccount(aId primitive.ObjectID) bool {
 	}
 	return false
 }
-func (db *DB) AddAccountToUser(uId primitive.ObjectID, aId primitive.ObjectID) error {
-	user, err := db.GetUserById(uId)
+func (db *DB) AddAccountToUser(ctx context.Context, uId primitive.ObjectID, aId primitive.ObjectID) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.AddAccountToUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	result, err := db.Db.Collection("users").UpdateOne(ctx, primitive.M{"_id": uId}, primitive.M{"$addToSet": primitive.M{"accounts": aId}})
 	if err != nil {
 		return err
 	}
-	user.Accounts = append(user.Accounts, aId)
-	_, err = db.Db.Collection("users").UpdateOne(context.TODO(), primitive.M{"_id": user.ID}, primitive.M{"$set": user})
-	if err != nil {
-		return err
+	if result.MatchedCount == 0 {
+		return utils.USER_NOT_FOUND
 	}
 	return nil
 }
-func (db *DB) RemoveAccountFromUser(uId primitive.ObjectID, aId primitive.ObjectID) error {
-	user, err := db.GetUserById(uId)
+func (db *DB) RemoveAccountFromUser(ctx context.Context, uId primitive.ObjectID, aId primitive.ObjectID) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.RemoveAccountFromUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	result, err := db.Db.Collection("users").UpdateOne(ctx, primitive.M{"_id": uId}, primitive.M{"$pull": primitive.M{"accounts": aId}})
 	if err != nil {
 		return err
 	}
-	for i, account := range user.Accounts {
-		if account == aId {
-			user.Accounts = append(user.Accounts[:i], user.Accounts[i+1:]...)
-			break
-		}
-	}
-	_, err = db.Db.Collection("users").UpdateOne(context.TODO(), primitive.M{"_id": user.ID}, primitive.M{"$set": user})
-	if err != nil {
-		return err
+	if result.MatchedCount == 0 {
+		return utils.USER_NOT_FOUND
 	}
 	return nil
 }
-func (db *DB) CreateUser(userRequest *UserRequest) (*User, error) {
+func (db *DB) CreateUser(ctx context.Context, userRequest *UserRequest) (_ *User, err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	password, err := utils.HashPassword(userRequest.Password)
 	if err != nil {
 		return nil, err
@@ -96,10 +117,11 @@ func (db *DB) CreateUser(userRequest *UserRequest) (*User, error) {
 		LastName:  userRequest.LastName,
 		Email:     userRequest.Email,
 		Password:  password,
+		Role:      RoleCustomer,
 		Accounts:  []primitive.ObjectID{},
 		CreatedAt: time.Now(),
 	}
-	_, err = db.Db.Collection("users").InsertOne(context.TODO(), user)
+	_, err = db.Db.Collection("users").InsertOne(ctx, user)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
 			return nil, utils.EMAIL_ALREADY_EXISTS
@@ -108,9 +130,15 @@ func (db *DB) CreateUser(userRequest *UserRequest) (*User, error) {
 	}
 	return user, nil
 }
-func (db *DB) GetUserById(id primitive.ObjectID) (*User, error) {
+func (db *DB) GetUserById(ctx context.Context, id primitive.ObjectID) (_ *User, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetUserById")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	user := &User{}
-	err := db.Db.Collection("users").FindOne(context.TODO(), primitive.M{"_id": id}).Decode(user)
+	err = db.Db.Collection("users").FindOne(ctx, primitive.M{"_id": id}).Decode(user)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, utils.USER_NOT_FOUND
@@ -119,51 +147,137 @@ func (db *DB) GetUserById(id primitive.ObjectID) (*User, error) {
 	}
 	return user, nil
 }
-func (db *DB) GetUserByEmail(email string) (*User, error) {
+func (db *DB) GetUserByEmail(ctx context.Context, email string) (_ *User, err error) {
+	ctx, span := tracing.Start(ctx, "DB.GetUserByEmail")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	user := &User{}
-	err := db.Db.Collection("users").FindOne(context.TODO(), primitive.M{"email": email}).Decode(user)
+	err = db.Db.Collection("users").FindOne(ctx, primitive.M{"email": email}).Decode(user)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
-func (db *DB) UpdateUser(uId primitive.ObjectID, userUpdate *UserUpdate) (*User, error) {
-	user, err := db.GetUserById(uId)
-	if err != nil {
-		return nil, err
-	}
+func (db *DB) UpdateUser(ctx context.Context, uId primitive.ObjectID, userUpdate *UserUpdate) (_ *User, err error) {
+	ctx, span := tracing.Start(ctx, "DB.UpdateUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	set := primitive.M{}
 	if userUpdate.FirstName != "" {
-		user.FirstName = userUpdate.FirstName
+		set["first_name"] = primitive.M{"$literal": userUpdate.FirstName}
 	}
 	if userUpdate.LastName != "" {
-		user.LastName = userUpdate.LastName
+		set["last_name"] = primitive.M{"$literal": userUpdate.LastName}
 	}
 	if userUpdate.Email != "" {
-		user.Email = userUpdate.Email
+		email := primitive.M{"$literal": userUpdate.Email}
+		set["email"] = email
+		set["email_verified"] = primitive.M{"$cond": primitive.A{
+			primitive.M{"$eq": primitive.A{"$email", email}}, "$email_verified", false,
+		}}
+	}
+	if len(set) == 0 {
+		return db.GetUserById(ctx, uId)
 	}
-	updatedUser, err := db.Db.Collection("users").UpdateOne(context.TODO(), primitive.M{"_id": user.ID}, primitive.M{"$set": user})
+
+	user := &User{}
+	err = db.Db.Collection("users").FindOneAndUpdate(ctx, primitive.M{"_id": uId}, primitive.A{primitive.M{"$set": set}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(user)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.USER_NOT_FOUND
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, utils.EMAIL_ALREADY_EXISTS
+		}
 		return nil, err
 	}
-	if updatedUser.MatchedCount == 0 {
-		return nil, utils.USER_NOT_FOUND
-	}
 	return user, nil
 }
-func (db *DB) DeleteUser(uId primitive.ObjectID) error {
-	_, err := db.Db.Collection("users").DeleteOne(context.TODO(), primitive.M{"_id": uId})
+func (db *DB) SetPassword(ctx context.Context, uId primitive.ObjectID, passwordHash string) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.SetPassword")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	result, err := db.Db.Collection("users").UpdateOne(ctx, primitive.M{"_id": uId}, primitive.M{"$set": primitive.M{"password": passwordHash}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.USER_NOT_FOUND
+	}
+	return nil
+}
+func (db *DB) MarkEmailVerified(ctx context.Context, uId primitive.ObjectID, email string) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.MarkEmailVerified")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	result, err := db.Db.Collection("users").UpdateOne(ctx, primitive.M{"_id": uId, "email": email}, primitive.M{"$set": primitive.M{"email_verified": true}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.INVALID_USER_TOKEN
+	}
+	return nil
+}
+func (db *DB) DeleteUser(ctx context.Context, uId primitive.ObjectID) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.DeleteUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("users").DeleteOne(ctx, primitive.M{"_id": uId})
 	if err != nil {
 		return err
 	}
 	return nil
 }
-func (db *DB) LoginUser(userLogin *UserLogin) (*User, error) {
-	user, err := db.GetUserByEmail(userLogin.Email)
+func (db *DB) LoginUser(ctx context.Context, userLogin *UserLogin) (_ *User, err error) {
+	ctx, span := tracing.Start(ctx, "DB.LoginUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	user, err := db.GetUserByEmail(ctx, userLogin.Email)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		utils.CheckDummyPasswordHash(userLogin.Password)
+		return nil, utils.INVALID_CREDENTIALS
+	}
 	if err != nil {
 		return nil, err
 	}
 	if !utils.CheckPasswordHash(userLogin.Password, user.Password) {
 		return nil, utils.INVALID_CREDENTIALS
 	}
+	rehashPassword(ctx, db.SetPassword, user, userLogin.Password)
 	return user, nil
 }
+func rehashPassword(ctx context.Context, setPassword func(ctx context.Context, uId primitive.ObjectID, passwordHash string) error, user *User, password string) {
+	if !utils.PasswordNeedsRehash(user.Password) {
+		return
+	}
+	passwordHash, err := utils.HashPassword(password)
+	if err == nil {
+		err = setPassword(ctx, user.ID, passwordHash)
+	}
+	if err != nil {
+		utils.Logger(ctx).Warn("could not rehash password", "user_id", user.ID.Hex(), "error", err)
+		return
+	}
+	user.Password = passwordHash
+	utils.Logger(ctx).Info("rehashed password with the current settings", "user_id", user.ID.Hex())
+}
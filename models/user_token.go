@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/mathis-k/bank-api/tracing"
+	"github.com/mathis-k/bank-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type UserTokenPurpose string
+
+const (
+	PasswordReset     UserTokenPurpose = "password_reset"
+	EmailVerification UserTokenPurpose = "email_verification"
+)
+
+type UserToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Purpose   UserTokenPurpose   `bson:"purpose"`
+	Email     string             `bson:"email"`
+	TokenHash string             `bson:"token_hash"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func ValidateForgotPasswordRequest(request *ForgotPasswordRequest) error {
+	validate := newValidator()
+	return validate.Struct(request)
+}
+func ValidateResetPasswordRequest(request *ResetPasswordRequest) error {
+	validate := newValidator()
+	return validate.Struct(request)
+}
+func ValidateVerifyEmailRequest(request *VerifyEmailRequest) error {
+	validate := newValidator()
+	return validate.Struct(request)
+}
+
+func NewUserToken(user *User, purpose UserTokenPurpose, ttl time.Duration) (*UserToken, string, error) {
+	token, err := newTokenSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	return &UserToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		TokenHash: HashRefreshToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, token, nil
+}
+
+func (t *UserToken) usable(now time.Time) bool {
+	return t.UsedAt == nil && now.Before(t.ExpiresAt)
+}
+
+func (db *DB) CreateUserToken(ctx context.Context, token *UserToken) (err error) {
+	ctx, span := tracing.Start(ctx, "DB.CreateUserToken")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, err = db.Db.Collection("user_tokens").UpdateMany(ctx,
+		primitive.M{"user_id": token.UserID, "purpose": token.Purpose, "used_at": primitive.M{"$exists": false}},
+		primitive.M{"$set": primitive.M{"used_at": token.CreatedAt}})
+	if err != nil {
+		return err
+	}
+	_, err = db.Db.Collection("user_tokens").InsertOne(ctx, token)
+	return err
+}
+func (db *DB) ConsumeUserToken(ctx context.Context, purpose UserTokenPurpose, tokenHash string) (_ *UserToken, err error) {
+	ctx, span := tracing.Start(ctx, "DB.ConsumeUserToken")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	now := time.Now()
+	filter := primitive.M{
+		"token_hash": tokenHash,
+		"purpose":    purpose,
+		"used_at":    primitive.M{"$exists": false},
+		"expires_at": primitive.M{"$gt": now},
+	}
+	token := &UserToken{}
+	err = db.Db.Collection("user_tokens").FindOneAndUpdate(ctx, filter,
+		primitive.M{"$set": primitive.M{"used_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.INVALID_USER_TOKEN
+		}
+		return nil, err
+	}
+	return token, nil
+}
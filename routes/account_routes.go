@@ -4,16 +4,29 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/mathis-k/bank-api/controllers"
 	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
 )
 
 func RegisterAccountRoutes(router *mux.Router, controllers *controllers.APIServer) {
 	subRouter := router.PathPrefix("/api/accounts").Subrouter()
-	subRouter.Use(middleware.AuthMiddleware)
-	subRouter.HandleFunc("", controllers.GetAccounts).Methods("GET")
-	subRouter.HandleFunc("", controllers.CreateAccount).Methods("POST")
+	subRouter.Use(middleware.Traced(middleware.AuthMiddleware))
+	subRouter.Handle("", scoped(models.ScopeAccountsRead, controllers.GetAccounts)).Methods("GET")
+	subRouter.Handle("", scoped(models.ScopeAccountsWrite, controllers.CreateAccount)).Methods("POST")
 
 	subsubRouter := subRouter.PathPrefix("/{number}").Subrouter()
-	subsubRouter.Use(controllers.Database.CheckAccountPermissionMiddleware)
-	subsubRouter.HandleFunc("", controllers.GetAccountByNumber).Methods("GET")
-	subsubRouter.HandleFunc("", controllers.DeleteAccount).Methods("DELETE")
+	subsubRouter.Use(middleware.Traced(controllers.CheckAccountPermissionMiddleware))
+	subsubRouter.Handle("", scoped(models.ScopeAccountsRead, controllers.GetAccountByNumber)).Methods("GET")
+	subsubRouter.Handle("", scoped(models.ScopeAccountsWrite, controllers.DeleteAccount)).Methods("DELETE")
+	subsubRouter.Handle("/statement", scoped(models.ScopeAccountsRead, controllers.GetAccountStatement)).Methods("GET")
+
+	orderRouter := subsubRouter.PathPrefix("/standing-orders").Subrouter()
+	orderRouter.Handle("", scoped(models.ScopeStandingOrdersRead, controllers.GetStandingOrders)).Methods("GET")
+	orderRouter.Handle("/{id}", scoped(models.ScopeStandingOrdersRead, controllers.GetStandingOrderById)).Methods("GET")
+	orderRouter.Handle("/{id}", scoped(models.ScopeStandingOrdersWrite, controllers.DeleteStandingOrder)).Methods("DELETE")
+	orderRouter.Handle("/{id}/executions", scoped(models.ScopeStandingOrdersRead, controllers.GetStandingOrderExecutions)).Methods("GET")
+
+	verifiedOrderRouter := orderRouter.NewRoute().Subrouter()
+	verifiedOrderRouter.Use(middleware.Traced(controllers.RequireVerifiedEmailMiddleware))
+	verifiedOrderRouter.Handle("", scoped(models.ScopeStandingOrdersWrite, controllers.CreateStandingOrder)).Methods("POST")
+	verifiedOrderRouter.Handle("/{id}", scoped(models.ScopeStandingOrdersWrite, controllers.UpdateStandingOrder)).Methods("PUT")
 }
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/mathis-k/bank-api/controllers"
+	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
+)
+
+func RegisterAdminRoutes(router *mux.Router, controllers *controllers.APIServer) {
+	subRouter := router.PathPrefix("/api/admin").Subrouter()
+	subRouter.Use(middleware.Traced(middleware.AuthMiddleware))
+	subRouter.Use(middleware.Traced(middleware.RequireRole(models.RoleSupport, models.RoleAdmin, models.RoleAuditor)))
+
+	subRouter.Handle("/users", scoped(models.ScopeAdminRead, controllers.AdminSearchUsers)).Methods("GET")
+	subRouter.Handle("/users/{id}", scoped(models.ScopeAdminRead, controllers.AdminGetUser)).Methods("GET")
+	subRouter.Handle("/users/{id}/api-keys", scoped(models.ScopeAdminRead, controllers.AdminGetAPIKeys)).Methods("GET")
+	subRouter.Handle("/transactions/{id}", scoped(models.ScopeAdminRead, controllers.AdminGetTransaction)).Methods("GET")
+
+	accountRouter := subRouter.PathPrefix("/accounts/{number}").Subrouter()
+	accountRouter.Use(middleware.Traced(controllers.AdminAccountMiddleware))
+	accountRouter.Handle("", scoped(models.ScopeAdminRead, controllers.AdminGetAccount)).Methods("GET")
+	accountRouter.Handle("/transactions", scoped(models.ScopeAdminRead, controllers.AdminGetAccountTransactions)).Methods("GET")
+
+	freezeRouter := accountRouter.NewRoute().Subrouter()
+	freezeRouter.Use(middleware.Traced(middleware.RequireRole(models.RoleSupport, models.RoleAdmin)))
+	freezeRouter.Handle("/freeze", scoped(models.ScopeAdminWrite, controllers.AdminFreezeAccount)).Methods("POST")
+	freezeRouter.Handle("/unfreeze", scoped(models.ScopeAdminWrite, controllers.AdminUnfreezeAccount)).Methods("POST")
+
+	reversalRouter := subRouter.NewRoute().Subrouter()
+	reversalRouter.Use(middleware.Traced(middleware.RequireRole(models.RoleSupport, models.RoleAdmin)))
+	reversalRouter.Use(middleware.Traced(controllers.IdempotencyMiddleware))
+	reversalRouter.Handle("/transactions/{id}/reverse", scoped(models.ScopeAdminWrite, controllers.AdminReverseTransaction)).Methods("POST")
+
+	adminRouter := subRouter.NewRoute().Subrouter()
+	adminRouter.Use(middleware.Traced(middleware.RequireRole(models.RoleAdmin)))
+	adminRouter.Handle("/users/{id}/role", scoped(models.ScopeAdminWrite, controllers.AdminSetUserRole)).Methods("PUT")
+	adminRouter.Handle("/users/{id}/api-keys", scoped(models.ScopeAdminWrite, controllers.AdminCreateAPIKey)).Methods("POST")
+	adminRouter.Handle("/users/{id}/api-keys/{keyId}", scoped(models.ScopeAdminWrite, controllers.AdminRevokeAPIKey)).Methods("DELETE")
+
+	auditRouter := subRouter.NewRoute().Subrouter()
+	auditRouter.Use(middleware.Traced(middleware.RequireRole(models.RoleAdmin, models.RoleAuditor)))
+	auditRouter.Handle("/audit-log", scoped(models.ScopeAdminRead, controllers.AdminGetAuditLog)).Methods("GET")
+}
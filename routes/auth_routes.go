@@ -3,10 +3,22 @@ package routes
 import (
 	"github.com/gorilla/mux"
 	"github.com/mathis-k/bank-api/controllers"
+	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
 )
 
 func RegisterAuthRoutes(router *mux.Router, controllers *controllers.APIServer) {
 	subRouter := router.PathPrefix("/api/auth").Subrouter()
-	subRouter.HandleFunc("/register", controllers.RegisterUser).Methods("POST")
-	subRouter.HandleFunc("/login", controllers.LoginUser).Methods("POST")
+	subRouter.Handle("/register", middleware.TracedHandler(controllers.RegisterUser)).Methods("POST")
+	subRouter.Handle("/login", middleware.TracedHandler(controllers.LoginUser)).Methods("POST")
+	subRouter.Handle("/login/totp", middleware.TracedHandler(controllers.LoginTOTP)).Methods("POST")
+	subRouter.Handle("/refresh", middleware.TracedHandler(controllers.RefreshToken)).Methods("POST")
+	subRouter.Handle("/forgot-password", middleware.TracedHandler(controllers.ForgotPassword)).Methods("POST")
+	subRouter.Handle("/reset-password", middleware.TracedHandler(controllers.ResetPassword)).Methods("POST")
+	subRouter.Handle("/verify-email", middleware.TracedHandler(controllers.VerifyEmail)).Methods("POST")
+
+	sessionRouter := subRouter.NewRoute().Subrouter()
+	sessionRouter.Use(middleware.Traced(middleware.AuthMiddleware))
+	sessionRouter.Handle("/logout", scoped(models.ScopeSession, controllers.LogoutUser)).Methods("POST")
+	sessionRouter.Handle("/logout-all", scoped(models.ScopeSession, controllers.LogoutEverywhere)).Methods("POST")
 }
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mathis-k/bank-api/controllers"
+	"github.com/mathis-k/bank-api/models"
+)
+
+// flakyStore cancels the request right after the transaction was committed and can fail to store the response, like
+// a client that hangs up or a MongoDB that becomes unavailable between the two writes.
+type flakyStore struct {
+	*models.MemoryStore
+	cancel       context.CancelFunc
+	failComplete bool
+}
+
+func (s *flakyStore) CreateTransaction(ctx context.Context, transactionRequest *models.TransactionRequest) (*models.Transaction, error) {
+	transaction, err := s.MemoryStore.CreateTransaction(ctx, transactionRequest)
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return transaction, err
+}
+
+func (s *flakyStore) CompleteIdempotencyKey(ctx context.Context, key *models.IdempotencyKey, statusCode int, response []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.failComplete {
+		return errors.New("server selection timeout")
+	}
+	return s.MemoryStore.CompleteIdempotencyKey(ctx, key, statusCode, response)
+}
+
+func TestIdempotentRetryAfterCommit(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-test-secret-test-secret-123")
+
+	store := &flakyStore{MemoryStore: models.NewMemoryStore()}
+	server := controllers.NewAPIServerWithStore(":0", store)
+	mail := &testMailer{}
+	server.Mailer = mail
+	server.IdempotencyKeyLock = time.Millisecond
+	router := NewRouter(server)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	c := &testClient{t: t, url: srv.URL}
+	c.register(mail, "j@example.com")
+	c.login("j@example.com")
+	account := number(c.expect(http.StatusCreated, "POST", "/api/accounts", nil)["account_number"])
+	path := "/api/transactions/account/" + account + "/deposit"
+
+	deposit := func(key string, ctx context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewReader([]byte(`{"amount":"100"}`))).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tc := range []struct {
+		name         string
+		key          string
+		failComplete bool
+	}{
+		{"canceled after commit", "canceled", false},
+		{"response not stored", "unstored", true},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		store.cancel, store.failComplete = cancel, tc.failComplete
+		first := deposit(tc.key, ctx)
+		store.cancel, store.failComplete = nil, false
+		if first.Code != http.StatusCreated {
+			t.Fatalf("%s: first request got %d: %s", tc.name, first.Code, first.Body)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		retry := deposit(tc.key, context.Background())
+		if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+			t.Fatalf("%s: retry got %d replayed=%q: %s", tc.name, retry.Code, retry.Header().Get("Idempotent-Replayed"), retry.Body)
+		}
+		var original, replayed map[string]any
+		_ = json.Unmarshal(first.Body.Bytes(), &original)
+		_ = json.Unmarshal(retry.Body.Bytes(), &replayed)
+		if original["id"] != replayed["id"] {
+			t.Errorf("%s: retry returned transaction %v, want %v", tc.name, replayed["id"], original["id"])
+		}
+	}
+
+	if balance := c.expect(http.StatusOK, "GET", "/api/accounts/"+account, nil)["balance"]; balance != "200.00" {
+		t.Errorf("balance = %v, want 200.00 after two deposits and two retries", balance)
+	}
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/mathis-k/bank-api/controllers"
+	"github.com/mathis-k/bank-api/metrics"
+	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"log/slog"
+	"net/http"
+)
+
+func NewRouter(controllers *controllers.APIServer) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(middleware.RouteTemplate)
+	router.Use(middleware.Traced(middleware.Timeout(controllers.RequestTimeout)))
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.ErrorMessage(w, r, utils.ROUTE_NOT_FOUND)
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.ErrorMessage(w, r, utils.METHOD_NOT_ALLOWED)
+	})
+	router.Handle("/api", middleware.TracedHandler(controllers.HandleStartPage)).Methods(http.MethodGet)
+	if metrics.Enabled() && metrics.ListenAddress() == "" {
+		if metrics.Protected() {
+			router.Handle(metrics.Path(), metrics.NewHandlerFromEnv()).Methods(http.MethodGet)
+		} else {
+			slog.Warn("metrics are not served on the API address without credentials, set METRICS_TOKEN, METRICS_USERNAME or METRICS_ADDRESS")
+		}
+	}
+	router.Handle("/.well-known/jwks.json", middleware.TracedHandler(controllers.GetJWKS)).Methods(http.MethodGet)
+
+	RegisterUserRoutes(router, controllers)
+	RegisterAccountRoutes(router, controllers)
+	RegisterTransactionRoutes(router, controllers)
+	RegisterAuthRoutes(router, controllers)
+	RegisterAdminRoutes(router, controllers)
+	return router
+}
+
+func scoped(scope models.Scope, handler http.HandlerFunc) http.Handler {
+	return middleware.Traced(middleware.RequireScope(scope))(middleware.TracedHandler(handler))
+}
@@ -0,0 +1,345 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mathis-k/bank-api/controllers"
+	"github.com/mathis-k/bank-api/mailer"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+)
+
+const testPassword = "password123"
+
+type testMailer struct {
+	mu       sync.Mutex
+	messages []*mailer.Message
+}
+
+func (m *testMailer) Send(message *mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *testMailer) last(prefix string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	body := m.messages[len(m.messages)-1].Body
+	i := strings.Index(body, prefix)
+	if i < 0 {
+		return ""
+	}
+	return strings.Fields(body[i+len(prefix):])[0]
+}
+
+type testResponse struct {
+	request string
+	body    string
+}
+
+type testClient struct {
+	t         *testing.T
+	url       string
+	token     string
+	responses []testResponse
+}
+
+func (c *testClient) do(method, path string, body any, header ...string) (int, map[string]any) {
+	c.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.responses = append(c.responses, testResponse{request: method + " " + path, body: string(raw)})
+
+	var m map[string]any
+	_ = json.Unmarshal(raw, &m)
+	return resp.StatusCode, m
+}
+
+func (c *testClient) expect(status int, method, path string, body any, header ...string) map[string]any {
+	c.t.Helper()
+	code, m := c.do(method, path, body, header...)
+	if code != status {
+		c.t.Fatalf("%s %s: got %d, want %d: %v", method, path, code, status, m)
+	}
+	return m
+}
+
+func (c *testClient) login(email string) map[string]any {
+	c.t.Helper()
+	c.token = ""
+	m := c.expect(http.StatusOK, "POST", "/api/auth/login", map[string]string{"email": email, "password": testPassword})
+	c.token = m["token"].(string)
+	return m
+}
+
+func (c *testClient) register(mail *testMailer, email string) {
+	c.t.Helper()
+	c.expect(http.StatusCreated, "POST", "/api/auth/register", map[string]string{"first_name": "John", "last_name": "Doe", "email": email, "password": testPassword})
+	c.expect(http.StatusOK, "POST", "/api/auth/verify-email", map[string]string{"token": mail.last("Token: ")})
+}
+
+func number(v any) string {
+	b, _ := json.Marshal(v)
+	return string(bytes.Trim(b, `"`))
+}
+
+func mustParseAccountNumber(t *testing.T, s string) uint64 {
+	t.Helper()
+	accountNumber, err := models.ParseAccountNumber(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accountNumber
+}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	switch key {
+	case "_id", "totp", "key_hash", "token_hash", "pepper":
+		return true
+	}
+	return strings.Contains(key, "password") || strings.Contains(key, "hash") || strings.Contains(key, "pepper")
+}
+
+func findSensitiveKeys(v any, found *[]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if sensitiveKey(key) {
+				*found = append(*found, key)
+			}
+			findSensitiveKeys(value, found)
+		}
+	case []any:
+		for _, value := range v {
+			findSensitiveKeys(value, found)
+		}
+	}
+}
+
+func TestResponsesDoNotLeakSensitiveFields(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-test-secret-test-secret-123")
+	t.Setenv("PASSWORD_PEPPER", "pepper-that-must-not-leak")
+	t.Setenv("ADMIN_EMAIL", "admin@example.com")
+
+	store := models.NewMemoryStore()
+	server := controllers.NewAPIServerWithStore(":0", store)
+	mail := &testMailer{}
+	server.Mailer = mail
+
+	router := NewRouter(server)
+	var mu sync.Mutex
+	hit := map[string]bool{}
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if template, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				mu.Lock()
+				hit[r.Method+" "+template] = true
+				mu.Unlock()
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	c := &testClient{t: t, url: srv.URL}
+
+	c.expect(http.StatusOK, "GET", "/api", nil)
+	c.expect(http.StatusOK, "GET", "/.well-known/jwks.json", nil)
+	c.do("GET", "/metrics", nil)
+
+	c.register(mail, "admin@example.com")
+	c.register(mail, "j@example.com")
+	controllers.BootstrapAdmin(context.Background(), store)
+
+	m := c.login("j@example.com")
+	m = c.expect(http.StatusOK, "POST", "/api/auth/refresh", map[string]string{"refresh_token": m["refresh_token"].(string)})
+	c.token = m["token"].(string)
+
+	user := c.expect(http.StatusOK, "GET", "/api/user", nil)
+	c.expect(http.StatusOK, "PUT", "/api/user", map[string]string{"last_name": "Doey"})
+	c.do("POST", "/api/user/verify-email", nil)
+	c.expect(http.StatusOK, "GET", "/api/user/auth-events", nil)
+
+	a1 := c.expect(http.StatusCreated, "POST", "/api/accounts", nil)
+	a2 := c.expect(http.StatusCreated, "POST", "/api/accounts", nil)
+	a3 := c.expect(http.StatusCreated, "POST", "/api/accounts", nil)
+	n1, n2, n3 := number(a1["account_number"]), number(a2["account_number"]), number(a3["account_number"])
+	c.expect(http.StatusOK, "GET", "/api/accounts", nil)
+	c.expect(http.StatusOK, "GET", "/api/accounts/"+n1, nil)
+	c.expect(http.StatusNoContent, "DELETE", "/api/accounts/"+n3, nil)
+
+	c.expect(http.StatusCreated, "POST", "/api/transactions/account/"+n1+"/deposit", map[string]any{"amount": "500"})
+	c.expect(http.StatusCreated, "POST", "/api/transactions/account/"+n1+"/withdraw", map[string]any{"amount": "5"})
+	transfer := c.expect(http.StatusCreated, "POST", "/api/transactions/account/"+n1+"/transfer", map[string]any{"amount": "10", "to_account": n2})
+	transferID := transfer["id"].(string)
+	c.expect(http.StatusOK, "GET", "/api/transactions", nil)
+	c.expect(http.StatusOK, "GET", "/api/transactions/"+transferID, nil)
+	c.expect(http.StatusOK, "GET", "/api/transactions/account/"+n1, nil)
+	c.expect(http.StatusOK, "GET", "/api/accounts/"+n1+"/statement?format=json", nil)
+
+	orders := "/api/accounts/" + n1 + "/standing-orders"
+	order := c.expect(http.StatusCreated, "POST", orders, map[string]any{"to_account": n2, "amount": "1", "frequency": "daily", "start_date": time.Now().Add(24 * time.Hour)})
+	orderID := order["id"].(string)
+	c.expect(http.StatusOK, "GET", orders, nil)
+	c.expect(http.StatusOK, "GET", orders+"/"+orderID, nil)
+	c.expect(http.StatusOK, "PUT", orders+"/"+orderID, map[string]any{"reference": "rent"})
+	c.expect(http.StatusOK, "GET", orders+"/"+orderID+"/executions", nil)
+	c.expect(http.StatusNoContent, "DELETE", orders+"/"+orderID, nil)
+
+	key := c.expect(http.StatusCreated, "POST", "/api/user/api-keys", map[string]any{"name": "batch", "scopes": []string{"accounts:read"}})
+	c.expect(http.StatusOK, "GET", "/api/user/api-keys", nil)
+	c.expect(http.StatusOK, "DELETE", "/api/user/api-keys/"+key["id"].(string), nil)
+
+	enrollment := c.expect(http.StatusCreated, "POST", "/api/user/totp", nil)
+	secret := enrollment["secret"].(string)
+	recoveryCodes := enrollment["recovery_codes"].([]any)
+	code, err := utils.TOTPCode(secret, utils.TOTPCounter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.expect(http.StatusOK, "POST", "/api/user/totp/verify", map[string]string{"code": code})
+	c.token = ""
+	m = c.expect(http.StatusOK, "POST", "/api/auth/login", map[string]string{"email": "j@example.com", "password": testPassword})
+	m = c.expect(http.StatusOK, "POST", "/api/auth/login/totp", map[string]string{"mfa_token": m["mfa_token"].(string), "code": recoveryCodes[0].(string)})
+	c.token = m["token"].(string)
+	c.expect(http.StatusOK, "DELETE", "/api/user/totp", map[string]string{"code": recoveryCodes[1].(string)})
+
+	c.login("admin@example.com")
+	reason := "?reason=ticket+1234"
+	userID := user["id"].(string)
+	c.expect(http.StatusOK, "GET", "/api/admin/users"+reason, nil)
+	c.expect(http.StatusOK, "GET", "/api/admin/users/"+userID+reason, nil)
+	c.expect(http.StatusOK, "GET", "/api/admin/users/"+userID+"/api-keys"+reason, nil)
+	c.expect(http.StatusOK, "GET", "/api/admin/transactions/"+transferID+reason, nil)
+	c.expect(http.StatusOK, "GET", "/api/admin/accounts/"+n1+reason, nil)
+	c.expect(http.StatusOK, "GET", "/api/admin/accounts/"+n1+"/transactions"+reason, nil)
+	c.expect(http.StatusOK, "POST", "/api/admin/accounts/"+n1+"/freeze", map[string]string{"reason": "fraud case"})
+	c.expect(http.StatusOK, "POST", "/api/admin/accounts/"+n1+"/unfreeze", map[string]string{"reason": "case closed"})
+	c.expect(http.StatusCreated, "POST", "/api/admin/transactions/"+transferID+"/reverse", map[string]string{"reason": "wrong account"})
+	c.expect(http.StatusOK, "PUT", "/api/admin/users/"+userID+"/role", map[string]string{"role": "auditor", "reason": "yearly audit"})
+	adminKey := c.expect(http.StatusCreated, "POST", "/api/admin/users/"+userID+"/api-keys", map[string]any{"name": "ops", "scopes": []string{"accounts:read"}, "reason": "batch job setup"})
+	c.expect(http.StatusOK, "DELETE", "/api/admin/users/"+userID+"/api-keys/"+adminKey["id"].(string), map[string]string{"reason": "job retired"})
+	c.expect(http.StatusOK, "GET", "/api/admin/audit-log"+reason, nil)
+
+	c.token = ""
+	c.expect(http.StatusAccepted, "POST", "/api/auth/forgot-password", map[string]string{"email": "j@example.com"})
+	server.WaitForBackgroundTasks()
+	c.expect(http.StatusOK, "POST", "/api/auth/reset-password", map[string]string{"token": mail.last("Token: "), "password": testPassword + "4"})
+	c.token = c.expect(http.StatusOK, "POST", "/api/auth/login", map[string]string{"email": "j@example.com", "password": testPassword + "4"})["token"].(string)
+	c.expect(http.StatusOK, "PUT", "/api/user/password", map[string]string{"current_password": testPassword + "4", "new_password": testPassword})
+	c.login("j@example.com")
+	c.expect(http.StatusOK, "POST", "/api/auth/logout", nil)
+	c.login("j@example.com")
+	c.expect(http.StatusOK, "POST", "/api/auth/logout-all", nil)
+
+	account, err := store.GetAccountByAccountNumber(context.Background(), mustParseAccountNumber(t, n1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := store.SearchUsers(context.Background(), "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaks := []string{"$argon2", "$2a$", "pepper-that-must-not-leak", account.ID.Hex()}
+	for _, user := range users {
+		leaks = append(leaks, user.Password)
+	}
+	for _, response := range c.responses {
+		for _, leak := range leaks {
+			if strings.Contains(response.body, leak) {
+				t.Errorf("%s leaks %q: %s", response.request, leak, response.body)
+			}
+		}
+		var v any
+		if err := json.Unmarshal([]byte(response.body), &v); err != nil {
+			continue
+		}
+		var found []string
+		findSensitiveKeys(v, &found)
+		if len(found) > 0 {
+			t.Errorf("%s has sensitive fields %v: %s", response.request, found, response.body)
+		}
+	}
+
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if !hit[method+" "+template] {
+				t.Errorf("route %s %s is not covered", method, template)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogoutAllRevokesOnlyEarlierTokens(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-test-secret-test-secret-123")
+
+	server := controllers.NewAPIServerWithStore(":0", models.NewMemoryStore())
+	mail := &testMailer{}
+	server.Mailer = mail
+	srv := httptest.NewServer(NewRouter(server))
+	defer srv.Close()
+
+	c := &testClient{t: t, url: srv.URL}
+	c.register(mail, "j@example.com")
+	for i := 0; i < 10; i++ {
+		c.login("j@example.com")
+		revoked := c.token
+		c.expect(http.StatusOK, "POST", "/api/auth/logout-all", nil)
+		c.expect(http.StatusUnauthorized, "GET", "/api/user", nil)
+
+		c.login("j@example.com")
+		c.expect(http.StatusOK, "GET", "/api/user", nil)
+		c.token = revoked
+		c.expect(http.StatusUnauthorized, "GET", "/api/user", nil)
+	}
+}
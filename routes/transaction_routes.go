@@ -4,18 +4,25 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/mathis-k/bank-api/controllers"
 	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
 )
 
 func RegisterTransactionRoutes(router *mux.Router, controllers *controllers.APIServer) {
 	subRouter := router.PathPrefix("/api/transactions").Subrouter()
-	subRouter.Use(middleware.AuthMiddleware)
-	subRouter.HandleFunc("", controllers.GetTransactions).Methods("GET")
-	subRouter.HandleFunc("/{id}", controllers.GetTransactionById).Methods("GET")
+	subRouter.Use(middleware.Traced(middleware.AuthMiddleware))
+	subRouter.Handle("", scoped(models.ScopeTransactionsRead, controllers.GetTransactions)).Methods("GET")
+	subRouter.Handle("/{id}", scoped(models.ScopeTransactionsRead, controllers.GetTransactionById)).Methods("GET")
 
 	subsubRouter := subRouter.PathPrefix("/account").Subrouter()
-	subsubRouter.Use(controllers.Database.CheckAccountPermissionMiddleware)
-	subsubRouter.HandleFunc("/{number}", controllers.GetTransactionsFromAccount).Methods("GET")
-	subsubRouter.HandleFunc("/{number}/deposit", controllers.DepositToAccount).Methods("POST")
-	subsubRouter.HandleFunc("/{number}/withdraw", controllers.WithdrawFromAccount).Methods("POST")
-	subsubRouter.HandleFunc("/{number}/transfer", controllers.TransferBetweenAccounts).Methods("POST")
+	subsubRouter.Use(middleware.Traced(controllers.CheckAccountPermissionMiddleware))
+	subsubRouter.Handle("/{number}", scoped(models.ScopeTransactionsRead, controllers.GetTransactionsFromAccount)).Methods("GET")
+
+	moneyRouter := subsubRouter.NewRoute().Subrouter()
+	moneyRouter.Use(middleware.Traced(controllers.IdempotencyMiddleware))
+	moneyRouter.Handle("/{number}/deposit", scoped(models.ScopeTransactionsWrite, controllers.DepositToAccount)).Methods("POST")
+
+	verifiedRouter := moneyRouter.NewRoute().Subrouter()
+	verifiedRouter.Use(middleware.Traced(controllers.RequireVerifiedEmailMiddleware))
+	verifiedRouter.Handle("/{number}/withdraw", scoped(models.ScopeTransactionsWrite, controllers.WithdrawFromAccount)).Methods("POST")
+	verifiedRouter.Handle("/{number}/transfer", scoped(models.ScopeTransfersCreate, controllers.TransferBetweenAccounts)).Methods("POST")
 }
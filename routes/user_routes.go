@@ -4,11 +4,21 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/mathis-k/bank-api/controllers"
 	"github.com/mathis-k/bank-api/middleware"
+	"github.com/mathis-k/bank-api/models"
 )
 
 func RegisterUserRoutes(router *mux.Router, controllers *controllers.APIServer) {
 	subRouter := router.PathPrefix("/api/user").Subrouter()
-	subRouter.Use(middleware.AuthMiddleware)
-	subRouter.HandleFunc("", controllers.GetUser).Methods("GET")
-	subRouter.HandleFunc("", controllers.UpdateUser).Methods("PUT")
+	subRouter.Use(middleware.Traced(middleware.AuthMiddleware))
+	subRouter.Handle("", scoped(models.ScopeUserRead, controllers.GetUser)).Methods("GET")
+	subRouter.Handle("", scoped(models.ScopeSession, controllers.UpdateUser)).Methods("PUT")
+	subRouter.Handle("/password", scoped(models.ScopeSession, controllers.ChangePassword)).Methods("PUT")
+	subRouter.Handle("/auth-events", scoped(models.ScopeUserRead, controllers.GetAuthEvents)).Methods("GET")
+	subRouter.Handle("/verify-email", scoped(models.ScopeSession, controllers.ResendVerificationEmail)).Methods("POST")
+	subRouter.Handle("/totp", scoped(models.ScopeSession, controllers.EnrollTOTP)).Methods("POST")
+	subRouter.Handle("/totp/verify", scoped(models.ScopeSession, controllers.ConfirmTOTP)).Methods("POST")
+	subRouter.Handle("/totp", scoped(models.ScopeSession, controllers.DisableTOTP)).Methods("DELETE")
+	subRouter.Handle("/api-keys", scoped(models.ScopeSession, controllers.GetAPIKeys)).Methods("GET")
+	subRouter.Handle("/api-keys", scoped(models.ScopeSession, controllers.CreateAPIKey)).Methods("POST")
+	subRouter.Handle("/api-keys/{keyId}", scoped(models.ScopeSession, controllers.RevokeAPIKey)).Methods("DELETE")
 }
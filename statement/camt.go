@@ -0,0 +1,178 @@
+package statement
+
+import (
+	"encoding/xml"
+	"github.com/mathis-k/bank-api/models"
+	"io"
+	"strconv"
+	"time"
+)
+
+const camt053Namespace = "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"
+
+type camtDocument struct {
+	XMLName   xml.Name      `xml:"Document"`
+	Namespace string        `xml:"xmlns,attr"`
+	GrpHdr    camtGroupHdr  `xml:"BkToCstmrStmt>GrpHdr"`
+	Statement camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtGroupHdr struct {
+	MsgId    string `xml:"MsgId"`
+	CreDtTm  string `xml:"CreDtTm"`
+	MsgPgntn struct {
+		PgNb      int  `xml:"PgNb"`
+		LastPgInd bool `xml:"LastPgInd"`
+	} `xml:"MsgPgntn"`
+}
+
+type camtStatement struct {
+	Id       string        `xml:"Id"`
+	CreDtTm  string        `xml:"CreDtTm"`
+	FrDtTm   string        `xml:"FrToDt>FrDtTm"`
+	ToDtTm   string        `xml:"FrToDt>ToDtTm"`
+	AcctId   string        `xml:"Acct>Id>Othr>Id"`
+	AcctCcy  string        `xml:"Acct>Ccy"`
+	Svcr     string        `xml:"Acct>Svcr>FinInstnId>Othr>Id"`
+	Balances []camtBalance `xml:"Bal"`
+	Summary  camtSummary   `xml:"TxsSummry"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtBalance struct {
+	Code      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	CdtDbtInd string     `xml:"CdtDbtInd"`
+	DtTm      string     `xml:"Dt>DtTm"`
+}
+
+type camtSummary struct {
+	NbOfNtries    int    `xml:"TtlNtries>NbOfNtries"`
+	Sum           string `xml:"TtlNtries>Sum"`
+	TtlNetNtryAmt string `xml:"TtlNtries>TtlNetNtryAmt"`
+	CdtDbtInd     string `xml:"TtlNtries>CdtDbtInd"`
+}
+
+type camtEntry struct {
+	NtryRef     string     `xml:"NtryRef"`
+	Amount      camtAmount `xml:"Amt"`
+	CdtDbtInd   string     `xml:"CdtDbtInd"`
+	RvslInd     bool       `xml:"RvslInd,omitempty"`
+	Sts         string     `xml:"Sts"`
+	BookgDtTm   string     `xml:"BookgDt>DtTm"`
+	ValDtTm     string     `xml:"ValDt>DtTm"`
+	AcctSvcrRef string     `xml:"AcctSvcrRef"`
+	BkTxCd      string     `xml:"BkTxCd>Prtry>Cd"`
+	BkTxIssr    string     `xml:"BkTxCd>Prtry>Issr"`
+	TxDtls      camtTxDtls `xml:"NtryDtls>TxDtls"`
+	AddtlInf    string     `xml:"AddtlNtryInf"`
+}
+
+type camtTxDtls struct {
+	AcctSvcrRef  string `xml:"Refs>AcctSvcrRef"`
+	DebtorAcct   string `xml:"RltdPties>DbtrAcct>Id>Othr>Id,omitempty"`
+	CreditorAcct string `xml:"RltdPties>CdtrAcct>Id>Othr>Id,omitempty"`
+	Ustrd        string `xml:"RmtInf>Ustrd,omitempty"`
+}
+
+func camtTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+func creditDebit(m models.Money) string {
+	if m < 0 {
+		return "DBIT"
+	}
+	return "CRDT"
+}
+
+func camtBalanceOf(code string, amount models.Money, currency models.Currency, at time.Time) camtBalance {
+	return camtBalance{
+		Code:      code,
+		Amount:    camtAmount{Currency: string(currency), Value: absolute(amount).String()},
+		CdtDbtInd: creditDebit(amount),
+		DtTm:      camtTime(at),
+	}
+}
+
+func writeCAMT053(w io.Writer, s *models.Statement) error {
+	currency := string(s.Currency)
+	document := camtDocument{
+		Namespace: camt053Namespace,
+		GrpHdr: camtGroupHdr{
+			MsgId:   s.ID.Hex(),
+			CreDtTm: camtTime(s.CreatedAt),
+		},
+		Statement: camtStatement{
+			Id:      s.ID.Hex(),
+			CreDtTm: camtTime(s.CreatedAt),
+			FrDtTm:  camtTime(s.From),
+			ToDtTm:  camtTime(s.To),
+			AcctId:  strconv.FormatUint(s.AccountNumber, 10),
+			AcctCcy: currency,
+			Svcr:    models.BankCode(),
+			Balances: []camtBalance{
+				camtBalanceOf("OPBD", s.OpeningBalance, s.Currency, s.From),
+				camtBalanceOf("CLBD", s.ClosingBalance, s.Currency, s.To),
+			},
+		},
+	}
+	document.GrpHdr.MsgPgntn.PgNb = 1
+	document.GrpHdr.MsgPgntn.LastPgInd = true
+
+	var sum, net models.Money
+	for _, line := range s.Lines {
+		sum += absolute(line.Amount)
+		net += line.Amount
+
+		details := camtTxDtls{
+			AcctSvcrRef: line.Transaction.ID.Hex(),
+			Ustrd:       line.Transaction.Reason,
+		}
+		if line.Counterparty != 0 {
+			counterparty := strconv.FormatUint(line.Counterparty, 10)
+			if line.Amount < 0 {
+				details.CreditorAcct = counterparty
+			} else {
+				details.DebtorAcct = counterparty
+			}
+		}
+		booked := camtTime(line.Transaction.CreatedAt)
+		document.Statement.Entries = append(document.Statement.Entries, camtEntry{
+			NtryRef:     line.Transaction.ID.Hex(),
+			Amount:      camtAmount{Currency: currency, Value: absolute(line.Amount).String()},
+			CdtDbtInd:   creditDebit(line.Amount),
+			RvslInd:     line.Transaction.Type == models.Reversal,
+			Sts:         "BOOK",
+			BookgDtTm:   booked,
+			ValDtTm:     booked,
+			AcctSvcrRef: line.Transaction.ID.Hex(),
+			BkTxCd:      string(line.Transaction.Type),
+			BkTxIssr:    models.BankCode(),
+			TxDtls:      details,
+			AddtlInf:    "Balance " + line.Balance.String() + " " + currency,
+		})
+	}
+	document.Statement.Summary = camtSummary{
+		NbOfNtries:    len(s.Lines),
+		Sum:           sum.String(),
+		TtlNetNtryAmt: absolute(net).String(),
+		CdtDbtInd:     creditDebit(net),
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,39 @@
+package statement
+
+import (
+	"encoding/csv"
+	"github.com/mathis-k/bank-api/models"
+	"io"
+	"strconv"
+	"time"
+)
+
+func writeCSV(w io.Writer, s *models.Statement) error {
+	writer := csv.NewWriter(w)
+	records := [][]string{
+		{"date", "transaction_id", "type", "description", "counterparty", "amount", "currency", "balance"},
+		{s.From.Format(time.RFC3339), "", "", "Opening balance", "", "", string(s.Currency), s.OpeningBalance.String()},
+	}
+	for _, line := range s.Lines {
+		counterparty := ""
+		if line.Counterparty != 0 {
+			counterparty = strconv.FormatUint(line.Counterparty, 10)
+		}
+		records = append(records, []string{
+			line.Transaction.CreatedAt.Format(time.RFC3339),
+			line.Transaction.ID.Hex(),
+			string(line.Transaction.Type),
+			description(line),
+			counterparty,
+			line.Amount.String(),
+			string(s.Currency),
+			line.Balance.String(),
+		})
+	}
+	records = append(records, []string{s.To.Format(time.RFC3339), "", "", "Closing balance", "", "", string(s.Currency), s.ClosingBalance.String()})
+
+	if err := writer.WriteAll(records); err != nil {
+		return err
+	}
+	return writer.Error()
+}
@@ -0,0 +1,121 @@
+package statement
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mathis-k/bank-api/models"
+	"github.com/mathis-k/bank-api/utils"
+	"io"
+	"mime"
+	"strings"
+)
+
+type Format string
+
+const (
+	JSON    Format = "json"
+	CSV     Format = "csv"
+	OFX     Format = "ofx"
+	CAMT053 Format = "camt053"
+)
+
+var contentTypes = map[Format]string{
+	JSON:    "application/json",
+	CSV:     "text/csv; charset=utf-8",
+	OFX:     "application/x-ofx",
+	CAMT053: "application/xml",
+}
+
+var acceptedMediaTypes = map[string]Format{
+	"application/json":  JSON,
+	"text/csv":          CSV,
+	"application/x-ofx": OFX,
+	"application/ofx":   OFX,
+	"application/xml":   CAMT053,
+	"text/xml":          CAMT053,
+}
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.ReplaceAll(s, ".", "")) {
+	case "json":
+		return JSON, nil
+	case "csv":
+		return CSV, nil
+	case "ofx":
+		return OFX, nil
+	case "camt053", "camt", "xml":
+		return CAMT053, nil
+	}
+	return "", utils.UNSUPPORTED_STATEMENT_FORMAT
+}
+
+func Negotiate(accept string) (Format, error) {
+	if strings.TrimSpace(accept) == "" {
+		return JSON, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		if format, ok := acceptedMediaTypes[mediaType]; ok {
+			return format, nil
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return JSON, nil
+		}
+	}
+	return "", utils.UNSUPPORTED_STATEMENT_FORMAT
+}
+
+func (f Format) ContentType() string {
+	return contentTypes[f]
+}
+
+func (f Format) Filename(s *models.Statement) string {
+	extension := string(f)
+	if f == CAMT053 {
+		extension = "xml"
+	}
+	return fmt.Sprintf("statement-%d-%s-%s.%s", s.AccountNumber, s.From.Format("20060102"), s.To.Format("20060102"), extension)
+}
+
+func Write(w io.Writer, f Format, s *models.Statement) error {
+	switch f {
+	case JSON:
+		return json.NewEncoder(w).Encode(models.NewStatementResponse(s))
+	case CSV:
+		return writeCSV(w, s)
+	case OFX:
+		return writeOFX(w, s)
+	case CAMT053:
+		return writeCAMT053(w, s)
+	}
+	return utils.UNSUPPORTED_STATEMENT_FORMAT
+}
+
+func description(line *models.StatementLine) string {
+	description := string(line.Transaction.Type)
+	if line.Counterparty != 0 {
+		description = fmt.Sprintf("%s %d", description, line.Counterparty)
+	}
+	if line.Transaction.Reason != "" {
+		description = fmt.Sprintf("%s: %s", description, line.Transaction.Reason)
+	}
+	return description
+}
+
+func absolute(m models.Money) models.Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,138 @@
+package statement
+
+import (
+	"encoding/xml"
+	"github.com/mathis-k/bank-api/models"
+	"io"
+	"strconv"
+	"time"
+)
+
+const ofxHeader = `<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>` + "\n"
+
+type ofxDocument struct {
+	XMLName xml.Name             `xml:"OFX"`
+	SignOn  ofxSignOn            `xml:"SIGNONMSGSRSV1>SONRS"`
+	Bank    ofxStatementResponse `xml:"BANKMSGSRSV1>STMTTRNRS"`
+}
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxSignOn struct {
+	Status   ofxStatus `xml:"STATUS"`
+	DtServer string    `xml:"DTSERVER"`
+	Language string    `xml:"LANGUAGE"`
+}
+
+type ofxStatementResponse struct {
+	TrnUID    string       `xml:"TRNUID"`
+	Status    ofxStatus    `xml:"STATUS"`
+	Statement ofxStatement `xml:"STMTRS"`
+}
+
+type ofxStatement struct {
+	CurDef      string          `xml:"CURDEF"`
+	BankID      string          `xml:"BANKACCTFROM>BANKID"`
+	AcctID      string          `xml:"BANKACCTFROM>ACCTID"`
+	AcctType    string          `xml:"BANKACCTFROM>ACCTTYPE"`
+	DtStart     string          `xml:"BANKTRANLIST>DTSTART"`
+	DtEnd       string          `xml:"BANKTRANLIST>DTEND"`
+	Transaction []ofxTrans      `xml:"BANKTRANLIST>STMTTRN"`
+	LedgerBal   ofxBalance      `xml:"LEDGERBAL"`
+	BalList     []ofxExtBalance `xml:"BALLIST>BAL"`
+}
+
+type ofxTrans struct {
+	TrnType  string `xml:"TRNTYPE"`
+	DtPosted string `xml:"DTPOSTED"`
+	TrnAmt   string `xml:"TRNAMT"`
+	FitID    string `xml:"FITID"`
+	Name     string `xml:"NAME,omitempty"`
+	Memo     string `xml:"MEMO,omitempty"`
+}
+
+type ofxBalance struct {
+	BalAmt string `xml:"BALAMT"`
+	DtAsOf string `xml:"DTASOF"`
+}
+
+type ofxExtBalance struct {
+	Name    string `xml:"NAME"`
+	Desc    string `xml:"DESC"`
+	BalType string `xml:"BALTYPE"`
+	Value   string `xml:"VALUE"`
+	DtAsOf  string `xml:"DTASOF"`
+}
+
+func ofxTime(t time.Time) string {
+	return t.UTC().Format("20060102150405.000") + "[0:GMT]"
+}
+
+func ofxTransactionType(line *models.StatementLine) string {
+	switch line.Transaction.Type {
+	case models.Deposit:
+		return "DEP"
+	case models.Payout:
+		return "ATM"
+	case models.Transfer:
+		return "XFER"
+	}
+	if line.Amount < 0 {
+		return "DEBIT"
+	}
+	return "CREDIT"
+}
+
+func writeOFX(w io.Writer, s *models.Statement) error {
+	ok := ofxStatus{Code: 0, Severity: "INFO"}
+	document := ofxDocument{
+		SignOn: ofxSignOn{Status: ok, DtServer: ofxTime(s.CreatedAt), Language: "ENG"},
+		Bank: ofxStatementResponse{
+			TrnUID: s.ID.Hex(),
+			Status: ok,
+			Statement: ofxStatement{
+				CurDef:   string(s.Currency),
+				BankID:   models.BankCode(),
+				AcctID:   strconv.FormatUint(s.AccountNumber, 10),
+				AcctType: "CHECKING",
+				DtStart:  ofxTime(s.From),
+				DtEnd:    ofxTime(s.To),
+				LedgerBal: ofxBalance{
+					BalAmt: s.ClosingBalance.String(),
+					DtAsOf: ofxTime(s.To),
+				},
+				BalList: []ofxExtBalance{{
+					Name:    "Opening balance",
+					Desc:    "Balance at the start of the statement period",
+					BalType: "DOLLAR",
+					Value:   s.OpeningBalance.String(),
+					DtAsOf:  ofxTime(s.From),
+				}},
+			},
+		},
+	}
+	for _, line := range s.Lines {
+		document.Bank.Statement.Transaction = append(document.Bank.Statement.Transaction, ofxTrans{
+			TrnType:  ofxTransactionType(line),
+			DtPosted: ofxTime(line.Transaction.CreatedAt),
+			TrnAmt:   line.Amount.String(),
+			FitID:    line.Transaction.ID.Hex(),
+			Name:     truncate(description(line), 32),
+			Memo:     "Balance " + line.Balance.String(),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header+ofxHeader); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
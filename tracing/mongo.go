@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+type mongoMonitor struct {
+	mu    sync.Mutex
+	spans map[int64]trace.Span
+}
+
+func NewMongoMonitor() *event.CommandMonitor {
+	m := &mongoMonitor{spans: map[int64]trace.Span{}}
+	return &event.CommandMonitor{
+		Started: m.started,
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			m.finished(e.RequestID, "")
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			m.finished(e.RequestID, e.Failure)
+		},
+	}
+}
+
+func (m *mongoMonitor) started(ctx context.Context, e *event.CommandStartedEvent) {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return
+	}
+	collection, ok := e.Command.Lookup(e.CommandName).StringValueOK()
+	if !ok {
+		collection, _ = e.Command.Lookup("collection").StringValueOK()
+	}
+	name := e.CommandName
+	if collection != "" {
+		name += " " + collection
+	}
+	_, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "mongodb"),
+			attribute.String("db.namespace", e.DatabaseName),
+			attribute.String("db.collection.name", collection),
+			attribute.String("db.operation.name", e.CommandName),
+		))
+	m.mu.Lock()
+	m.spans[e.RequestID] = span
+	m.mu.Unlock()
+}
+
+func (m *mongoMonitor) finished(requestID int64, failure string) {
+	m.mu.Lock()
+	span, ok := m.spans[requestID]
+	delete(m.spans, requestID)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	if failure != "" {
+		span.SetStatus(codes.Error, failure)
+	}
+	span.End()
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const AccountNumberLength = 10
+
+func LuhnCheckDigit(payload string) (int, error) {
+	sum := 0
+	double := true
+	for i := len(payload) - 1; i >= 0; i-- {
+		digit := int(payload[i] - '0')
+		if digit < 0 || digit > 9 {
+			return 0, INVALID_ACCOUNT_NUMBER
+		}
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return (10 - sum%10) % 10, nil
+}
+
+func ValidLuhn(number string) bool {
+	if len(number) < 2 {
+		return false
+	}
+	checkDigit, err := LuhnCheckDigit(number[:len(number)-1])
+	if err != nil {
+		return false
+	}
+	return int(number[len(number)-1]-'0') == checkDigit
+}
+
+func GenerateAccountNumber() (uint64, error) {
+	lower := new(big.Int).Exp(big.NewInt(10), big.NewInt(AccountNumberLength-2), nil)
+	span := new(big.Int).Mul(lower, big.NewInt(9))
+	n, err := rand.Int(rand.Reader, span)
+	if err != nil {
+		return 0, err
+	}
+	payload := n.Add(n, lower).String()
+	checkDigit, err := LuhnCheckDigit(payload)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(payload+strconv.Itoa(checkDigit), 10, 64)
+}
+
+func ValidAccountNumber(accountNumber uint64) bool {
+	return ValidLuhn(strconv.FormatUint(accountNumber, 10))
+}
+
+func NormalizeIBAN(iban string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(iban), " ", ""))
+}
+
+func ibanMod97(rearranged string) (int, error) {
+	remainder := 0
+	for _, c := range rearranged {
+		switch {
+		case c >= '0' && c <= '9':
+			remainder = (remainder*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			remainder = (remainder*100 + int(c-'A') + 10) % 97
+		default:
+			return 0, INVALID_IBAN
+		}
+	}
+	return remainder, nil
+}
+
+func NewIBAN(countryCode string, bban string) (string, error) {
+	countryCode = strings.ToUpper(countryCode)
+	bban = NormalizeIBAN(bban)
+	if len(countryCode) != 2 || len(bban) == 0 || len(bban) > 30 {
+		return "", INVALID_IBAN
+	}
+	remainder, err := ibanMod97(bban + countryCode + "00")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%02d%s", countryCode, 98-remainder, bban), nil
+}
+
+func ValidIBAN(iban string) bool {
+	iban = NormalizeIBAN(iban)
+	if len(iban) < 5 || len(iban) > 34 {
+		return false
+	}
+	remainder, err := ibanMod97(iban[4:] + iban[:4])
+	return err == nil && remainder == 1
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestLuhn(t *testing.T) {
+	for _, tc := range []struct {
+		payload    string
+		checkDigit int
+	}{
+		{"7992739871", 3},
+		{"492956130", 8},
+		{"0", 0},
+		{"1", 8},
+		{"000000000", 0},
+		{"999999999", 9},
+	} {
+		checkDigit, err := LuhnCheckDigit(tc.payload)
+		if err != nil || checkDigit != tc.checkDigit {
+			t.Errorf("LuhnCheckDigit(%q) = %d, %v, want %d", tc.payload, checkDigit, err, tc.checkDigit)
+		}
+		if number := tc.payload + strconv.Itoa(tc.checkDigit); !ValidLuhn(number) {
+			t.Errorf("ValidLuhn(%q) = false", number)
+		}
+	}
+	if _, err := LuhnCheckDigit("12a4"); !errors.Is(err, INVALID_ACCOUNT_NUMBER) {
+		t.Errorf("LuhnCheckDigit(\"12a4\") error = %v, want %v", err, INVALID_ACCOUNT_NUMBER)
+	}
+	for _, number := range []string{"", "3", "79927398710", "79927398731", "4929561309", "49295613o8"} {
+		if ValidLuhn(number) {
+			t.Errorf("ValidLuhn(%q) = true", number)
+		}
+	}
+}
+
+func TestGenerateAccountNumber(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		accountNumber, err := GenerateAccountNumber()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := strconv.FormatUint(accountNumber, 10); len(s) != AccountNumberLength || !ValidAccountNumber(accountNumber) {
+			t.Fatalf("GenerateAccountNumber() = %s, want %d digits with a valid check digit", s, AccountNumberLength)
+		}
+	}
+}
+
+func TestIBAN(t *testing.T) {
+	for _, tc := range []struct {
+		countryCode, bban, iban string
+	}{
+		{"DE", "370400440532013000", "DE89370400440532013000"},
+		{"gb", "WEST12345698765432", "GB82WEST12345698765432"},
+		{"NL", "ABNA0417164300", "NL91ABNA0417164300"},
+	} {
+		iban, err := NewIBAN(tc.countryCode, tc.bban)
+		if err != nil || iban != tc.iban {
+			t.Errorf("NewIBAN(%q, %q) = %q, %v, want %q", tc.countryCode, tc.bban, iban, err, tc.iban)
+		}
+	}
+	for _, iban := range []string{"DE89370400440532013000", "GB82 WEST 1234 5698 7654 32", "gb82west12345698765432", " NL91ABNA0417164300 "} {
+		if !ValidIBAN(iban) {
+			t.Errorf("ValidIBAN(%q) = false", iban)
+		}
+	}
+	for _, iban := range []string{"", "DE89", "DE89370400440532013001", "DE98370400440532013000", "GB82-WEST-1234-5698-7654-32", "DE8937040044053201300012345678901234"} {
+		if ValidIBAN(iban) {
+			t.Errorf("ValidIBAN(%q) = true", iban)
+		}
+	}
+	for _, tc := range []struct{ countryCode, bban string }{{"D", "370400440532013000"}, {"DE", ""}, {"DE", "1234567890123456789012345678901"}, {"DE", "3704-0044"}} {
+		if _, err := NewIBAN(tc.countryCode, tc.bban); !errors.Is(err, INVALID_IBAN) {
+			t.Errorf("NewIBAN(%q, %q) error = %v, want %v", tc.countryCode, tc.bban, err, INVALID_IBAN)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"* * * * *", "0 9 * * 1-5", "*/15 8-18 * * *", "0 0 1,15 * *", "30 2 * * 7", "5/10 * * 1-12/3 *"} {
+		if _, err := ParseCron(expr); err != nil {
+			t.Errorf("ParseCron(%q) = %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "1- * * * *"} {
+		if _, err := ParseCron(expr); !errors.Is(err, INVALID_CRON_EXPRESSION) {
+			t.Errorf("ParseCron(%q) error = %v, want %v", expr, err, INVALID_CRON_EXPRESSION)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, tc := range []struct {
+		name  string
+		expr  string
+		after time.Time
+		want  []time.Time
+	}{
+		{"next minute", "* * * * *", time.Date(2026, 10, 16, 9, 0, 30, 0, time.UTC), []time.Time{utc(2026, 10, 16, 9, 1), utc(2026, 10, 16, 9, 2)}},
+		{"weekdays", "0 9 * * 1-5", utc(2026, 10, 16, 9, 0), []time.Time{utc(2026, 10, 19, 9, 0), utc(2026, 10, 20, 9, 0)}},
+		{"sunday as 7", "0 0 * * 7", utc(2026, 10, 16, 0, 0), []time.Time{utc(2026, 10, 18, 0, 0), utc(2026, 10, 25, 0, 0)}},
+		{"day of month or weekday", "0 0 13 * 5", utc(2026, 10, 1, 0, 0), []time.Time{utc(2026, 10, 2, 0, 0), utc(2026, 10, 9, 0, 0), utc(2026, 10, 13, 0, 0)}},
+		{"31st skips short months", "0 9 31 * *", utc(2026, 3, 31, 9, 0), []time.Time{utc(2026, 5, 31, 9, 0), utc(2026, 7, 31, 9, 0), utc(2026, 8, 31, 9, 0)}},
+		{"leap day", "0 0 29 2 *", utc(2026, 3, 1, 0, 0), []time.Time{utc(2028, 2, 29, 0, 0), utc(2032, 2, 29, 0, 0)}},
+		{"year end", "59 23 31 12 *", utc(2026, 12, 31, 23, 58), []time.Time{utc(2026, 12, 31, 23, 59), utc(2027, 12, 31, 23, 59)}},
+		{"never", "0 0 30 2 *", utc(2026, 1, 1, 0, 0), []time.Time{{}}},
+		{"skipped by daylight saving time", "30 2 * * *", time.Date(2026, 3, 28, 3, 0, 0, 0, berlin), []time.Time{
+			time.Date(2026, 3, 29, 3, 30, 0, 0, berlin), time.Date(2026, 3, 30, 2, 30, 0, 0, berlin),
+		}},
+		{"repeated by daylight saving time", "30 2 * * *", time.Date(2026, 10, 24, 3, 0, 0, 0, berlin), []time.Time{
+			time.Date(2026, 10, 25, 2, 30, 0, 0, berlin), time.Date(2026, 10, 26, 2, 30, 0, 0, berlin),
+		}},
+		{"hourly over daylight saving time", "0 * * * *", time.Date(2026, 3, 29, 0, 30, 0, 0, berlin), []time.Time{
+			time.Date(2026, 3, 29, 1, 0, 0, 0, berlin), time.Date(2026, 3, 29, 3, 0, 0, 0, berlin), time.Date(2026, 3, 29, 4, 0, 0, 0, berlin),
+		}},
+	} {
+		schedule, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		after := tc.after
+		for i, want := range tc.want {
+			next := schedule.Next(after)
+			if !next.Equal(want) {
+				t.Errorf("%s: run %d = %v, want %v", tc.name, i+1, next, want)
+				break
+			}
+			after = next
+		}
+	}
+}
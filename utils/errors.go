@@ -1,32 +1,289 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"io"
 	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
 )
 
+const StatusClientClosedRequest = 499
+
 var (
-	DATABASE_NOT_ACTIVVE     = fmt.Errorf("mongoDB connection is not active")
-	INVALID_TOKEN            = fmt.Errorf("invalid token")
-	TOKEN_EXPIRED            = fmt.Errorf("token has expired")
-	INVALID_CLAIMS           = fmt.Errorf("invalid token claims")
-	INVALID_CREDENTIALS      = fmt.Errorf("invalid credentials")
-	MISSING_AUTH_HEADER      = fmt.Errorf("missing authorization header")
-	EMAIL_ALREADY_EXISTS     = fmt.Errorf("email already exists")
-	USER_NOT_FOUND           = fmt.Errorf("user not found")
-	TRANSACTION_NOT_FOUND    = fmt.Errorf("transaction not found")
-	ACCOUNT_NOT_FOUND        = fmt.Errorf("account not found")
-	INVALID_TRANSACTION_TYPE = fmt.Errorf("invalid transaction type")
-	INSUFFICIENT_FUNDS       = fmt.Errorf("insufficient funds")
-	MISSING_TRANSACTION_ID   = fmt.Errorf("missing transaction id")
-	MISSING_ACCOUNT_NUMBER   = fmt.Errorf("missing account number")
+	DATABASE_NOT_ACTIVVE            = newError(http.StatusServiceUnavailable, "database_not_active", "mongoDB connection is not active")
+	INVALID_TOKEN                   = newError(http.StatusUnauthorized, "invalid_token", "invalid token")
+	TOKEN_EXPIRED                   = newError(http.StatusUnauthorized, "token_expired", "token has expired")
+	INVALID_CLAIMS                  = newError(http.StatusUnauthorized, "invalid_claims", "invalid token claims")
+	INVALID_CREDENTIALS             = newError(http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
+	INVALID_CURRENT_PASSWORD        = newError(http.StatusForbidden, "invalid_current_password", "current password is incorrect")
+	MISSING_AUTH_HEADER             = newError(http.StatusUnauthorized, "missing_auth_header", "missing authorization header")
+	EMAIL_ALREADY_EXISTS            = newError(http.StatusConflict, "email_already_exists", "email already exists")
+	USER_NOT_FOUND                  = newError(http.StatusNotFound, "user_not_found", "user not found")
+	TRANSACTION_NOT_FOUND           = newError(http.StatusNotFound, "transaction_not_found", "transaction not found")
+	ACCOUNT_NOT_FOUND               = newError(http.StatusNotFound, "account_not_found", "account not found")
+	INVALID_TRANSACTION_TYPE        = newError(http.StatusBadRequest, "invalid_transaction_type", "invalid transaction type")
+	INSUFFICIENT_FUNDS              = newError(http.StatusUnprocessableEntity, "insufficient_funds", "insufficient funds")
+	MISSING_TRANSACTION_ID          = newError(http.StatusBadRequest, "missing_transaction_id", "missing transaction id")
+	MISSING_ACCOUNT_NUMBER          = newError(http.StatusBadRequest, "missing_account_number", "missing account number")
+	INVALID_AMOUNT                  = newError(http.StatusBadRequest, "invalid_amount", "invalid amount")
+	TRANSACTION_LIMIT_EXCEEDED      = newError(http.StatusBadRequest, "transaction_limit_exceeded", "amount exceeds the maximum amount per transaction")
+	INVALID_AMOUNT_PRECISION        = newError(http.StatusBadRequest, "invalid_amount_precision", "amount has more decimal places than the currency allows")
+	UNSUPPORTED_CURRENCY            = newError(http.StatusBadRequest, "unsupported_currency", "unsupported currency")
+	CURRENCY_MISMATCH               = newError(http.StatusBadRequest, "currency_mismatch", "currency does not match the account currency")
+	INVALID_EXCHANGE_RATE           = newError(http.StatusUnprocessableEntity, "invalid_exchange_rate", "invalid exchange rate")
+	EXCHANGE_RATE_NOT_FOUND         = newError(http.StatusUnprocessableEntity, "exchange_rate_not_found", "no exchange rate available for this currency pair")
+	UNBALANCED_JOURNAL_ENTRY        = newError(http.StatusInternalServerError, "unbalanced_journal_entry", "journal entry postings do not balance")
+	INVALID_IDEMPOTENCY_KEY         = newError(http.StatusBadRequest, "invalid_idempotency_key", "idempotency key must be between 1 and 255 characters")
+	IDEMPOTENCY_KEY_MISMATCH        = newError(http.StatusConflict, "idempotency_key_mismatch", "idempotency key was already used with a different request")
+	IDEMPOTENCY_KEY_IN_USE          = newError(http.StatusConflict, "idempotency_key_in_use", "a request with this idempotency key is still being processed")
+	INVALID_TRANSACTION_ID          = newError(http.StatusBadRequest, "invalid_transaction_id", "invalid transaction id")
+	TRANSACTION_ALREADY_REVERSED    = newError(http.StatusConflict, "transaction_already_reversed", "transaction has already been reversed")
+	CANNOT_REVERSE_REVERSAL         = newError(http.StatusUnprocessableEntity, "cannot_reverse_reversal", "a reversal cannot be reversed")
+	REVERSAL_INSUFFICIENT_FUNDS     = newError(http.StatusUnprocessableEntity, "reversal_insufficient_funds", "the receiving account no longer has enough funds to reverse this transaction")
+	INVALID_CRON_EXPRESSION         = newError(http.StatusBadRequest, "invalid_cron_expression", "invalid cron expression, expected \"minute hour day-of-month month day-of-week\"")
+	INVALID_FREQUENCY               = newError(http.StatusBadRequest, "invalid_frequency", "invalid standing order frequency")
+	STANDING_ORDER_NOT_FOUND        = newError(http.StatusNotFound, "standing_order_not_found", "standing order not found")
+	MISSING_STANDING_ORDER_ID       = newError(http.StatusBadRequest, "missing_standing_order_id", "missing standing order id")
+	INVALID_STANDING_ORDER_ID       = newError(http.StatusBadRequest, "invalid_standing_order_id", "invalid standing order id")
+	STANDING_ORDER_ALREADY_EXECUTED = newError(http.StatusConflict, "standing_order_already_executed", "standing order was already executed for this date")
+	STANDING_ORDER_CHANGED          = newError(http.StatusConflict, "standing_order_changed", "standing order was executed while it was updated, please try again")
+	INVALID_CURSOR                  = newError(http.StatusBadRequest, "invalid_cursor", "invalid or expired cursor")
+	INVALID_QUERY_PARAMETER         = newError(http.StatusBadRequest, "invalid_query_parameter", "invalid query parameter")
+	UNSUPPORTED_STATEMENT_FORMAT    = newError(http.StatusBadRequest, "unsupported_statement_format", "unsupported statement format, expected json, csv, ofx or camt053")
+	INVALID_DATE_RANGE              = newError(http.StatusBadRequest, "invalid_date_range", "invalid date range, from_date has to be before to_date")
+	INVALID_ACCOUNT_NUMBER          = newError(http.StatusBadRequest, "invalid_account_number", "invalid account number, check digit does not match")
+	INVALID_IBAN                    = newError(http.StatusBadRequest, "invalid_iban", "invalid IBAN")
+	ACCOUNT_NUMBER_EXHAUSTED        = newError(http.StatusServiceUnavailable, "account_number_exhausted", "could not generate a unique account number, please try again")
+	TOKEN_REVOKED                   = newError(http.StatusUnauthorized, "token_revoked", "token has been revoked")
+	INVALID_REFRESH_TOKEN           = newError(http.StatusUnauthorized, "invalid_refresh_token", "invalid or expired refresh token")
+	MISSING_REFRESH_TOKEN           = newError(http.StatusBadRequest, "missing_refresh_token", "refresh_token is required")
+	REFRESH_TOKEN_REUSED            = newError(http.StatusUnauthorized, "refresh_token_reused", "refresh token has already been used, please log in again")
+	MISSING_SIGNING_KEY             = newError(http.StatusInternalServerError, "missing_signing_key", "no active JWT signing key")
+	INVALID_SIGNING_KEY             = newError(http.StatusInternalServerError, "invalid_signing_key", "invalid JWT signing key")
+	WEAK_SIGNING_KEY                = newError(http.StatusInternalServerError, "weak_signing_key", "JWT signing key is too weak")
+	TOTP_ALREADY_ENABLED            = newError(http.StatusConflict, "totp_already_enabled", "two-factor authentication is already enabled")
+	TOTP_NOT_ENABLED                = newError(http.StatusConflict, "totp_not_enabled", "two-factor authentication is not enabled")
+	TOTP_NOT_ENROLLED               = newError(http.StatusConflict, "totp_not_enrolled", "no pending two-factor enrollment, start one at POST /api/user/totp")
+	TOTP_REQUIRED                   = newError(http.StatusForbidden, "totp_required", "a two-factor code is required, send it in the X-TOTP-Code header")
+	TOTP_SETUP_REQUIRED             = newError(http.StatusForbidden, "totp_setup_required", "transfers above the two-factor threshold require two-factor authentication, enable it at POST /api/user/totp")
+	INVALID_TOTP_CODE               = newError(http.StatusUnprocessableEntity, "invalid_totp_code", "invalid two-factor code")
+	TOTP_CODE_REUSED                = newError(http.StatusUnprocessableEntity, "totp_code_reused", "two-factor code has already been used, wait for the next one")
+	INVALID_MFA_TOKEN               = newError(http.StatusUnauthorized, "invalid_mfa_token", "invalid or expired two-factor login token")
+	INVALID_USER_TOKEN              = newError(http.StatusBadRequest, "invalid_user_token", "invalid, used or expired token")
+	EMAIL_NOT_VERIFIED              = newError(http.StatusForbidden, "email_not_verified", "email address is not verified, check your inbox or request a new link at POST /api/user/verify-email")
+	EMAIL_ALREADY_VERIFIED          = newError(http.StatusConflict, "email_already_verified", "email address is already verified")
+	TOO_MANY_LOGIN_ATTEMPTS         = newError(http.StatusTooManyRequests, "too_many_login_attempts", "too many failed login attempts, please try again later")
+	TOO_MANY_PASSWORD_RESETS        = newError(http.StatusTooManyRequests, "too_many_password_resets", "too many password reset requests, please try again later")
+	STORAGE_TYPE_SERIALIZED         = newError(http.StatusInternalServerError, "storage_type_serialized", "storage types must be converted to a response type before they are serialized")
+	RESPONSE_ENCODING_FAILED        = newError(http.StatusInternalServerError, "response_encoding_failed", "could not encode response")
+	FORBIDDEN_ROLE                  = newError(http.StatusForbidden, "forbidden_role", "your role is not allowed to access this resource")
+	ACCOUNT_FROZEN                  = newError(http.StatusLocked, "account_frozen", "account is frozen, please contact support")
+	ACCOUNT_ALREADY_FROZEN          = newError(http.StatusConflict, "account_already_frozen", "account is already frozen")
+	ACCOUNT_NOT_FROZEN              = newError(http.StatusConflict, "account_not_frozen", "account is not frozen")
+	MISSING_USER_ID                 = newError(http.StatusBadRequest, "missing_user_id", "missing user id")
+	INVALID_USER_ID                 = newError(http.StatusBadRequest, "invalid_user_id", "invalid user id")
+	CANNOT_CHANGE_OWN_ROLE          = newError(http.StatusConflict, "cannot_change_own_role", "you cannot change your own role")
+	INVALID_API_KEY                 = newError(http.StatusUnauthorized, "invalid_api_key", "invalid, expired or revoked API key")
+	INVALID_API_KEY_REQUEST         = newError(http.StatusBadRequest, "invalid_api_key_request", "invalid API key request")
+	INVALID_API_KEY_ID              = newError(http.StatusBadRequest, "invalid_api_key_id", "invalid API key id")
+	API_KEY_NOT_FOUND               = newError(http.StatusNotFound, "api_key_not_found", "API key not found")
+	API_KEY_IP_NOT_ALLOWED          = newError(http.StatusForbidden, "api_key_ip_not_allowed", "API key is not allowed from this IP address")
+	TOO_MANY_API_KEYS               = newError(http.StatusConflict, "too_many_api_keys", "too many active API keys, revoke an unused one first")
+	MISSING_SCOPE                   = newError(http.StatusForbidden, "missing_scope", "credentials lack the scope required for this resource")
+	VALIDATION_FAILED               = newError(http.StatusBadRequest, "validation_failed", "request validation failed")
+	INVALID_REQUEST_BODY            = newError(http.StatusBadRequest, "invalid_request_body", "request body is not valid JSON")
+	ROUTE_NOT_FOUND                 = newError(http.StatusNotFound, "route_not_found", "no route matches the requested path")
+	METHOD_NOT_ALLOWED              = newError(http.StatusMethodNotAllowed, "method_not_allowed", "method is not allowed for this route")
+	INTERNAL_ERROR                  = newError(http.StatusInternalServerError, "internal_error", "an internal error occurred")
+	INVALID_METRICS_CREDENTIALS     = newError(http.StatusUnauthorized, "invalid_metrics_credentials", "invalid or missing metrics credentials")
+	REQUEST_CANCELED                = newError(StatusClientClosedRequest, "client_closed_request", "the client closed the request before it completed")
+	REQUEST_TIMED_OUT               = newError(http.StatusGatewayTimeout, "request_timeout", "the request did not complete in time")
+	DATABASE_UNAVAILABLE            = newError(http.StatusServiceUnavailable, "database_unavailable", "the database is currently unavailable")
 )
 
-func ErrorMessage(w http.ResponseWriter, code int, error error) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	_, err := w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, error.Error())))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func newError(status int, code string, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Is(target error) bool {
+	apiError, ok := target.(*APIError)
+	return ok && apiError.Code == e.Code
+}
+
+// WithStatus answers an API error with status instead of the sentinel's own status, for errors whose meaning depends
+// on the endpoint, e.g. an invalid two-factor code during login.
+func WithStatus(err error, status int) error {
+	var apiError *APIError
+	if !errors.As(err, &apiError) {
+		return err
+	}
+	return &APIError{Status: status, Code: apiError.Code, Message: apiError.Message}
+}
+
+// ErrorCode returns the stable code of an API error, errors without one are reported as internal errors.
+func ErrorCode(err error) string {
+	var apiError *APIError
+	switch {
+	case errors.As(err, &apiError):
+		return apiError.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return REQUEST_TIMED_OUT.Code
+	default:
+		return INTERNAL_ERROR.Code
+	}
+}
+
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail"`
+	Code   string       `json:"code"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func NewProblem(ctx context.Context, err error) *Problem {
+	var validationErrors validator.ValidationErrors
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var timeError *time.ParseError
+	var apiError *APIError
+	var serverSelectionError topology.ServerSelectionError
+
+	problem := &Problem{Status: http.StatusBadRequest}
+	switch {
+	case errors.Is(err, context.Canceled):
+		problem.Status, problem.Code, problem.Detail = REQUEST_CANCELED.Status, REQUEST_CANCELED.Code, REQUEST_CANCELED.Message
+	case errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err):
+		Logger(ctx).Warn("request timed out", "error", err)
+		problem.Status, problem.Code, problem.Detail = REQUEST_TIMED_OUT.Status, REQUEST_TIMED_OUT.Code, REQUEST_TIMED_OUT.Message
+	case errors.As(err, &serverSelectionError), mongo.IsNetworkError(err):
+		Logger(ctx).Error("database unavailable", "error", err)
+		problem.Status, problem.Code, problem.Detail = DATABASE_UNAVAILABLE.Status, DATABASE_UNAVAILABLE.Code, DATABASE_UNAVAILABLE.Message
+	case errors.As(err, &validationErrors):
+		messages := make([]string, 0, len(validationErrors))
+		for _, fieldError := range validationErrors {
+			message := validationMessage(fieldError)
+			messages = append(messages, message)
+			problem.Errors = append(problem.Errors, FieldError{
+				Field:   fieldError.Field(),
+				Rule:    fieldError.Tag(),
+				Param:   fieldError.Param(),
+				Message: message,
+			})
+		}
+		problem.Code, problem.Detail = VALIDATION_FAILED.Code, VALIDATION_FAILED.Message+": "+strings.Join(messages, "; ")
+	case errors.As(err, &typeError):
+		field := typeError.Field
+		if field == "" {
+			field = "request body"
+		}
+		problem.Code, problem.Detail = INVALID_REQUEST_BODY.Code, fmt.Sprintf("%s has the wrong type, expected %s", field, typeError.Type.Kind())
+		problem.Errors = []FieldError{{Field: field, Rule: "type", Param: typeError.Type.Kind().String(), Message: problem.Detail}}
+	case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+		problem.Code, problem.Detail = INVALID_REQUEST_BODY.Code, INVALID_REQUEST_BODY.Message
+	case errors.As(err, &timeError):
+		problem.Code, problem.Detail = INVALID_REQUEST_BODY.Code, fmt.Sprintf("%s is not a valid RFC 3339 time", timeError.Value)
+	case errors.Is(err, io.EOF):
+		problem.Code, problem.Detail = INVALID_REQUEST_BODY.Code, "request body is empty"
+	case errors.As(err, &apiError):
+		problem.Status = apiError.Status
+		problem.Code, problem.Detail = apiError.Code, err.Error()
+		if problem.Status >= http.StatusInternalServerError {
+			Logger(ctx).Error("internal error", "code", apiError.Code, "error", err)
+			problem.Detail = apiError.Message
+		}
+	default:
+		Logger(ctx).Error("internal error", "error", err)
+		problem.Status = http.StatusInternalServerError
+		problem.Code, problem.Detail = INTERNAL_ERROR.Code, INTERNAL_ERROR.Message
+	}
+	problem.Title = http.StatusText(problem.Status)
+	if problem.Status == StatusClientClosedRequest {
+		problem.Title = "Client Closed Request"
+	}
+	problem.Type = "about:blank"
+	if base := os.Getenv("PROBLEM_TYPE_BASE_URL"); base != "" {
+		problem.Type = strings.TrimSuffix(base, "/") + "/" + problem.Code
+	}
+	return problem
+}
+
+func validationMessage(fieldError validator.FieldError) string {
+	field, param := fieldError.Field(), fieldError.Param()
+	unit := ""
+	switch fieldError.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		unit = " items"
+	}
+	switch fieldError.Tag() {
+	case "required", "requiredForTransfer", "requiredForDeposit", "requiredForPayout":
+		return field + " is required"
+	case "shouldBeEmptyForDeposit", "shouldBeEmptyForPayout":
+		return field + " must be empty"
+	case "email":
+		return field + " must be a valid email address"
+	case "min", "gte":
+		return fmt.Sprintf("%s must be at least %s%s", field, param, unit)
+	case "max", "lte":
+		return fmt.Sprintf("%s must be at most %s%s", field, param, unit)
+	case "len":
+		return fmt.Sprintf("%s must be exactly %s%s", field, param, unit)
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", field, param)
+	case "gtfield":
+		return fmt.Sprintf("%s must be after %s", field, param)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", field, strings.ReplaceAll(param, " ", ", "))
+	case "unique":
+		return field + " must not contain duplicates"
+	case "notInPast":
+		return field + " must not be in the past"
+	case "cron":
+		return field + " must be a cron expression like \"0 9 * * 1-5\""
+	case "accountNumber":
+		return field + " must be a valid account number or IBAN"
+	default:
+		return fmt.Sprintf("%s failed the %s check", field, fieldError.Tag())
+	}
+}
+
+func ErrorMessage(w http.ResponseWriter, r *http.Request, err error) {
+	problem := NewProblem(r.Context(), err)
+	body, encodeErr := json.Marshal(problem)
+	if encodeErr != nil {
+		http.Error(w, http.StatusText(problem.Status), problem.Status)
+		return
 	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	_, _ = w.Write(append(body, '\n'))
 }
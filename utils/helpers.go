@@ -3,8 +3,13 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,11 +27,101 @@ func StringToUint64(s string) (uint64, error) {
 	return i, nil
 }
 
-func ResponseMessage(w http.ResponseWriter, code int, response any) {
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("invalid duration, using fallback", "variable", key, "value", value, "fallback", fallback)
+		return fallback
+	}
+	return d
+}
+
+func GetEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		slog.Warn("invalid number, using fallback", "variable", key, "value", value, "fallback", fallback)
+		return fallback
+	}
+	return i
+}
+
+func ResponseMessage(w http.ResponseWriter, r *http.Request, code int, response any) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		Logger(r.Context()).Error("could not encode response", "type", fmt.Sprintf("%T", response), "error", err)
+		ErrorMessage(w, r, RESPONSE_ENCODING_FAILED)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		ErrorMessage(w, http.StatusInternalServerError, err)
-		return
+	_, _ = w.Write(append(body, '\n'))
+}
+
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxies := trustedProxies()
+	if os.Getenv("TRUST_PROXY_HEADERS") != "true" && !isTrustedProxy(host, proxies) {
+		return host
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(header, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				forwarded = append(forwarded, ip)
+			}
+		}
+	}
+	if len(forwarded) == 0 {
+		return host
+	}
+	for i := len(forwarded) - 1; i > 0; i-- {
+		if !isTrustedProxy(forwarded[i], proxies) {
+			return forwarded[i]
+		}
+	}
+	return forwarded[0]
+}
+
+func trustedProxies() []netip.Prefix {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			slog.Warn("ignoring invalid trusted proxy", "entry", entry)
+		}
+	}
+	return proxies
+}
+
+func isTrustedProxy(ip string, proxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, proxy := range proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const REDACTED = "[REDACTED]"
+
+var sensitiveLogKeys = []string{"password", "token", "secret", "authorization", "api_key", "cookie", "pepper", "recovery", "totp_code"}
+
+var sensitiveLogValues = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), REDACTED},
+	{regexp.MustCompile(`\b(bk_[0-9a-f]{8})_[A-Za-z0-9_-]+`), "${1}_" + REDACTED},
+	{regexp.MustCompile(`(?i)\b(bearer)\s+[^\s"]+`), "${1} " + REDACTED},
+	{regexp.MustCompile(`(://[^/:@\s]+:)[^/@\s]+@`), "${1}" + REDACTED + "@"},
+	{regexp.MustCompile(`\$argon2id\$[^\s"]+`), REDACTED},
+	{regexp.MustCompile(`\$2[aby]\$\d{2}\$[./A-Za-z0-9]{53}`), REDACTED},
+}
+
+func NewLogger(w io.Writer) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+func ConfigureLogging() *slog.Logger {
+	logger := NewLogger(os.Stderr)
+	slog.SetDefault(logger)
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			logger.Warn("invalid LOG_LEVEL, using info", "value", value)
+		}
+	}
+	return logger
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, "logger", logger)
+}
+
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value("logger").(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func Redact(value string) string {
+	for _, sensitive := range sensitiveLogValues {
+		value = sensitive.pattern.ReplaceAllString(value, sensitive.replacement)
+	}
+	return value
+}
+
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, sensitive := range sensitiveLogKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(attr.Key, REDACTED)
+		}
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(attr.Value.String()))
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, Redact(value.Error()))
+		case []byte:
+			return slog.String(attr.Key, Redact(string(value)))
+		}
+	}
+	return attr
+}
@@ -1,18 +1,211 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
 )
 
+const (
+	ARGON2ID           = "argon2id"
+	BCRYPT             = "bcrypt"
+	ARGON2_MEMORY      = 64 * 1024
+	ARGON2_ITERATIONS  = 3
+	ARGON2_PARALLELISM = 2
+	ARGON2_SALT_SIZE   = 16
+	ARGON2_KEY_SIZE    = 32
+)
+
+type PasswordConfig struct {
+	Algorithm   string
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	BcryptCost  int
+	Pepper      []byte
+}
+
+type argon2Hash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	peppered    bool
+	salt        []byte
+	key         []byte
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+
+	argon2Slots     chan struct{}
+	argon2SlotsOnce sync.Once
+)
+
+func PasswordConfigFromEnv() PasswordConfig {
+	config := PasswordConfig{
+		Algorithm:   strings.ToLower(os.Getenv("PASSWORD_HASH_ALGORITHM")),
+		Memory:      uint32(GetEnvInt("ARGON2_MEMORY", ARGON2_MEMORY)),
+		Iterations:  uint32(GetEnvInt("ARGON2_ITERATIONS", ARGON2_ITERATIONS)),
+		Parallelism: uint8(GetEnvInt("ARGON2_PARALLELISM", ARGON2_PARALLELISM)),
+		BcryptCost:  GetEnvInt("BCRYPT_COST", bcrypt.DefaultCost),
+		Pepper:      []byte(os.Getenv("PASSWORD_PEPPER")),
+	}
+	if config.Algorithm != BCRYPT {
+		config.Algorithm = ARGON2ID
+	}
+	return config
+}
+
 func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+	config := PasswordConfigFromEnv()
+	if config.Algorithm == BCRYPT {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), config.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hashedPassword), nil
+	}
+
+	hash := &argon2Hash{
+		memory:      config.Memory,
+		iterations:  config.Iterations,
+		parallelism: config.Parallelism,
+		peppered:    len(config.Pepper) > 0,
+		salt:        make([]byte, ARGON2_SALT_SIZE),
+	}
+	if _, err := rand.Read(hash.salt); err != nil {
 		return "", err
 	}
-	return string(hashedPassword), nil
+	hash.key = hash.derive(password, config.Pepper, ARGON2_KEY_SIZE)
+	return hash.String(), nil
 }
 
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	if !strings.HasPrefix(hash, "$"+ARGON2ID+"$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		return err == nil
+	}
+
+	parsed, err := parseArgon2Hash(hash)
+	if err != nil {
+		slog.Warn("could not parse password hash", "error", err)
+		return false
+	}
+	pepper := PasswordConfigFromEnv().Pepper
+	if parsed.peppered && len(pepper) == 0 {
+		slog.Warn("password hash was created with a pepper, but PASSWORD_PEPPER is not set")
+		return false
+	}
+	key := parsed.derive(password, pepper, uint32(len(parsed.key)))
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1
+}
+
+func PasswordNeedsRehash(hash string) bool {
+	config := PasswordConfigFromEnv()
+	if !strings.HasPrefix(hash, "$"+ARGON2ID+"$") {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err == nil && (config.Algorithm != BCRYPT || cost != config.BcryptCost)
+	}
+
+	parsed, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+	return config.Algorithm != ARGON2ID ||
+		parsed.memory != config.Memory ||
+		parsed.iterations != config.Iterations ||
+		parsed.parallelism != config.Parallelism ||
+		parsed.peppered != (len(config.Pepper) > 0)
+}
+
+func CheckDummyPasswordHash(password string) bool {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword("dummy password for unknown users")
+	})
+	CheckPasswordHash(password, dummyHash)
+	return false
+}
+
+func acquireArgon2Slot() func() {
+	argon2SlotsOnce.Do(func() {
+		argon2Slots = make(chan struct{}, GetEnvInt("ARGON2_CONCURRENCY", runtime.NumCPU()))
+	})
+	argon2Slots <- struct{}{}
+	return func() { <-argon2Slots }
+}
+
+func (h *argon2Hash) derive(password string, pepper []byte, keySize uint32) []byte {
+	release := acquireArgon2Slot()
+	defer release()
+
+	input := []byte(password)
+	if h.peppered {
+		mac := hmac.New(sha256.New, pepper)
+		mac.Write(input)
+		input = mac.Sum(nil)
+	}
+	return argon2.IDKey(input, h.salt, h.iterations, h.memory, h.parallelism, keySize)
+}
+
+func (h *argon2Hash) String() string {
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", h.memory, h.iterations, h.parallelism)
+	if h.peppered {
+		params += ",pepper=1"
+	}
+	return fmt.Sprintf("$%s$v=%d$%s$%s$%s", ARGON2ID, argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(h.salt), base64.RawStdEncoding.EncodeToString(h.key))
+}
+
+func parseArgon2Hash(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != ARGON2ID {
+		return nil, fmt.Errorf("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %s", parts[2])
+	}
+
+	parsed := &argon2Hash{}
+	for _, param := range strings.Split(parts[3], ",") {
+		var err error
+		switch key, value, _ := strings.Cut(param, "="); key {
+		case "m":
+			_, err = fmt.Sscan(value, &parsed.memory)
+		case "t":
+			_, err = fmt.Sscan(value, &parsed.iterations)
+		case "p":
+			_, err = fmt.Sscan(value, &parsed.parallelism)
+		case "pepper":
+			parsed.peppered = value == "1"
+		default:
+			err = fmt.Errorf("unknown parameter %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid argon2id parameters %s: %v", parts[3], err)
+		}
+	}
+
+	var err error
+	if parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if parsed.memory == 0 || parsed.iterations == 0 || parsed.parallelism == 0 || len(parsed.key) == 0 {
+		return nil, fmt.Errorf("invalid argon2id parameters %s", parts[3])
+	}
+	return parsed, nil
 }